@@ -45,10 +45,76 @@ func DecodeToInt64(v interface{}) int64 {
 	return -1
 }
 
+// DecodeToString returns v if it is a string and "" otherwise.
+func DecodeToString(v interface{}) string {
+	s, _ := v.(string)
+
+	return s
+}
+
+// DecodeToBool returns v if it is a bool and fallback otherwise.
+func DecodeToBool(v interface{}, fallback bool) bool {
+	if b, ok := v.(bool); ok {
+		return b
+	}
+
+	return fallback
+}
+
+// DecodeToTable returns v if it is a JSON object and nil otherwise.
+func DecodeToTable(v interface{}) dsapid.Table {
+	switch t := v.(type) {
+	case dsapid.Table:
+		return t
+	case map[string]interface{}:
+		return dsapid.Table(t)
+	}
+
+	return nil
+}
+
+// DecodeToTables returns the JSON objects of the array v.
+func DecodeToTables(v interface{}) (tables []dsapid.Table) {
+	switch a := v.(type) {
+	case []dsapid.Table:
+		return a
+	case []interface{}:
+		for _, item := range a {
+			if t := DecodeToTable(item); t != nil {
+				tables = append(tables, t)
+			}
+		}
+	}
+
+	return tables
+}
+
 func ComputeUrn(manifest *dsapid.ManifestResource) string {
 	return fmt.Sprintf("smartos:smartos:%s:%s", manifest.Name, manifest.Version)
 }
 
+// ComputeFilePath names the file_idx'th file of manifest after its name,
+// version and compression. It returns an empty path for unknown compressions.
+func ComputeFilePath(manifest *dsapid.ManifestResource, file_idx int, compression dsapid.CompressionType) string {
+	ext, ok := dsapid.CompressionTypeExtensionMap[compression]
+	if !ok {
+		return ""
+	}
+
+	var fullName string
+	if file_idx > 0 {
+		fullName = fmt.Sprintf("%s-%s-%d", manifest.Name, manifest.Version, file_idx)
+	} else {
+		fullName = fmt.Sprintf("%s-%s", manifest.Name, manifest.Version)
+	}
+
+	if manifest.Type == dsapid.ManifestTypeZone {
+		return fmt.Sprintf("%s.zfs%s", fullName, ext)
+	}
+
+	return fmt.Sprintf("%s.zvol%s", fullName, ext)
+}
+
 func ParseDateTime(value string) (converted time.Time, err error) {
 	var formats = []string{
 		"2006-01-02T15:04:05.999999999Z",
@@ -0,0 +1,322 @@
+package decoder
+
+import (
+	"fmt"
+	"github.com/MerlinDMC/dsapid"
+	"github.com/MerlinDMC/dsapid/converter"
+	"github.com/pborman/uuid"
+	"regexp"
+	"strings"
+)
+
+const (
+	ProblemMissing string = "Missing"
+	ProblemInvalid string = "Invalid"
+)
+
+var (
+	md5Pattern  = regexp.MustCompile("^[0-9a-f]{32}$")
+	sha1Pattern = regexp.MustCompile("^[0-9a-f]{40}$")
+)
+
+// Problem describes why the value at Field, a path like "files[0].sha1",
+// was rejected.
+type Problem struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type Problems []Problem
+
+func (me Problems) Error() string {
+	messages := make([]string, 0, len(me))
+
+	for _, p := range me {
+		messages = append(messages, fmt.Sprintf("%s: %s", p.Field, p.Message))
+	}
+
+	return "invalid manifest: " + strings.Join(messages, ", ")
+}
+
+type ValidationOptions struct {
+	// AllowNoUuid accepts manifests which get their uuid assigned later
+	AllowNoUuid bool
+	// AllowNoFiles accepts manifests whose files are added later
+	AllowNoFiles bool
+	// Imgapi expects the IMGAPI format which is otherwise detected by "v"
+	Imgapi bool
+}
+
+// ValidateManifest checks a manifest before it is decoded and reports every
+// problem found.
+func ValidateManifest(data dsapid.Table, opts ValidationOptions) Problems {
+	v := &validator{
+		problems: make(Problems, 0),
+	}
+
+	_, imgapi := data["v"]
+
+	if imgapi {
+		v.number(data, "v", true)
+	}
+
+	imgapi = imgapi || opts.Imgapi
+
+	if v.str(data, "uuid", !opts.AllowNoUuid) {
+		v.uuid(data, "uuid")
+	}
+
+	v.str(data, "name", true)
+	v.str(data, "version", true)
+	v.str(data, "os", true)
+
+	// types beyond ManifestTypeDescription, like the docker and other
+	// images of IMGAPI, are stored as they are just like the decoders do
+	v.str(data, "type", true)
+
+	for _, field := range []string{"description", "homepage", "urn", "provider", "creator_name", "nic_driver", "disk_driver", "cpu_type"} {
+		v.str(data, field, false)
+	}
+
+	for _, field := range []string{"owner", "creator_uuid"} {
+		if v.str(data, field, false) {
+			v.uuid(data, field)
+		}
+	}
+
+	if v.str(data, "state", false) {
+		if s := dsapid.ManifestState(data["state"].(string)); dsapid.ManifestStateDescription[s] == "" {
+			v.invalid("state", fmt.Sprintf("unknown state \"%s\"", s))
+		}
+	}
+
+	for _, field := range []string{"published_at", "created_at"} {
+		if v.str(data, field, false) {
+			if _, err := converter.ParseDateTime(data[field].(string)); err != nil {
+				v.invalid(field, "not a date")
+			}
+		}
+	}
+
+	for _, field := range []string{"public", "disabled", "icon"} {
+		if value, ok := data[field]; ok {
+			if _, ok := value.(bool); !ok {
+				v.invalid(field, "not a boolean")
+			}
+		}
+	}
+
+	for _, field := range []string{"requirements", "tags", "options", "builder_info"} {
+		v.object(data, field)
+	}
+
+	for _, field := range []string{"users", "metadata_info"} {
+		v.objects(data, field)
+	}
+
+	for _, field := range []string{"billing_tags", "channels", "acl"} {
+		v.strings(data, field, field == "acl")
+	}
+
+	v.integer(data, "image_size")
+
+	files, ok := data["files"]
+	if !ok {
+		if !opts.AllowNoFiles {
+			v.missing("files")
+		}
+
+		return v.problems
+	}
+
+	items, ok := files.([]interface{})
+	if !ok {
+		v.invalid("files", "not an array")
+
+		return v.problems
+	}
+
+	if len(items) == 0 && !opts.AllowNoFiles {
+		v.invalid("files", "no files")
+	}
+
+	for idx, item := range items {
+		field := fmt.Sprintf("files[%d]", idx)
+
+		file := converter.DecodeToTable(item)
+		if file == nil {
+			v.invalid(field, "not an object")
+			continue
+		}
+
+		v.file(field, file, imgapi)
+	}
+
+	return v.problems
+}
+
+type validator struct {
+	// prefix is the path of the object being checked
+	prefix   string
+	problems Problems
+}
+
+func (me *validator) file(field string, file dsapid.Table, imgapi bool) {
+	v := &validator{
+		prefix: me.prefix + field + ".",
+	}
+
+	if size, ok := file["size"]; !ok {
+		v.missing("size")
+	} else if converter.DecodeToInt64(size) <= 0 {
+		v.invalid("size", "has to be a number greater than 0")
+	}
+
+	if v.str(file, "md5", false) && !md5Pattern.MatchString(file["md5"].(string)) {
+		v.invalid("md5", "not a md5 checksum")
+	}
+
+	if v.str(file, "sha1", false) && !sha1Pattern.MatchString(file["sha1"].(string)) {
+		v.invalid("sha1", "not a sha1 checksum")
+	}
+
+	if imgapi {
+		if v.str(file, "compression", false) {
+			if c := dsapid.CompressionType(file["compression"].(string)); !knownCompression(c) {
+				v.invalid("compression", fmt.Sprintf("unknown compression \"%s\"", c))
+			}
+		}
+	} else {
+		v.str(file, "path", true)
+	}
+
+	me.problems = append(me.problems, v.problems...)
+}
+
+// str reports whether data has a string at field; missing required and
+// non-string values are recorded as problems.
+func (me *validator) str(data dsapid.Table, field string, required bool) bool {
+	value, ok := data[field]
+	if !ok {
+		if required {
+			me.missing(field)
+		}
+
+		return false
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		me.invalid(field, "not a string")
+
+		return false
+	}
+
+	if required && s == "" {
+		me.missing(field)
+
+		return false
+	}
+
+	return true
+}
+
+func (me *validator) uuid(data dsapid.Table, field string) {
+	if uuid.Parse(data[field].(string)) == nil {
+		me.invalid(field, "not a uuid")
+	}
+}
+
+func (me *validator) number(data dsapid.Table, field string, required bool) {
+	value, ok := data[field]
+	if !ok {
+		if required {
+			me.missing(field)
+		}
+
+		return
+	}
+
+	if _, ok := value.(float64); !ok {
+		me.invalid(field, "not a number")
+	}
+}
+
+// integer accepts the numbers and numeric strings converter.DecodeToInt64
+// understands.
+func (me *validator) integer(data dsapid.Table, field string) {
+	if value, ok := data[field]; ok && converter.DecodeToInt64(value) < 0 {
+		me.invalid(field, "not a number")
+	}
+}
+
+func (me *validator) object(data dsapid.Table, field string) {
+	if value, ok := data[field]; ok && converter.DecodeToTable(value) == nil {
+		me.invalid(field, "not an object")
+	}
+}
+
+func (me *validator) objects(data dsapid.Table, field string) {
+	value, ok := data[field]
+	if !ok {
+		return
+	}
+
+	items, ok := value.([]interface{})
+	if !ok {
+		me.invalid(field, "not an array")
+
+		return
+	}
+
+	for idx, item := range items {
+		if converter.DecodeToTable(item) == nil {
+			me.invalid(fmt.Sprintf("%s[%d]", field, idx), "not an object")
+		}
+	}
+}
+
+func (me *validator) strings(data dsapid.Table, field string, uuids bool) {
+	value, ok := data[field]
+	if !ok {
+		return
+	}
+
+	items, ok := value.([]interface{})
+	if !ok {
+		me.invalid(field, "not an array")
+
+		return
+	}
+
+	for idx, item := range items {
+		if s, ok := item.(string); !ok {
+			me.invalid(fmt.Sprintf("%s[%d]", field, idx), "not a string")
+		} else if uuids && uuid.Parse(s) == nil {
+			me.invalid(fmt.Sprintf("%s[%d]", field, idx), "not a uuid")
+		}
+	}
+}
+
+func (me *validator) missing(field string) {
+	me.problems = append(me.problems, Problem{
+		Field:   me.prefix + field,
+		Code:    ProblemMissing,
+		Message: "is required",
+	})
+}
+
+func (me *validator) invalid(field string, message string) {
+	me.problems = append(me.problems, Problem{
+		Field:   me.prefix + field,
+		Code:    ProblemInvalid,
+		Message: message,
+	})
+}
+
+func knownCompression(compression dsapid.CompressionType) bool {
+	_, ok := dsapid.CompressionTypeExtensionMap[compression]
+
+	return ok
+}
@@ -0,0 +1,77 @@
+package decoder
+
+import (
+	"encoding/json"
+	"github.com/MerlinDMC/dsapid"
+	"testing"
+)
+
+func decodeTable(t *testing.T, s string) (data dsapid.Table) {
+	if err := json.Unmarshal([]byte(s), &data); err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func TestValidateManifest(t *testing.T) {
+	valid := map[string]string{
+		"dsapi": `{
+			"uuid": "f9e4be48-9466-11e1-bc41-9f993f5dff36",
+			"name": "base", "version": "1.6.3", "os": "smartos", "type": "zone-dataset",
+			"creator_uuid": "352971aa-31ba-496c-9ade-a379feaecd52",
+			"published_at": "2012-05-02T15:15:24Z",
+			"files": [{"path": "base-1.6.3.zfs.bz2", "size": 46243688, "sha1": "fd4b8b5a3ddc8e1b4e1a0b7d5c0f5fbd1d2e3f40"}]
+		}`,
+		"imgapi": `{
+			"v": 2, "uuid": "f9e4be48-9466-11e1-bc41-9f993f5dff36",
+			"name": "base", "version": "1.6.3", "os": "smartos", "type": "zvol", "state": "active",
+			"acl": ["352971aa-31ba-496c-9ade-a379feaecd52"],
+			"files": [{"compression": "gzip", "size": 1024, "md5": "d41d8cd98f00b204e9800998ecf8427e"}]
+		}`,
+	}
+
+	for format, s := range valid {
+		if problems := ValidateManifest(decodeTable(t, s), ValidationOptions{}); len(problems) > 0 {
+			t.Errorf("%s manifest should be valid: %s", format, problems)
+		}
+	}
+
+	problems := ValidateManifest(decodeTable(t, `{
+		"v": 2, "uuid": "not-a-uuid", "name": 42, "os": "smartos", "type": ["docker"],
+		"public": "yes", "acl": ["nobody"], "image_size": "large",
+		"files": [{"compression": "zip", "size": 0, "sha1": "XYZ", "md5": 1}, "file"]
+	}`), ValidationOptions{})
+
+	expected := map[string]string{
+		"uuid":                 ProblemInvalid,
+		"name":                 ProblemInvalid,
+		"version":              ProblemMissing,
+		"type":                 ProblemInvalid,
+		"public":               ProblemInvalid,
+		"acl[0]":               ProblemInvalid,
+		"image_size":           ProblemInvalid,
+		"files[0].size":        ProblemInvalid,
+		"files[0].sha1":        ProblemInvalid,
+		"files[0].md5":         ProblemInvalid,
+		"files[0].compression": ProblemInvalid,
+		"files[1]":             ProblemInvalid,
+	}
+
+	if len(problems) != len(expected) {
+		t.Errorf("expected %d problems but got %d: %s", len(expected), len(problems), problems)
+	}
+
+	for _, p := range problems {
+		if code, ok := expected[p.Field]; !ok || code != p.Code {
+			t.Errorf("unexpected problem %s %s: %s", p.Field, p.Code, p.Message)
+		}
+	}
+
+	if problems := ValidateManifest(decodeTable(t, `{"name": "base", "version": "1.0", "os": "linux", "type": "docker"}`), ValidationOptions{
+		AllowNoUuid:  true,
+		AllowNoFiles: true,
+	}); len(problems) > 0 {
+		t.Errorf("uuid and files should be optional and any type accepted: %s", problems)
+	}
+}
@@ -39,14 +39,14 @@ func (me *dsapiDecoder) Decode(data dsapid.Table) *dsapid.ManifestResource {
 	var creator_uuid string = dsapid.DefaultUserUuid
 	var creator_name string = dsapid.DefaultUserName
 
-	if v, ok := data["creator_uuid"]; ok && v.(string) != "" {
-		creator_uuid = v.(string)
+	if v, ok := data["creator_uuid"]; ok && converter.DecodeToString(v) != "" {
+		creator_uuid = converter.DecodeToString(v)
 		// } else {
 		// 	logger.Warn("manifest has no creator_uuid")
 	}
 
-	if v, ok := data["creator_name"]; ok && v.(string) != "" {
-		creator_name = v.(string)
+	if v, ok := data["creator_name"]; ok && converter.DecodeToString(v) != "" {
+		creator_name = converter.DecodeToString(v)
 		// } else {
 		// 	logger.Warn("manifest has no creator_name")
 	}
@@ -56,7 +56,7 @@ func (me *dsapiDecoder) Decode(data dsapid.Table) *dsapid.ManifestResource {
 	manifest.Owner = user.Uuid
 
 	if v, ok := data["provider"]; ok {
-		manifest.Provider = dsapid.SyncProvider(v.(string))
+		manifest.Provider = dsapid.SyncProvider(converter.DecodeToString(v))
 	} else {
 		if user.Provider != "" {
 			manifest.Provider = user.Provider
@@ -66,54 +66,43 @@ func (me *dsapiDecoder) Decode(data dsapid.Table) *dsapid.ManifestResource {
 	}
 
 	if v, ok := data["uuid"]; ok {
-		manifest.Uuid = v.(string)
+		manifest.Uuid = converter.DecodeToString(v)
 	}
 
 	if v, ok := data["name"]; ok {
-		manifest.Name = v.(string)
+		manifest.Name = converter.DecodeToString(v)
 	}
 
 	if v, ok := data["version"]; ok {
-		manifest.Version = v.(string)
+		manifest.Version = converter.DecodeToString(v)
 	}
 
 	if v, ok := data["description"]; ok {
-		manifest.Description = v.(string)
+		manifest.Description = converter.DecodeToString(v)
 	}
 
 	if v, ok := data["type"]; ok {
-		manifest.Type = dsapid.ManifestType(v.(string))
+		manifest.Type = dsapid.ManifestType(converter.DecodeToString(v))
 	}
 
 	if v, ok := data["os"]; ok {
-		manifest.Os = v.(string)
+		manifest.Os = converter.DecodeToString(v)
 	}
 
 	if v, ok := data["requirements"]; ok {
-		manifest.Requirements = dsapid.Table(v.(map[string]interface{}))
+		manifest.Requirements = converter.DecodeToTable(v)
 	}
 
 	if v, ok := data["tags"]; ok {
-		manifest.Tags = dsapid.Table(v.(map[string]interface{}))
+		manifest.Tags = converter.DecodeToTable(v)
 	}
 
 	if v, ok := data["users"]; ok {
-		for _, u := range v.([]interface{}) {
-			manifest.Users = append(manifest.Users, dsapid.Table(u.(map[string]interface{})))
-		}
+		manifest.Users = append(manifest.Users, converter.DecodeToTables(v)...)
 	}
 
-	if v, ok := data["public"]; ok {
-		manifest.Public = v.(bool)
-	} else {
-		manifest.Public = true
-	}
-
-	if v, ok := data["disabled"]; ok {
-		manifest.Disabled = v.(bool)
-	} else {
-		manifest.Disabled = false
-	}
+	manifest.Public = converter.DecodeToBool(data["public"], true)
+	manifest.Disabled = converter.DecodeToBool(data["disabled"], false)
 
 	if manifest.Disabled {
 		manifest.State = dsapid.ManifestStateDisabled
@@ -122,30 +111,30 @@ func (me *dsapiDecoder) Decode(data dsapid.Table) *dsapid.ManifestResource {
 	}
 
 	if v, ok := data["homepage"]; ok {
-		manifest.Homepage = v.(string)
+		manifest.Homepage = converter.DecodeToString(v)
 	}
 
 	if v, ok := data["urn"]; ok {
-		manifest.Urn = v.(string)
+		manifest.Urn = converter.DecodeToString(v)
 	} else {
 		manifest.Urn = converter.ComputeUrn(manifest)
 	}
 
-	if v, ok := data["options"]; ok {
-		manifest.Options = dsapid.Table(v.(map[string]interface{}))
+	if v := converter.DecodeToTable(data["options"]); v != nil {
+		manifest.Options = v
 	}
 
 	if manifest.Type == dsapid.ManifestTypeZvol {
 		if v, ok := data["nic_driver"]; ok {
-			manifest.Options["nic_driver"] = v.(string)
+			manifest.Options["nic_driver"] = converter.DecodeToString(v)
 		}
 
 		if v, ok := data["disk_driver"]; ok {
-			manifest.Options["disk_driver"] = v.(string)
+			manifest.Options["disk_driver"] = converter.DecodeToString(v)
 		}
 
 		if v, ok := data["cpu_type"]; ok {
-			manifest.Options["cpu_type"] = v.(string)
+			manifest.Options["cpu_type"] = converter.DecodeToString(v)
 		}
 
 		if v, ok := data["image_size"]; ok {
@@ -154,13 +143,13 @@ func (me *dsapiDecoder) Decode(data dsapid.Table) *dsapid.ManifestResource {
 	}
 
 	if v, ok := data["published_at"]; ok {
-		if dt, err := converter.ParseDateTime(v.(string)); err == nil {
+		if dt, err := converter.ParseDateTime(converter.DecodeToString(v)); err == nil {
 			manifest.PublishedAt = dt
 		}
 	}
 
 	if v, ok := data["created_at"]; ok {
-		if dt, err := converter.ParseDateTime(v.(string)); err == nil {
+		if dt, err := converter.ParseDateTime(converter.DecodeToString(v)); err == nil {
 			manifest.CreatedAt = dt
 		} else {
 			manifest.CreatedAt = manifest.PublishedAt
@@ -177,15 +166,15 @@ func (me *dsapiDecoder) Decode(data dsapid.Table) *dsapid.ManifestResource {
 		}
 
 		if v, ok := data["path"]; ok {
-			file.Path = v.(string)
+			file.Path = converter.DecodeToString(v)
 		}
 
 		if v, ok := data["md5"]; ok {
-			file.Md5 = v.(string)
+			file.Md5 = converter.DecodeToString(v)
 		}
 
 		if v, ok := data["sha1"]; ok {
-			file.Sha1 = v.(string)
+			file.Sha1 = converter.DecodeToString(v)
 		}
 
 		if ext := path.Ext(file.Path); ext != "" {
@@ -198,20 +187,18 @@ func (me *dsapiDecoder) Decode(data dsapid.Table) *dsapid.ManifestResource {
 	}
 
 	if v, ok := data["files"]; ok {
-		for _, u := range v.([]interface{}) {
-			manifest.Files = append(manifest.Files, decode_file(dsapid.Table(u.(map[string]interface{}))))
+		for _, u := range converter.DecodeToTables(v) {
+			manifest.Files = append(manifest.Files, decode_file(u))
 		}
 	}
 
 	// internal extra stuff which might only be accessible if we sync from another instance of ourselve
-	if v, ok := data["builder_info"]; ok {
-		manifest.BuilderInfo = dsapid.Table(v.(map[string]interface{}))
+	if v := converter.DecodeToTable(data["builder_info"]); v != nil {
+		manifest.BuilderInfo = v
 	}
 
 	if v, ok := data["metadata_info"]; ok {
-		for _, m := range v.([]interface{}) {
-			manifest.MetadataInfo = append(manifest.MetadataInfo, dsapid.Table(m.(map[string]interface{})))
-		}
+		manifest.MetadataInfo = append(manifest.MetadataInfo, converter.DecodeToTables(v)...)
 	}
 
 	return manifest
@@ -0,0 +1,48 @@
+package dsapi
+
+import (
+	"encoding/json"
+	"github.com/MerlinDMC/dsapid"
+	"github.com/MerlinDMC/dsapid/storage"
+	"testing"
+)
+
+// malformedManifests carry every field with a type the decoder doesn't
+// expect. Decode has to cope with them as it also runs on unvalidated data.
+var malformedManifests = []string{
+	`{}`,
+	`{"uuid": 1, "name": [], "version": {}, "type": "zvol", "os": true, "owner": 42, "creator_uuid": 42, "creator_name": []}`,
+	`{"type": "zvol", "image_size": {}, "nic_driver": 1, "disk_driver": [], "cpu_type": null}`,
+	`{"files": "file", "requirements": [], "tags": "tag", "users": {}, "metadata_info": 1, "builder_info": []}`,
+	`{"files": [1, "file", null, [], {"size": "big", "md5": 1, "sha1": [], "path": {}, "compression": 2}]}`,
+	`{"published_at": 1, "created_at": [], "public": "yes", "disabled": 0, "icon": "no", "state": 1}`,
+	`{"acl": "all", "channels": [1, null], "billing_tags": {}, "users": [1, "user", {"name": 1}], "options": "none"}`,
+	`{"files": null, "users": null, "acl": null, "requirements": null, "published_at": null}`,
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	users, _ := storage.NewUserStorage("")
+
+	decoder, err := NewDecoder(dsapid.SyncProviderCommunity, users)
+	if err != nil {
+		t.Fatalf("can't create decoder: %s", err)
+	}
+
+	for _, s := range malformedManifests {
+		var data dsapid.Table
+
+		if err := json.Unmarshal([]byte(s), &data); err != nil {
+			t.Fatal(err)
+		}
+
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("decoding %s panicked: %v", s, r)
+				}
+			}()
+
+			decoder.Decode(data)
+		}()
+	}
+}
@@ -50,7 +50,7 @@ func (me *dsapiEncoder) Encode(manifest *dsapid.ManifestResource) interface{} {
 
 	if manifest.Type == dsapid.ManifestTypeZvol {
 		if v, ok := manifest.Options["cpu_type"]; ok {
-			out.CpuType = v.(string)
+			out.CpuType = converter.DecodeToString(v)
 		}
 
 		if v, ok := manifest.Options["image_size"]; ok {
@@ -58,11 +58,11 @@ func (me *dsapiEncoder) Encode(manifest *dsapid.ManifestResource) interface{} {
 		}
 
 		if v, ok := manifest.Options["nic_driver"]; ok {
-			out.NicDriver = v.(string)
+			out.NicDriver = converter.DecodeToString(v)
 		}
 
 		if v, ok := manifest.Options["disk_driver"]; ok {
-			out.DiskDriver = v.(string)
+			out.DiskDriver = converter.DecodeToString(v)
 		}
 	}
 
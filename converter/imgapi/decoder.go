@@ -1,7 +1,6 @@
 package imgapi
 
 import (
-	"fmt"
 	"github.com/MerlinDMC/dsapid"
 	"github.com/MerlinDMC/dsapid/converter"
 	"github.com/MerlinDMC/dsapid/storage"
@@ -39,8 +38,8 @@ func (me *imgadmDecoder) Decode(data dsapid.Table) *dsapid.ManifestResource {
 	var creator_uuid string = dsapid.DefaultUserUuid
 	var creator_name string = dsapid.DefaultUserName
 
-	if v, ok := data["owner"]; ok && v.(string) != "" {
-		creator_uuid = v.(string)
+	if v, ok := data["owner"]; ok && converter.DecodeToString(v) != "" {
+		creator_uuid = converter.DecodeToString(v)
 
 		if u, ok := me.users.GetOK(creator_uuid); ok {
 			creator_name = u.Name
@@ -62,57 +61,74 @@ func (me *imgadmDecoder) Decode(data dsapid.Table) *dsapid.ManifestResource {
 	}
 
 	if v, ok := data["uuid"]; ok {
-		manifest.Uuid = v.(string)
+		manifest.Uuid = converter.DecodeToString(v)
 	}
 
 	if v, ok := data["name"]; ok {
-		manifest.Name = v.(string)
+		manifest.Name = converter.DecodeToString(v)
 	}
 
 	if v, ok := data["version"]; ok {
-		manifest.Version = v.(string)
+		manifest.Version = converter.DecodeToString(v)
 	}
 
 	if v, ok := data["description"]; ok {
-		manifest.Description = v.(string)
+		manifest.Description = converter.DecodeToString(v)
 	}
 
 	if v, ok := data["type"]; ok {
-		manifest.Type = dsapid.ManifestType(v.(string))
+		manifest.Type = dsapid.ManifestType(converter.DecodeToString(v))
 	}
 
 	if v, ok := data["os"]; ok {
-		manifest.Os = v.(string)
+		manifest.Os = converter.DecodeToString(v)
 	}
 
 	if v, ok := data["requirements"]; ok {
-		manifest.Requirements = dsapid.Table(v.(map[string]interface{}))
+		manifest.Requirements = converter.DecodeToTable(v)
 	}
 
 	if v, ok := data["tags"]; ok {
-		manifest.Tags = dsapid.Table(v.(map[string]interface{}))
+		manifest.Tags = converter.DecodeToTable(v)
 	}
 
-	if v, ok := data["users"]; ok {
-		for _, u := range v.([]interface{}) {
-			manifest.Users = append(manifest.Users, dsapid.Table(u.(map[string]interface{})))
+	if v, ok := data["billing_tags"].([]interface{}); ok {
+		for _, t := range v {
+			if s, ok := t.(string); ok {
+				manifest.BillingTags = append(manifest.BillingTags, s)
+			}
 		}
 	}
 
-	if v, ok := data["public"]; ok {
-		manifest.Public = v.(bool)
-	} else {
-		manifest.Public = true
+	if v, ok := data["channels"].([]interface{}); ok {
+		for _, c := range v {
+			if s, ok := c.(string); ok {
+				manifest.Channels = append(manifest.Channels, s)
+			}
+		}
 	}
 
-	if v, ok := data["disabled"]; ok {
-		manifest.Disabled = v.(bool)
-	} else {
-		manifest.Disabled = false
+	if v, ok := data["acl"].([]interface{}); ok {
+		for _, a := range v {
+			if s, ok := a.(string); ok {
+				manifest.Acl = append(manifest.Acl, s)
+			}
+		}
+	}
+
+	if v, ok := data["icon"].(bool); ok {
+		manifest.Icon = v
 	}
 
+	if v, ok := data["users"]; ok {
+		manifest.Users = append(manifest.Users, converter.DecodeToTables(v)...)
+	}
+
+	manifest.Public = converter.DecodeToBool(data["public"], true)
+	manifest.Disabled = converter.DecodeToBool(data["disabled"], false)
+
 	if v, ok := data["state"]; ok {
-		manifest.State = dsapid.ManifestState(v.(string))
+		manifest.State = dsapid.ManifestState(converter.DecodeToString(v))
 	} else {
 		if manifest.Disabled {
 			manifest.State = dsapid.ManifestStateDisabled
@@ -122,26 +138,26 @@ func (me *imgadmDecoder) Decode(data dsapid.Table) *dsapid.ManifestResource {
 	}
 
 	if v, ok := data["homepage"]; ok {
-		manifest.Homepage = v.(string)
+		manifest.Homepage = converter.DecodeToString(v)
 	}
 
 	if v, ok := data["urn"]; ok {
-		manifest.Urn = v.(string)
+		manifest.Urn = converter.DecodeToString(v)
 	} else {
 		manifest.Urn = converter.ComputeUrn(manifest)
 	}
 
 	if manifest.Type == dsapid.ManifestTypeZvol {
 		if v, ok := data["nic_driver"]; ok {
-			manifest.Options["nic_driver"] = v.(string)
+			manifest.Options["nic_driver"] = converter.DecodeToString(v)
 		}
 
 		if v, ok := data["disk_driver"]; ok {
-			manifest.Options["disk_driver"] = v.(string)
+			manifest.Options["disk_driver"] = converter.DecodeToString(v)
 		}
 
 		if v, ok := data["cpu_type"]; ok {
-			manifest.Options["cpu_type"] = v.(string)
+			manifest.Options["cpu_type"] = converter.DecodeToString(v)
 		}
 
 		if v, ok := data["image_size"]; ok {
@@ -150,13 +166,13 @@ func (me *imgadmDecoder) Decode(data dsapid.Table) *dsapid.ManifestResource {
 	}
 
 	if v, ok := data["published_at"]; ok {
-		if dt, err := converter.ParseDateTime(v.(string)); err == nil {
+		if dt, err := converter.ParseDateTime(converter.DecodeToString(v)); err == nil {
 			manifest.PublishedAt = dt
 		}
 	}
 
 	if v, ok := data["created_at"]; ok {
-		if dt, err := converter.ParseDateTime(v.(string)); err == nil {
+		if dt, err := converter.ParseDateTime(converter.DecodeToString(v)); err == nil {
 			manifest.CreatedAt = dt
 		} else {
 			manifest.CreatedAt = manifest.PublishedAt
@@ -169,7 +185,7 @@ func (me *imgadmDecoder) Decode(data dsapid.Table) *dsapid.ManifestResource {
 		file.Compression = dsapid.CompressionTypeNone
 
 		if v, ok := data["compression"]; ok {
-			file.Compression = dsapid.CompressionType(v.(string))
+			file.Compression = dsapid.CompressionType(converter.DecodeToString(v))
 		}
 
 		if v, ok := data["size"]; ok {
@@ -177,47 +193,32 @@ func (me *imgadmDecoder) Decode(data dsapid.Table) *dsapid.ManifestResource {
 		}
 
 		if v, ok := data["md5"]; ok {
-			file.Md5 = v.(string)
+			file.Md5 = converter.DecodeToString(v)
 		}
 
 		if v, ok := data["sha1"]; ok {
-			file.Sha1 = v.(string)
+			file.Sha1 = converter.DecodeToString(v)
 		}
 
 		// reconstruct path information
-		if ext, ok := dsapid.CompressionTypeExtensionMap[file.Compression]; ok {
-			var fullName string
-			if file_idx := len(manifest.Files); file_idx > 0 {
-				fullName = fmt.Sprintf("%s-%s-%d", manifest.Name, manifest.Version, file_idx)
-			} else {
-				fullName = fmt.Sprintf("%s-%s", manifest.Name, manifest.Version)
-			}
-
-			if manifest.Type == dsapid.ManifestTypeZone {
-				file.Path = fmt.Sprintf("%s.zfs%s", fullName, ext)
-			} else {
-				file.Path = fmt.Sprintf("%s.zvol%s", fullName, ext)
-			}
-		}
+		file.Path = converter.ComputeFilePath(manifest, len(manifest.Files), file.Compression)
 
 		return file
 	}
 
 	if v, ok := data["files"]; ok {
-		for _, u := range v.([]interface{}) {
-			manifest.Files = append(manifest.Files, decode_file(dsapid.Table(u.(map[string]interface{}))))
+		for _, u := range converter.DecodeToTables(v) {
+			manifest.Files = append(manifest.Files, decode_file(u))
 		}
 	}
 
 	// internal extra stuff which might only be accessible if we sync from another instance of ourselve
-	if v, ok := data["builder_info"]; ok {
-		manifest.BuilderInfo = dsapid.Table(v.(map[string]interface{}))
+	if v := converter.DecodeToTable(data["builder_info"]); v != nil {
+		manifest.BuilderInfo = v
 	}
 
 	if v, ok := data["metadata_info"]; ok {
-		for _, m := range v.([]interface{}) {
-			manifest.MetadataInfo = append(manifest.MetadataInfo, dsapid.Table(m.(map[string]interface{})))
-		}
+		manifest.MetadataInfo = append(manifest.MetadataInfo, converter.DecodeToTables(v)...)
 	}
 
 	return manifest
@@ -42,6 +42,11 @@ func (me *imgapiEncoder) Encode(manifest *dsapid.ManifestResource) interface{} {
 		Requirements: manifest.Requirements,
 		Users:        manifest.Users,
 		Tags:         manifest.Tags,
+		BillingTags:  manifest.BillingTags,
+		Channels:     manifest.Channels,
+		Icon:         manifest.Icon,
+		Acl:          manifest.Acl,
+		Files:        make([]imgapiManifestFile, 0),
 	}
 
 	if owner, ok := me.users.GetOK(manifest.Owner); ok {
@@ -50,7 +55,7 @@ func (me *imgapiEncoder) Encode(manifest *dsapid.ManifestResource) interface{} {
 
 	if manifest.Type == dsapid.ManifestTypeZvol {
 		if v, ok := manifest.Options["cpu_type"]; ok {
-			out.CpuType = v.(string)
+			out.CpuType = converter.DecodeToString(v)
 		}
 
 		if v, ok := manifest.Options["image_size"]; ok {
@@ -58,11 +63,11 @@ func (me *imgapiEncoder) Encode(manifest *dsapid.ManifestResource) interface{} {
 		}
 
 		if v, ok := manifest.Options["nic_driver"]; ok {
-			out.NicDriver = v.(string)
+			out.NicDriver = converter.DecodeToString(v)
 		}
 
 		if v, ok := manifest.Options["disk_driver"]; ok {
-			out.DiskDriver = v.(string)
+			out.DiskDriver = converter.DecodeToString(v)
 		}
 	}
 
@@ -24,9 +24,13 @@ type imgapiManifest struct {
 	Public       bool                 `json:"public"`
 	PublishedAt  time.Time            `json:"published_at"`
 	Owner        string               `json:"owner"`
+	Acl          []string             `json:"acl,omitempty"`
 	Requirements dsapid.Table         `json:"requirements,omitempty"`
 	Users        []dsapid.Table       `json:"users,omitempty"`
 	Tags         dsapid.Table         `json:"tags,omitempty"`
+	BillingTags  []string             `json:"billing_tags,omitempty"`
+	Channels     []string             `json:"channels,omitempty"`
+	Icon         bool                 `json:"icon,omitempty"`
 	CpuType      string               `json:"cpu_type,omitempty"`
 	ImageSize    int64                `json:"image_size,omitempty"`
 	NicDriver    string               `json:"nic_driver,omitempty"`
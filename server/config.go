@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"github.com/MerlinDMC/dsapid"
+	"github.com/MerlinDMC/dsapid/storage"
 	"github.com/martini-contrib/throttle"
 	"io/ioutil"
 	"os"
@@ -20,11 +21,52 @@ type Config struct {
 
 	DataDir     string                      `json:"datadir"`
 	UsersConfig string                      `json:"users"`
+	Storage     dsapid.StorageType          `json:"storage,omitempty"`
+	Database    string                      `json:"database,omitempty"`
+	Files       filesConfig                 `json:"files,omitempty"`
 	SyncSources []dsapid.SyncSourceResource `json:"sync,omitempty"`
+	Channels    dsapid.Channels             `json:"channels,omitempty"`
 
 	Throttle struct {
 		Api throttleConfig `json:"api,omitempty"`
 	} `json:"throttle,omitempty"`
+
+	GarbageCollect gcConfig      `json:"gc,omitempty"`
+	Fsck           fsckConfig    `json:"fsck,omitempty"`
+	Watch          watchConfig   `json:"watch,omitempty"`
+	Uploads        uploadsConfig `json:"uploads,omitempty"`
+}
+
+type gcConfig struct {
+	// scheduled runs are disabled if zero
+	Interval Duration `json:"interval,omitempty"`
+	Grace    Duration `json:"grace,omitempty"`
+	DryRun   bool     `json:"dry_run,omitempty"`
+}
+
+// uploadsConfig keeps resumable upload sessions which are removed after
+// being idle for the expiry.
+type uploadsConfig struct {
+	Dir    string   `json:"dir,omitempty"`
+	Expiry Duration `json:"expiry,omitempty"`
+	// upload request bodies are unlimited if zero
+	MaxBodySize int64 `json:"max_body_size,omitempty"`
+}
+
+type filesConfig struct {
+	Backend dsapid.FileBackendType `json:"backend,omitempty"`
+	S3      storage.S3Options      `json:"s3,omitempty"`
+}
+
+type fsckConfig struct {
+	// scheduled runs are disabled if zero
+	Interval Duration `json:"interval,omitempty"`
+}
+
+// watchConfig enables reloading manifests changed inside datadir.
+type watchConfig struct {
+	Enabled bool     `json:"enabled,omitempty"`
+	Delay   Duration `json:"delay,omitempty"`
 }
 
 type protoConfig struct {
@@ -79,6 +121,16 @@ func DefaultConfig() Config {
 		Hostname: "localhost",
 		LogLevel: flagLogLevel,
 		BaseUrl:  "http://localhost:8000/",
+		Storage:  dsapid.StorageTypeFilesystem,
+		GarbageCollect: gcConfig{
+			Grace: Duration(7 * 24 * time.Hour),
+		},
+		Watch: watchConfig{
+			Delay: Duration(2 * time.Second),
+		},
+		Uploads: uploadsConfig{
+			Expiry: Duration(24 * time.Hour),
+		},
 		Listen: map[string]protoConfig{
 			"http": protoConfig{
 				ListenAddress: "0.0.0.0:8000",
@@ -97,6 +149,26 @@ func (me *Config) Load(filename string) (err error) {
 	return err
 }
 
+// DatabaseFile returns the bolt database location which defaults to a file
+// next to the users config.
+func (me *Config) DatabaseFile() string {
+	if me.Database != "" {
+		return me.Database
+	}
+
+	return path.Join(path.Dir(me.UsersConfig), "dsapid.db")
+}
+
+// UploadDir returns the directory for upload sessions which defaults to a
+// hidden directory inside datadir.
+func (me *Config) UploadDir() string {
+	if me.Uploads.Dir != "" {
+		return me.Uploads.Dir
+	}
+
+	return path.Join(me.DataDir, ".uploads")
+}
+
 func (me *Config) Save(filename string) (err error) {
 	os.MkdirAll(path.Dir(filename), 0770)
 
@@ -0,0 +1,286 @@
+package fsck
+
+import (
+	"errors"
+	"github.com/MerlinDMC/dsapid"
+	"github.com/MerlinDMC/dsapid/storage"
+	log "github.com/Sirupsen/logrus"
+	"reflect"
+	"sync"
+	"time"
+)
+
+var (
+	ErrScrubberAlreadyRunning error = errors.New("scrubber already running")
+	ErrScrubInProgress        error = errors.New("scrub already in progress")
+)
+
+type Scrubber interface {
+	Run() error
+	Stop()
+	// Scrub verifies every stored image and quarantines those with broken
+	// files. Quarantined images whose files are intact again get their
+	// previous state back.
+	Scrub() (*Report, error)
+	Status() Status
+}
+
+type Report struct {
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+	Checked     int       `json:"checked"`
+	Quarantined []Failure `json:"quarantined"`
+	// images which couldn't be checked, e.g. while the file backend is down
+	Skipped  []Failure `json:"skipped"`
+	Restored []Failure `json:"restored"`
+}
+
+type Failure struct {
+	Uuid    string `json:"uuid"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Error   string `json:"error,omitempty"`
+}
+
+type Status struct {
+	Running    bool    `json:"running"`
+	Checked    int     `json:"checked"`
+	Total      int     `json:"total"`
+	LastReport *Report `json:"last_report,omitempty"`
+}
+
+type scrubber struct {
+	manifests storage.ManifestStorage
+	interval  time.Duration
+
+	lock        sync.Mutex
+	running     bool
+	checked     int
+	total       int
+	last_report *Report
+	s_stop      chan struct{}
+}
+
+// NewScrubber creates a Scrubber which runs every interval once started.
+// An interval of 0 only allows scrubbing on demand.
+func NewScrubber(manifests storage.ManifestStorage, interval time.Duration) Scrubber {
+	return &scrubber{
+		manifests: manifests,
+		interval:  interval,
+	}
+}
+
+func (me *scrubber) Run() error {
+	if me.s_stop != nil {
+		return ErrScrubberAlreadyRunning
+	}
+
+	me.s_stop = make(chan struct{})
+
+	if me.interval <= 0 {
+		return nil
+	}
+
+	go func() {
+		ticker := time.NewTicker(me.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-me.s_stop:
+				return
+			case <-ticker.C:
+				me.Scrub()
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (me *scrubber) Stop() {
+	if me.s_stop != nil {
+		close(me.s_stop)
+	}
+}
+
+func (me *scrubber) Scrub() (*Report, error) {
+	me.lock.Lock()
+	if me.running {
+		me.lock.Unlock()
+
+		return nil, ErrScrubInProgress
+	}
+
+	var manifests []*dsapid.ManifestResource
+
+	for manifest := range me.manifests.List() {
+		// purged files of nuked images are expected to be gone
+		if manifest.State != dsapid.ManifestStateNuked && len(manifest.Files) > 0 {
+			manifests = append(manifests, manifest)
+		}
+	}
+
+	me.running, me.checked, me.total = true, 0, len(manifests)
+	me.lock.Unlock()
+
+	report := &Report{
+		StartedAt:   time.Now(),
+		Quarantined: make([]Failure, 0),
+		Skipped:     make([]Failure, 0),
+		Restored:    make([]Failure, 0),
+	}
+
+	for _, manifest := range manifests {
+		err := storage.VerifyManifestFiles(me.manifests, manifest)
+
+		if _, broken := err.(*storage.IntegrityError); broken {
+			log.WithFields(log.Fields{
+				"image_uuid":    manifest.Uuid,
+				"image_name":    manifest.Name,
+				"image_version": manifest.Version,
+			}).Errorf("integrity check failed: %s", err)
+
+			me.quarantine(manifest)
+
+			report.Quarantined = append(report.Quarantined, newFailure(manifest, err))
+		} else if err != nil {
+			log.WithFields(log.Fields{
+				"image_uuid":    manifest.Uuid,
+				"image_name":    manifest.Name,
+				"image_version": manifest.Version,
+			}).Warnf("can't check integrity: %s", err)
+
+			report.Skipped = append(report.Skipped, newFailure(manifest, err))
+		} else if manifest.State == dsapid.ManifestStateQuarantined {
+			if me.restore(manifest) {
+				report.Restored = append(report.Restored, newFailure(manifest, nil))
+			}
+		}
+
+		report.Checked++
+
+		me.lock.Lock()
+		me.checked = report.Checked
+		me.lock.Unlock()
+	}
+
+	report.FinishedAt = time.Now()
+
+	log.WithFields(log.Fields{
+		"checked":     report.Checked,
+		"quarantined": len(report.Quarantined),
+		"skipped":     len(report.Skipped),
+		"restored":    len(report.Restored),
+	}).Info("integrity check finished")
+
+	me.lock.Lock()
+	me.running = false
+	me.last_report = report
+	me.lock.Unlock()
+
+	return report, nil
+}
+
+// current returns the stored version of the checked manifest unless it is
+// gone or its files changed since it was checked. Changes are always made
+// to the current version so those done during a scrub are kept.
+func (me *scrubber) current(checked *dsapid.ManifestResource) (*dsapid.ManifestResource, bool) {
+	manifest, ok := me.manifests.GetOK(checked.Uuid)
+	if !ok || !reflect.DeepEqual(manifest.Files, checked.Files) {
+		return nil, false
+	}
+
+	return manifest, true
+}
+
+func (me *scrubber) quarantine(checked *dsapid.ManifestResource) {
+	manifest, ok := me.current(checked)
+	if !ok || manifest.State == dsapid.ManifestStateQuarantined || manifest.State == dsapid.ManifestStateNuked {
+		return
+	}
+
+	quarantined := storage.CopyManifest(manifest)
+	quarantined.State = dsapid.ManifestStateQuarantined
+	quarantined.Disabled = true
+
+	if err := me.manifests.Update(quarantined.Uuid, quarantined, storage.RevisionAuthor{Name: "fsck"}); err != nil {
+		log.WithFields(log.Fields{
+			"image_uuid": manifest.Uuid,
+		}).Errorf("can't quarantine image: %s", err)
+	}
+}
+
+// restore sets the state a quarantined image had before it was quarantined.
+func (me *scrubber) restore(checked *dsapid.ManifestResource) bool {
+	manifest, ok := me.current(checked)
+	if !ok || manifest.State != dsapid.ManifestStateQuarantined {
+		return false
+	}
+
+	revisions, err := me.manifests.Revisions(manifest.Uuid)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"image_uuid": manifest.Uuid,
+		}).Errorf("can't read revisions: %s", err)
+
+		return false
+	}
+
+	for i := len(revisions) - 1; i >= 0; i-- {
+		previous := revisions[i].Manifest
+
+		if previous == nil || previous.State == dsapid.ManifestStateQuarantined {
+			continue
+		}
+
+		restored := storage.CopyManifest(manifest)
+		restored.State = previous.State
+		restored.Disabled = previous.Disabled
+
+		log.WithFields(log.Fields{
+			"image_uuid":    manifest.Uuid,
+			"image_name":    manifest.Name,
+			"image_version": manifest.Version,
+			"state":         restored.State,
+		}).Info("restoring quarantined image")
+
+		if err := me.manifests.Update(restored.Uuid, restored, storage.RevisionAuthor{Name: "fsck"}); err != nil {
+			log.WithFields(log.Fields{
+				"image_uuid": manifest.Uuid,
+			}).Errorf("can't restore image: %s", err)
+
+			return false
+		}
+
+		return true
+	}
+
+	return false
+}
+
+func newFailure(manifest *dsapid.ManifestResource, err error) Failure {
+	failure := Failure{
+		Uuid:    manifest.Uuid,
+		Name:    manifest.Name,
+		Version: manifest.Version,
+	}
+
+	if err != nil {
+		failure.Error = err.Error()
+	}
+
+	return failure
+}
+
+func (me *scrubber) Status() Status {
+	me.lock.Lock()
+	defer me.lock.Unlock()
+
+	return Status{
+		Running:    me.running,
+		Checked:    me.checked,
+		Total:      me.total,
+		LastReport: me.last_report,
+	}
+}
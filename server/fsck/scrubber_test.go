@@ -0,0 +1,180 @@
+package fsck
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"github.com/MerlinDMC/dsapid"
+	"github.com/MerlinDMC/dsapid/storage"
+	"github.com/pborman/uuid"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// flakyBackend fails to open the blobs in down like an unreachable S3;
+// onOpen is called before a blob is opened.
+type flakyBackend struct {
+	storage.BlobBackend
+
+	down   map[string]bool
+	onOpen func(string)
+}
+
+func (me *flakyBackend) Open(key string) (storage.BlobReader, error) {
+	if me.onOpen != nil {
+		me.onOpen(key)
+	}
+
+	if me.down[key] {
+		return nil, errors.New("connection refused")
+	}
+
+	return me.BlobBackend.Open(key)
+}
+
+func addImage(t *testing.T, manifests storage.ManifestStorage, name string, data string) *dsapid.ManifestResource {
+	sum := sha1.Sum([]byte(data))
+
+	m := &dsapid.ManifestResource{
+		Uuid:        uuid.New(),
+		Name:        name,
+		Version:     "1.0.0",
+		State:       dsapid.ManifestStateActive,
+		Type:        dsapid.ManifestTypeZone,
+		PublishedAt: time.Now(),
+		Files: []dsapid.ManifestFileResource{{
+			Path: name + ".zfs",
+			Size: int64(len(data)),
+			Sha1: hex.EncodeToString(sum[:]),
+		}},
+	}
+
+	f, err := manifests.CreateFile(m, &m.Files[0])
+	if err != nil {
+		t.Fatalf("can't create file: %s", err)
+	}
+
+	f.Write([]byte(data))
+	f.Close()
+
+	if err := manifests.Add(m.Uuid, m); err != nil {
+		t.Fatalf("can't add manifest: %s", err)
+	}
+
+	return m
+}
+
+func blobKey(file dsapid.ManifestFileResource) string {
+	return path.Join("sha1", file.Sha1[:2], file.Sha1)
+}
+
+func TestScrubQuarantinesBrokenImagesOnly(t *testing.T) {
+	basedir, err := ioutil.TempDir("", "dsapid-fsck")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %s", err)
+	}
+	defer os.RemoveAll(basedir)
+
+	blobdir := path.Join(basedir, ".blobs")
+	backend := &flakyBackend{BlobBackend: storage.NewLocalBlobBackend(blobdir), down: make(map[string]bool)}
+	manifests := storage.NewManifestStorage(basedir, backend)
+
+	intact := addImage(t, manifests, "intact", "intact")
+	broken := addImage(t, manifests, "broken", "broken")
+	offline := addImage(t, manifests, "offline", "offline")
+
+	ioutil.WriteFile(path.Join(blobdir, blobKey(broken.Files[0])), []byte("garbage"), 0660)
+	backend.down[blobKey(offline.Files[0])] = true
+
+	stored := manifests.Get(broken.Uuid)
+
+	report, err := NewScrubber(manifests, 0).Scrub()
+	if err != nil {
+		t.Fatalf("scrub failed: %s", err)
+	}
+
+	if len(report.Quarantined) != 1 || report.Quarantined[0].Uuid != broken.Uuid {
+		t.Errorf("should quarantine only the broken image but got %v", report.Quarantined)
+	}
+
+	if len(report.Skipped) != 1 || report.Skipped[0].Uuid != offline.Uuid {
+		t.Errorf("should skip the image which can't be read but got %v", report.Skipped)
+	}
+
+	for _, m := range []*dsapid.ManifestResource{intact, offline} {
+		if state := manifests.Get(m.Uuid).State; state != dsapid.ManifestStateActive {
+			t.Errorf("%s should stay active but is %s", m.Name, state)
+		}
+	}
+
+	if m := manifests.Get(broken.Uuid); m.State != dsapid.ManifestStateQuarantined || !m.Disabled {
+		t.Errorf("broken image should be quarantined but is %s", m.State)
+	}
+
+	if stored.State != dsapid.ManifestStateActive {
+		t.Error("should not modify the stored manifest in place")
+	}
+
+	// once the file is repaired the image gets its state back
+	ioutil.WriteFile(path.Join(blobdir, blobKey(broken.Files[0])), []byte("broken"), 0660)
+
+	if report, _ = NewScrubber(manifests, 0).Scrub(); len(report.Restored) != 1 {
+		t.Errorf("should restore the repaired image but got %v", report.Restored)
+	}
+
+	if m := manifests.Get(broken.Uuid); m.State != dsapid.ManifestStateActive || m.Disabled {
+		t.Errorf("repaired image should be active again but is %s", m.State)
+	}
+}
+
+func TestScrubKeepsChangesMadeDuringTheScrub(t *testing.T) {
+	basedir, err := ioutil.TempDir("", "dsapid-fsck")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %s", err)
+	}
+	defer os.RemoveAll(basedir)
+
+	blobdir := path.Join(basedir, ".blobs")
+	backend := &flakyBackend{BlobBackend: storage.NewLocalBlobBackend(blobdir), down: make(map[string]bool)}
+	manifests := storage.NewManifestStorage(basedir, backend)
+
+	broken := addImage(t, manifests, "broken", "broken")
+	deleted := addImage(t, manifests, "deleted", "deleted")
+
+	ioutil.WriteFile(path.Join(blobdir, blobKey(broken.Files[0])), []byte("garbage"), 0660)
+	ioutil.WriteFile(path.Join(blobdir, blobKey(deleted.Files[0])), []byte("garbage"), 0660)
+
+	// an admin changes one image and deletes the other while they are checked
+	backend.onOpen = func(key string) {
+		switch key {
+		case blobKey(broken.Files[0]):
+			changed := storage.CopyManifest(manifests.Get(broken.Uuid))
+			changed.Acl = []string{"352971aa-31ba-496c-9ade-a379feaecd52"}
+
+			manifests.Update(changed.Uuid, changed, storage.RevisionAuthor{Name: "admin"})
+		case blobKey(deleted.Files[0]):
+			manifests.Delete(deleted.Uuid)
+		}
+	}
+
+	if _, err := NewScrubber(manifests, 0).Scrub(); err != nil {
+		t.Fatalf("scrub failed: %s", err)
+	}
+
+	m := manifests.Get(broken.Uuid)
+
+	if m.State != dsapid.ManifestStateQuarantined {
+		t.Errorf("broken image should be quarantined but is %s", m.State)
+	}
+
+	if len(m.Acl) != 1 {
+		t.Error("the change made during the scrub should be kept")
+	}
+
+	if _, ok := manifests.GetOK(deleted.Uuid); ok {
+		t.Error("an image deleted during the scrub should stay deleted")
+	}
+}
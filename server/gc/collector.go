@@ -0,0 +1,113 @@
+package gc
+
+import (
+	"errors"
+	"github.com/MerlinDMC/dsapid/storage"
+	log "github.com/Sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+var (
+	ErrCollectorAlreadyRunning error = errors.New("garbage collector already running")
+)
+
+type Collector interface {
+	Run() error
+	Stop()
+	Collect(dry_run bool) (*storage.GarbageReport, error)
+	LastReport() *storage.GarbageReport
+}
+
+type collector struct {
+	manifests storage.ManifestStorage
+	interval  time.Duration
+	grace     time.Duration
+	dry_run   bool
+
+	// lock serializes collection runs
+	lock        sync.Mutex
+	last_report *storage.GarbageReport
+	s_stop      chan struct{}
+}
+
+// NewCollector creates a Collector which runs every interval once started.
+// An interval of 0 only allows collecting on demand.
+func NewCollector(manifests storage.ManifestStorage, interval time.Duration, grace time.Duration, dry_run bool) Collector {
+	return &collector{
+		manifests: manifests,
+		interval:  interval,
+		grace:     grace,
+		dry_run:   dry_run,
+	}
+}
+
+func (me *collector) Run() error {
+	if me.s_stop != nil {
+		return ErrCollectorAlreadyRunning
+	}
+
+	me.s_stop = make(chan struct{})
+
+	if me.interval <= 0 {
+		return nil
+	}
+
+	go func() {
+		ticker := time.NewTicker(me.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-me.s_stop:
+				return
+			case <-ticker.C:
+				me.Collect(me.dry_run)
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (me *collector) Stop() {
+	if me.s_stop != nil {
+		close(me.s_stop)
+	}
+}
+
+func (me *collector) Collect(dry_run bool) (*storage.GarbageReport, error) {
+	me.lock.Lock()
+	defer me.lock.Unlock()
+
+	report, err := me.manifests.CollectGarbage(storage.GarbageOptions{
+		Grace:  me.grace,
+		DryRun: dry_run,
+	})
+
+	if err != nil {
+		log.Errorf("garbage collection failed: %s", err)
+
+		return report, err
+	}
+
+	log.WithFields(log.Fields{
+		"dry_run":         report.DryRun,
+		"nuked_images":    len(report.NukedImages),
+		"orphaned_dirs":   len(report.OrphanedDirs),
+		"stray_files":     len(report.StrayFiles),
+		"orphaned_blobs":  len(report.OrphanedBlobs),
+		"bytes_reclaimed": report.BytesReclaimed,
+	}).Info("garbage collection finished")
+
+	me.last_report = report
+
+	return report, nil
+}
+
+func (me *collector) LastReport() *storage.GarbageReport {
+	me.lock.Lock()
+	defer me.lock.Unlock()
+
+	return me.last_report
+}
@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"github.com/MerlinDMC/dsapid"
+	"github.com/MerlinDMC/dsapid/server/fsck"
+	"github.com/MerlinDMC/dsapid/server/middleware"
+	log "github.com/Sirupsen/logrus"
+	"net/http"
+)
+
+func ApiPostFsck(encoder middleware.OutputEncoder, scrubber fsck.Scrubber, user middleware.User) (int, []byte) {
+	if scrubber.Status().Running {
+		return http.StatusConflict, encoder.MustEncode(dsapid.Table{
+			"error": fsck.ErrScrubInProgress.Error(),
+		})
+	}
+
+	log.WithFields(log.Fields{
+		"user_uuid": user.GetId(),
+		"user_name": user.GetName(),
+	}).Info("starting integrity check")
+
+	// verifying all files takes a while; progress is reported by GET
+	go scrubber.Scrub()
+
+	return http.StatusAccepted, encoder.MustEncode(dsapid.Table{
+		"ok": "integrity check started",
+	})
+}
+
+func ApiGetFsck(encoder middleware.OutputEncoder, scrubber fsck.Scrubber) (int, []byte) {
+	return http.StatusOK, encoder.MustEncode(scrubber.Status())
+}
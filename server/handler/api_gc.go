@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"github.com/MerlinDMC/dsapid"
+	"github.com/MerlinDMC/dsapid/server/gc"
+	"github.com/MerlinDMC/dsapid/server/middleware"
+	log "github.com/Sirupsen/logrus"
+	"net/http"
+	"strconv"
+)
+
+func ApiPostCollectGarbage(encoder middleware.OutputEncoder, collector gc.Collector, user middleware.User, req *http.Request) (int, []byte) {
+	dry_run, _ := strconv.ParseBool(req.URL.Query().Get("dry_run"))
+
+	log.WithFields(log.Fields{
+		"user_uuid": user.GetId(),
+		"user_name": user.GetName(),
+		"dry_run":   dry_run,
+	}).Info("collecting garbage")
+
+	report, err := collector.Collect(dry_run)
+	if err != nil {
+		return http.StatusInternalServerError, encoder.MustEncode(dsapid.Table{
+			"error": err.Error(),
+		})
+	}
+
+	return http.StatusOK, encoder.MustEncode(report)
+}
+
+func ApiGetCollectGarbage(encoder middleware.OutputEncoder, collector gc.Collector) (int, []byte) {
+	if report := collector.LastReport(); report != nil {
+		return http.StatusOK, encoder.MustEncode(report)
+	}
+
+	return http.StatusNotFound, encoder.MustEncode(dsapid.Table{
+		"error": "no garbage collection run yet",
+	})
+}
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"encoding/json"
+	"github.com/MerlinDMC/dsapid"
+	"github.com/MerlinDMC/dsapid/server/middleware"
+	dsapid_sync "github.com/MerlinDMC/dsapid/server/sync"
+	log "github.com/Sirupsen/logrus"
+	"github.com/go-martini/martini"
+	"mime"
+	"net/http"
+	"net/url"
+)
+
+type importRequest struct {
+	Uuid       string              `json:"uuid"`
+	Source     string              `json:"source"`
+	FileSource string              `json:"file_source"`
+	Type       dsapid.SyncType     `json:"type"`
+	Provider   dsapid.SyncProvider `json:"provider"`
+}
+
+// ApiPostImport imports a single image from a remote dsapi or imgapi
+// source. Parameters are read from a JSON body or the query string.
+func ApiPostImport(encoder middleware.OutputEncoder, sync_manager dsapid_sync.SyncManager, user middleware.User, req *http.Request) (int, []byte) {
+	var data importRequest
+
+	media_type, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+
+	if req.ContentLength != 0 && media_type == "application/json" {
+		if err := json.NewDecoder(req.Body).Decode(&data); err != nil {
+			return http.StatusBadRequest, encoder.MustEncode(dsapid.Table{
+				"error": "request body is not a JSON object",
+			})
+		}
+	} else {
+		query := req.URL.Query()
+
+		data = importRequest{
+			Uuid:       query.Get("uuid"),
+			Source:     query.Get("source"),
+			FileSource: query.Get("file_source"),
+			Type:       dsapid.SyncType(query.Get("type")),
+			Provider:   dsapid.SyncProvider(query.Get("provider")),
+		}
+	}
+
+	if data.Type == "" {
+		data.Type = dsapid.SyncTypeImgapi
+	}
+
+	if data.Provider == "" {
+		data.Provider = dsapid.SyncProviderCommunity
+	}
+
+	if u, err := url.Parse(data.Source); err != nil || u.Host == "" {
+		return http.StatusBadRequest, encoder.MustEncode(dsapid.Table{
+			"error": "invalid source url",
+		})
+	}
+
+	log.WithFields(log.Fields{
+		"user_uuid":  user.GetId(),
+		"user_name":  user.GetName(),
+		"image_uuid": data.Uuid,
+		"source":     data.Source,
+	}).Info("requesting image import")
+
+	job, err := sync_manager.Import(dsapid.SyncSourceResource{
+		Type:       data.Type,
+		Provider:   data.Provider,
+		Source:     data.Source,
+		FileSource: data.FileSource,
+	}, data.Uuid)
+
+	switch err {
+	case nil:
+		return http.StatusAccepted, encoder.MustEncode(job)
+	case dsapid_sync.ErrImageExists:
+		return http.StatusConflict, encoder.MustEncode(dsapid.Table{
+			"error": err.Error(),
+		})
+	}
+
+	return http.StatusBadRequest, encoder.MustEncode(dsapid.Table{
+		"error": err.Error(),
+	})
+}
+
+func ApiGetImports(encoder middleware.OutputEncoder, sync_manager dsapid_sync.SyncManager) (int, []byte) {
+	return http.StatusOK, encoder.MustEncode(sync_manager.ImportJobs())
+}
+
+func ApiGetImport(encoder middleware.OutputEncoder, params martini.Params, sync_manager dsapid_sync.SyncManager) (int, []byte) {
+	if job, ok := sync_manager.ImportStatus(params["id"]); ok {
+		return http.StatusOK, encoder.MustEncode(job)
+	}
+
+	return http.StatusNotFound, encoder.MustEncode(dsapid.Table{
+		"error": "import job not found",
+	})
+}
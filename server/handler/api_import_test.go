@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"bytes"
+	"github.com/MerlinDMC/dsapid"
+	dsapid_sync "github.com/MerlinDMC/dsapid/server/sync"
+	"net/http"
+	"testing"
+)
+
+type testSyncManager struct {
+	dsapid_sync.SyncManager
+
+	source dsapid.SyncSourceResource
+	id     string
+}
+
+func (me *testSyncManager) Import(source dsapid.SyncSourceResource, id string) (dsapid_sync.ImportJob, error) {
+	me.source, me.id = source, id
+
+	return dsapid_sync.ImportJob{Image: id}, nil
+}
+
+func TestApiPostImportJsonWithCharset(t *testing.T) {
+	sync_manager := new(testSyncManager)
+
+	req, _ := http.NewRequest("POST", "/api/import", bytes.NewBufferString(`{
+		"uuid": "f9e4be48-9466-11e1-bc41-9f993f5dff36",
+		"source": "https://images.example.com"
+	}`))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+	if status, body := ApiPostImport(testEncoder{}, sync_manager, &dsapid.UserResource{Name: "admin"}, req); status != http.StatusAccepted {
+		t.Fatalf("expected 202 but got %d: %s", status, body)
+	}
+
+	if sync_manager.id != "f9e4be48-9466-11e1-bc41-9f993f5dff36" || sync_manager.source.Source != "https://images.example.com" {
+		t.Errorf("body should be read but got %s from %s", sync_manager.id, sync_manager.source.Source)
+	}
+}
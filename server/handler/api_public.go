@@ -4,6 +4,7 @@ import (
 	"archive/tar"
 	"encoding/json"
 	"fmt"
+	"github.com/MerlinDMC/dsapid"
 	"github.com/MerlinDMC/dsapid/converter"
 	"github.com/MerlinDMC/dsapid/server/middleware"
 	"github.com/MerlinDMC/dsapid/storage"
@@ -11,10 +12,10 @@ import (
 	"github.com/go-martini/martini"
 	"io"
 	"net/http"
-	"os"
+	"strconv"
 )
 
-func ApiDatasetsList(encoder middleware.OutputEncoder, params martini.Params, manifests storage.ManifestStorage, user middleware.User, req *http.Request) (int, []byte) {
+func ApiDatasetsList(encoder middleware.OutputEncoder, params martini.Params, manifests storage.ManifestStorage, user middleware.User, res http.ResponseWriter, req *http.Request) (int, []byte) {
 	var data []interface{} = make([]interface{}, 0)
 	var filters []storage.ManifestFilter = []storage.ManifestFilter{storage.FilterManifestEnabled()}
 
@@ -36,23 +37,90 @@ func ApiDatasetsList(encoder middleware.OutputEncoder, params martini.Params, ma
 		filters = append(filters, storage.FilterManifestOs(v))
 	}
 
-	for manifest := range manifests.Filter(filters...) {
-		data = append(data, manifest)
+	if ranges, err := rangeFilters(req.URL.Query()); err != nil {
+		return http.StatusBadRequest, encoder.MustEncode(dsapid.Table{
+			"error": err.Error(),
+		})
+	} else {
+		filters = append(filters, ranges...)
+	}
+
+	items, err := paginate(manifests, filters, res, req)
+	if err != nil {
+		return http.StatusBadRequest, encoder.MustEncode(dsapid.Table{
+			"error": err.Error(),
+		})
+	}
+
+	for _, manifest := range items {
+		data = append(data, hideAcl(user, manifest))
+	}
+
+	return http.StatusOK, encoder.MustEncode(data)
+}
+
+// ApiSearch ranks the datasets visible to user by how well they match ?q=.
+func ApiSearch(encoder middleware.OutputEncoder, manifests storage.ManifestStorage, users storage.UserStorage, user middleware.User, req *http.Request) (int, []byte) {
+	var data []interface{} = make([]interface{}, 0)
+	var filters []storage.ManifestFilter = []storage.ManifestFilter{storage.FilterManifestEnabled()}
+
+	if user.IsGuest() {
+		filters = append(filters, storage.FilterManifestPublic(true))
+	} else {
+		filters = append(filters, storage.FilterManifestForUser(user.GetId()))
+	}
+
+	limit := maxPageLimit
+
+	if v := req.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return http.StatusBadRequest, encoder.MustEncode(dsapid.Table{
+				"error": (&invalidParamError{"limit", v}).Error(),
+			})
+		}
+
+		if n < limit {
+			limit = n
+		}
+	}
+
+	owner_name := func(id string) string {
+		if owner, ok := users.GetOK(id); ok {
+			return owner.Name
+		}
+
+		return ""
+	}
+
+results:
+	for _, manifest := range manifests.Search(req.URL.Query().Get("q"), owner_name) {
+		for _, filter := range filters {
+			if !filter.Match(manifest) {
+				continue results
+			}
+		}
+
+		if data = append(data, hideAcl(user, manifest)); len(data) == limit {
+			break
+		}
 	}
 
 	return http.StatusOK, encoder.MustEncode(data)
 }
 
 func ApiDatasetsDetail(encoder middleware.OutputEncoder, params martini.Params, manifests storage.ManifestStorage, converter converter.DsapiManifestEncoder, user middleware.User, req *http.Request) (int, []byte) {
-	if manifest, ok := manifests.GetOK(params["id"]); ok {
-		return http.StatusOK, encoder.MustEncode(manifest)
+	if manifest, ok := manifests.GetOK(params["id"]); ok && imageVisible(user, manifest) {
+		return http.StatusOK, encoder.MustEncode(hideAcl(user, manifest))
 	}
 
 	return http.StatusNotFound, []byte("Not found")
 }
 
 func ApiDatasetExport(encoder middleware.OutputEncoder, params martini.Params, manifests storage.ManifestStorage, dsapi_converter converter.DsapiManifestEncoder, imgapi_converter converter.ImgapiManifestEncoder, user middleware.User, res http.ResponseWriter) {
-	if manifest, ok := manifests.GetOK(params["id"]); ok {
+	if manifest, ok := manifests.GetOK(params["id"]); ok && imageVisible(user, manifest) {
+		manifest = hideAcl(user, manifest)
+
 		res.Header().Set("Content-Type", "application/octet-stream")
 		res.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s-%s.tar\"", manifest.Name, manifest.Version))
 
@@ -78,18 +146,18 @@ func ApiDatasetExport(encoder middleware.OutputEncoder, params martini.Params, m
 		}
 
 		for _, file := range manifest.Files {
-			filename := manifests.FilePath(manifest, &file)
-
-			if fin, err := os.OpenFile(filename, os.O_RDONLY, 0660); err == nil {
+			if fin, err := manifests.OpenFile(manifest, &file); err == nil {
 				tw.WriteHeader(&tar.Header{
-					Name: manifest.Files[0].Path,
+					Name: file.Path,
 					Mode: 0666,
-					Size: manifest.Files[0].Size,
+					Size: fin.Size(),
 				})
 
 				if _, err := io.Copy(tw, fin); err != nil {
 					log.Errorf("failed to create tar streaming archive: %s", err)
 				}
+
+				fin.Close()
 			}
 		}
 
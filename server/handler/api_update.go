@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"github.com/MerlinDMC/dsapid"
 	"github.com/MerlinDMC/dsapid/converter"
 	"github.com/MerlinDMC/dsapid/server/middleware"
@@ -8,71 +9,83 @@ import (
 	log "github.com/Sirupsen/logrus"
 	"github.com/go-martini/martini"
 	"net/http"
+	"strconv"
 )
 
 func ApiPostDatasetUpdate(encoder middleware.OutputEncoder, params martini.Params, manifests storage.ManifestStorage, converter converter.DsapiManifestEncoder, user middleware.User, req *http.Request) (int, []byte) {
 	action := req.URL.Query().Get("action")
 
-	if manifest, ok := manifests.GetOK(params["id"]); ok && action != "" {
-		switch action {
-		case "enable":
-			log.WithFields(log.Fields{
-				"user_uuid":     user.GetId(),
-				"user_name":     user.GetName(),
-				"image_uuid":    manifest.Uuid,
-				"image_name":    manifest.Name,
-				"image_version": manifest.Version,
-			}).Info("enabling image")
-
-			manifest.State = dsapid.ManifestStateActive
-			manifest.Disabled = false
-			break
-		case "deprecate":
-			log.WithFields(log.Fields{
-				"user_uuid":     user.GetId(),
-				"user_name":     user.GetName(),
-				"image_uuid":    manifest.Uuid,
-				"image_name":    manifest.Name,
-				"image_version": manifest.Version,
-			}).Info("deprecating image")
-
-			manifest.State = dsapid.ManifestStateDeprecated
-			manifest.Disabled = false
-			break
-		case "disable":
-			log.WithFields(log.Fields{
-				"user_uuid":     user.GetId(),
-				"user_name":     user.GetName(),
-				"image_uuid":    manifest.Uuid,
-				"image_name":    manifest.Name,
-				"image_version": manifest.Version,
-			}).Info("disabling image")
-
-			manifest.State = dsapid.ManifestStateDisabled
-			manifest.Disabled = true
-			break
-		case "nuke":
-			log.WithFields(log.Fields{
-				"user_uuid":     user.GetId(),
-				"user_name":     user.GetName(),
-				"image_uuid":    manifest.Uuid,
-				"image_name":    manifest.Name,
-				"image_version": manifest.Version,
-			}).Info("nuking image")
-
-			manifest.State = dsapid.ManifestStateNuked
-			manifest.Disabled = true
-			break
-		}
+	manifest, ok := manifests.GetOK(params["id"])
+	if !ok {
+		return http.StatusNotFound, encoder.MustEncode(dsapid.Table{
+			"error": "dataset not found",
+		})
+	}
 
-		if err := manifests.Update(manifest.Uuid, manifest); err == nil {
-			return http.StatusOK, encoder.MustEncode(converter.EncodeWithExtra(manifest))
-		}
+	manifest = storage.CopyManifest(manifest)
+
+	switch action {
+	case "enable":
+		log.WithFields(log.Fields{
+			"user_uuid":     user.GetId(),
+			"user_name":     user.GetName(),
+			"image_uuid":    manifest.Uuid,
+			"image_name":    manifest.Name,
+			"image_version": manifest.Version,
+		}).Info("enabling image")
+
+		manifest.State = dsapid.ManifestStateActive
+		manifest.Disabled = false
+		break
+	case "deprecate":
+		log.WithFields(log.Fields{
+			"user_uuid":     user.GetId(),
+			"user_name":     user.GetName(),
+			"image_uuid":    manifest.Uuid,
+			"image_name":    manifest.Name,
+			"image_version": manifest.Version,
+		}).Info("deprecating image")
+
+		manifest.State = dsapid.ManifestStateDeprecated
+		manifest.Disabled = false
+		break
+	case "disable":
+		log.WithFields(log.Fields{
+			"user_uuid":     user.GetId(),
+			"user_name":     user.GetName(),
+			"image_uuid":    manifest.Uuid,
+			"image_name":    manifest.Name,
+			"image_version": manifest.Version,
+		}).Info("disabling image")
+
+		manifest.State = dsapid.ManifestStateDisabled
+		manifest.Disabled = true
+		break
+	case "nuke":
+		log.WithFields(log.Fields{
+			"user_uuid":     user.GetId(),
+			"user_name":     user.GetName(),
+			"image_uuid":    manifest.Uuid,
+			"image_name":    manifest.Name,
+			"image_version": manifest.Version,
+		}).Info("nuking image")
+
+		manifest.State = dsapid.ManifestStateNuked
+		manifest.Disabled = true
+		break
+	default:
+		return http.StatusBadRequest, encoder.MustEncode(dsapid.Table{
+			"error": "invalid action",
+		})
 	}
 
-	return http.StatusInternalServerError, encoder.MustEncode(dsapid.Table{
-		"error": "update failed",
-	})
+	if err := manifests.Update(manifest.Uuid, manifest, revisionAuthor(user)); err != nil {
+		return http.StatusInternalServerError, encoder.MustEncode(dsapid.Table{
+			"error": "update failed",
+		})
+	}
+
+	return http.StatusOK, encoder.MustEncode(converter.EncodeWithExtra(manifest))
 }
 
 func ApiPostReloadDatasets(encoder middleware.OutputEncoder, manifests storage.ManifestStorage, user middleware.User, req *http.Request) (int, []byte) {
@@ -81,9 +94,162 @@ func ApiPostReloadDatasets(encoder middleware.OutputEncoder, manifests storage.M
 		"user_name": user.GetName(),
 	}).Info("reloading datasets")
 
-	manifests.Reload()
+	if err := manifests.Reload(); err != nil {
+		log.WithFields(log.Fields{
+			"user_uuid": user.GetId(),
+			"user_name": user.GetName(),
+		}).Errorf("can't reload datasets: %s", err)
+
+		return http.StatusInternalServerError, encoder.MustEncode(dsapid.Table{
+			"error": "reload failed",
+		})
+	}
 
 	return http.StatusOK, encoder.MustEncode(dsapid.Table{
 		"ok": "datasets reloaded",
 	})
 }
+
+func ApiGetDatasetRevisions(encoder middleware.OutputEncoder, params martini.Params, manifests storage.ManifestStorage) (int, []byte) {
+	if _, ok := manifests.GetOK(params["id"]); !ok {
+		return http.StatusNotFound, encoder.MustEncode(dsapid.Table{
+			"error": "dataset not found",
+		})
+	}
+
+	revisions, err := manifests.Revisions(params["id"])
+	if err != nil {
+		return http.StatusInternalServerError, encoder.MustEncode(dsapid.Table{
+			"error": err.Error(),
+		})
+	}
+
+	return http.StatusOK, encoder.MustEncode(revisions)
+}
+
+func ApiGetDatasetRevision(encoder middleware.OutputEncoder, params martini.Params, manifests storage.ManifestStorage) (int, []byte) {
+	revision, status, err := findRevision(manifests, params["id"], params["revision"])
+	if err != nil {
+		return status, encoder.MustEncode(dsapid.Table{
+			"error": err.Error(),
+		})
+	}
+
+	return http.StatusOK, encoder.MustEncode(revision)
+}
+
+// ApiGetDatasetRevisionDiff compares a revision with the revision given by
+// ?to= or with the current manifest.
+func ApiGetDatasetRevisionDiff(encoder middleware.OutputEncoder, params martini.Params, manifests storage.ManifestStorage, req *http.Request) (int, []byte) {
+	from, status, err := findRevision(manifests, params["id"], params["revision"])
+	if err != nil {
+		return status, encoder.MustEncode(dsapid.Table{
+			"error": err.Error(),
+		})
+	}
+
+	to, ok := manifests.GetOK(params["id"])
+	if !ok {
+		return http.StatusNotFound, encoder.MustEncode(dsapid.Table{
+			"error": "dataset not found",
+		})
+	}
+
+	to_revision := "current"
+
+	if v := req.URL.Query().Get("to"); v != "" {
+		revision, status, err := findRevision(manifests, params["id"], v)
+		if err != nil {
+			return status, encoder.MustEncode(dsapid.Table{
+				"error": err.Error(),
+			})
+		}
+
+		to, to_revision = revision.Manifest, v
+	}
+
+	changes, err := storage.DiffManifests(from.Manifest, to)
+	if err != nil {
+		return http.StatusInternalServerError, encoder.MustEncode(dsapid.Table{
+			"error": err.Error(),
+		})
+	}
+
+	return http.StatusOK, encoder.MustEncode(dsapid.Table{
+		"from":    from.Revision,
+		"to":      to_revision,
+		"changes": changes,
+	})
+}
+
+// ApiPostDatasetRollback restores the metadata of a revision as a new
+// revision. The files, icon, acl and channels of the image are kept as they
+// are.
+func ApiPostDatasetRollback(encoder middleware.OutputEncoder, params martini.Params, manifests storage.ManifestStorage, converter converter.DsapiManifestEncoder, user middleware.User) (int, []byte) {
+	revision, status, err := findRevision(manifests, params["id"], params["revision"])
+	if err != nil {
+		return status, encoder.MustEncode(dsapid.Table{
+			"error": err.Error(),
+		})
+	}
+
+	current, ok := manifests.GetOK(params["id"])
+	if !ok {
+		return http.StatusNotFound, encoder.MustEncode(dsapid.Table{
+			"error": "dataset not found",
+		})
+	}
+
+	current = storage.CopyManifest(current)
+
+	manifest := *storage.CopyManifest(revision.Manifest)
+	manifest.Uuid = current.Uuid
+	manifest.Files = current.Files
+	manifest.Icon = current.Icon
+	manifest.Acl = current.Acl
+	manifest.Channels = current.Channels
+
+	log.WithFields(log.Fields{
+		"user_uuid":     user.GetId(),
+		"user_name":     user.GetName(),
+		"image_uuid":    manifest.Uuid,
+		"image_name":    manifest.Name,
+		"image_version": manifest.Version,
+		"revision":      revision.Revision,
+	}).Info("rolling back image")
+
+	if err := manifests.Update(manifest.Uuid, &manifest, revisionAuthor(user)); err != nil {
+		return http.StatusInternalServerError, encoder.MustEncode(dsapid.Table{
+			"error": "rollback failed",
+		})
+	}
+
+	return http.StatusOK, encoder.MustEncode(converter.EncodeWithExtra(&manifest))
+}
+
+func findRevision(manifests storage.ManifestStorage, id string, value string) (*storage.ManifestRevision, int, error) {
+	if _, ok := manifests.GetOK(id); !ok {
+		return nil, http.StatusNotFound, errors.New("dataset not found")
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.New("invalid revision")
+	}
+
+	revision, err := manifests.Revision(id, n)
+	if err == storage.ErrStorageItemNotFound {
+		return nil, http.StatusNotFound, errors.New("revision not found")
+	} else if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return revision, http.StatusOK, nil
+}
+
+func revisionAuthor(user middleware.User) storage.RevisionAuthor {
+	return storage.RevisionAuthor{
+		Uuid: user.GetId(),
+		Name: user.GetName(),
+	}
+}
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"github.com/MerlinDMC/dsapid"
+	"github.com/MerlinDMC/dsapid/storage"
+	"github.com/go-martini/martini"
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestApiPostDatasetUpdateRejectsUnknownActions(t *testing.T) {
+	manifests, cleanup := newTestManifestStorage(t)
+	defer cleanup()
+
+	admin := &dsapid.UserResource{Uuid: "352971aa-31ba-496c-9ade-a379feaecd52", Name: "admin"}
+
+	manifest := newTestManifest("image", time.Now())
+
+	if err := manifests.Add(manifest.Uuid, manifest); err != nil {
+		t.Fatalf("can't add manifest: %s", err)
+	}
+
+	for _, action := range []string{"", "explode"} {
+		req, _ := http.NewRequest("POST", "/api/datasets/"+manifest.Uuid+"?action="+action, nil)
+
+		if status, _ := ApiPostDatasetUpdate(testEncoder{}, martini.Params{"id": manifest.Uuid}, manifests, testManifestEncoder{}, admin, req); status != http.StatusBadRequest {
+			t.Errorf("action %q: expected %d but got %d", action, http.StatusBadRequest, status)
+		}
+	}
+
+	if revisions, _ := manifests.Revisions(manifest.Uuid); len(revisions) != 0 {
+		t.Errorf("expected no revisions but got %d", len(revisions))
+	}
+
+	req, _ := http.NewRequest("POST", "/api/datasets/unknown?action=disable", nil)
+
+	if status, _ := ApiPostDatasetUpdate(testEncoder{}, martini.Params{"id": "unknown"}, manifests, testManifestEncoder{}, admin, req); status != http.StatusNotFound {
+		t.Errorf("expected %d for an unknown dataset but got %d", http.StatusNotFound, status)
+	}
+}
+
+func TestApiPostDatasetRollbackKeepsAccess(t *testing.T) {
+	manifests, cleanup := newTestManifestStorage(t)
+	defer cleanup()
+
+	admin := &dsapid.UserResource{Uuid: "352971aa-31ba-496c-9ade-a379feaecd52", Name: "admin"}
+	author := storage.RevisionAuthor{Uuid: admin.Uuid, Name: admin.Name}
+
+	manifest := newTestManifest("image", time.Now())
+	manifest.Description = "first"
+
+	if err := manifests.Add(manifest.Uuid, manifest); err != nil {
+		t.Fatalf("can't add manifest: %s", err)
+	}
+
+	changed := storage.CopyManifest(manifest)
+	changed.Description = "second"
+	changed.Icon = true
+	changed.Acl = []string{"930896af-bf8c-48d4-885c-6573a94b1853"}
+	changed.Channels = []string{"staging"}
+
+	if err := manifests.Update(changed.Uuid, changed, author); err != nil {
+		t.Fatalf("can't update manifest: %s", err)
+	}
+
+	revisions, err := manifests.Revisions(manifest.Uuid)
+	if err != nil || len(revisions) == 0 {
+		t.Fatalf("no revisions recorded: %s", err)
+	}
+
+	params := martini.Params{"id": manifest.Uuid, "revision": strconv.Itoa(revisions[0].Revision)}
+
+	if status, body := ApiPostDatasetRollback(testEncoder{}, params, manifests, testManifestEncoder{}, admin); status != http.StatusOK {
+		t.Fatalf("rollback failed with %d: %s", status, body)
+	}
+
+	current := manifests.Get(manifest.Uuid)
+
+	if current.Description != "first" {
+		t.Errorf("expected the description of the revision but got %q", current.Description)
+	}
+
+	if !current.Icon || !reflect.DeepEqual(current.Acl, changed.Acl) || !reflect.DeepEqual(current.Channels, changed.Channels) {
+		t.Errorf("rollback changed icon, acl or channels: %v %v %v", current.Icon, current.Acl, current.Channels)
+	}
+}
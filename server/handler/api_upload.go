@@ -3,131 +3,387 @@ package handler
 import (
 	"crypto/md5"
 	"crypto/sha1"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/MerlinDMC/dsapid"
 	"github.com/MerlinDMC/dsapid/converter/decoder"
 	"github.com/MerlinDMC/dsapid/server/middleware"
 	"github.com/MerlinDMC/dsapid/storage"
 	log "github.com/Sirupsen/logrus"
-	"github.com/go-martini/martini"
 	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
-	"os"
+	"path"
+	"strconv"
+	"strings"
 	"time"
 )
 
-func ApiPostFileUpload(encoder middleware.OutputEncoder, params martini.Params, manifests storage.ManifestStorage, users storage.UserStorage, user middleware.User, req *http.Request) (int, []byte) {
-	var manifest *dsapid.ManifestResource
+const (
+	maxUploadMemory int64 = 32 << 20
+)
 
-	if file, _, err := req.FormFile("manifest"); err != nil {
-		log.WithFields(log.Fields{
-			"error": err.Error(),
-		}).Error("there was an error reading the manifest")
-		goto errNoManifest
-	} else {
-		var data dsapid.Table
+var (
+	errChecksumMismatch error = errors.New("checksum mismatch")
+	errSizeMismatch     error = errors.New("size mismatch")
+)
 
-		if err := json.NewDecoder(file).Decode(&data); err != nil {
+// ApiPostFileUpload stores a new image. A multipart/form-data request
+// carries the manifest and one form file per file of the manifest, see
+// uploadFileIndex. Any other request streams the single file of the
+// manifest from the body straight into the datadir: the manifest is read
+// from the X-Manifest header, plain or base64 encoded JSON, or from a JSON
+// object at the start of the body which the file data follows.
+func ApiPostFileUpload(encoder middleware.OutputEncoder, manifests storage.ManifestStorage, users storage.UserStorage, user middleware.User, req *http.Request) (int, []byte) {
+	var data dsapid.Table
+	var parts []*multipart.FileHeader
+	var file io.Reader
+
+	media_type, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	multipart_upload := media_type == "multipart/form-data"
+
+	if multipart_upload {
+		if err := req.ParseMultipartForm(maxUploadMemory); err != nil {
+			log.WithFields(log.Fields{
+				"error": err.Error(),
+			}).Error("there was an error reading the upload")
+			return uploadFailed(encoder, err)
+		}
+		defer req.MultipartForm.RemoveAll()
+
+		manifest_file, _, err := req.FormFile("manifest")
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err.Error(),
+			}).Error("there was an error reading the manifest")
+			return uploadFailed(encoder, err)
+		}
+
+		if err := json.NewDecoder(manifest_file).Decode(&data); err != nil {
 			log.WithFields(log.Fields{
 				"error": err.Error(),
 			}).Error("there was an error decoding the manifest")
-			goto errNoManifest
-		} else {
-			manifest = decoder.DecodeToManifest(data, dsapid.SyncProviderCommunity, users)
-
-			if _, ok := manifests.GetOK(manifest.Uuid); ok {
-				log.WithFields(log.Fields{
-					"user_uuid":     user.GetId(),
-					"user_name":     user.GetName(),
-					"image_uuid":    manifest.Uuid,
-					"image_name":    manifest.Name,
-					"image_version": manifest.Version,
-				}).Warn("uploading duplicate image")
-
-				return http.StatusInternalServerError, encoder.MustEncode(dsapid.Table{
-					"error": "image already exists",
-				})
+			return uploadFailed(encoder, err)
+		}
+
+		manifest_file.Close()
+	} else if v := req.Header.Get("X-Manifest"); v != "" {
+		if !strings.HasPrefix(strings.TrimSpace(v), "{") {
+			if b, err := base64.StdEncoding.DecodeString(v); err == nil {
+				v = string(b)
 			}
+		}
 
+		if err := json.Unmarshal([]byte(v), &data); err != nil {
 			log.WithFields(log.Fields{
-				"user_uuid":     user.GetId(),
-				"user_name":     user.GetName(),
-				"image_uuid":    manifest.Uuid,
-				"image_name":    manifest.Name,
-				"image_version": manifest.Version,
-			}).Info("uploading image")
-
-			manifest.PublishedAt = time.Now()
-			manifest.State = dsapid.ManifestStatePending
-
-			if !user.HasRoles(dsapid.UserRoleDatasetManage) && !user.HasRoles(dsapid.UserRoleDatasetAdmin) {
-				manifest.Owner = user.GetId()
-			}
+				"error": err.Error(),
+			}).Error("there was an error decoding the manifest")
+			return uploadFailed(encoder, err)
+		}
+
+		file = req.Body
+	} else {
+		dec := json.NewDecoder(req.Body)
+
+		if err := dec.Decode(&data); err != nil {
+			log.WithFields(log.Fields{
+				"error": err.Error(),
+			}).Error("there was an error decoding the manifest")
+			return uploadFailed(encoder, err)
+		}
+
+		// the decoder may have read ahead into the file data
+		file = io.MultiReader(dec.Buffered(), req.Body)
+	}
+
+	if data == nil {
+		return http.StatusBadRequest, encoder.MustEncode(dsapid.Table{
+			"error": "manifest missing",
+		})
+	}
+
+	if problems := decoder.ValidateManifest(data, decoder.ValidationOptions{}); len(problems) > 0 {
+		return invalidManifest(encoder, problems)
+	}
+
+	manifest := decoder.DecodeToManifest(data, dsapid.SyncProviderCommunity, users)
+
+	if len(manifest.Files) == 0 {
+		return http.StatusBadRequest, encoder.MustEncode(dsapid.Table{
+			"error": "manifest has no file",
+		})
+	}
+
+	if err := uniqueFilePaths(manifest); err != nil {
+		return http.StatusBadRequest, encoder.MustEncode(dsapid.Table{
+			"error": err.Error(),
+		})
+	}
+
+	if multipart_upload {
+		var err error
+
+		if parts, err = uploadParts(manifest, req.MultipartForm); err != nil {
+			return http.StatusBadRequest, encoder.MustEncode(dsapid.Table{
+				"error": err.Error(),
+			})
+		}
+	} else if len(manifest.Files) != 1 {
+		return http.StatusBadRequest, encoder.MustEncode(dsapid.Table{
+			"error": "streamed uploads carry exactly one file, use multipart/form-data",
+		})
+	}
+
+	if _, ok := manifests.GetOK(manifest.Uuid); ok {
+		log.WithFields(log.Fields{
+			"user_uuid":     user.GetId(),
+			"user_name":     user.GetName(),
+			"image_uuid":    manifest.Uuid,
+			"image_name":    manifest.Name,
+			"image_version": manifest.Version,
+		}).Warn("uploading duplicate image")
+
+		return http.StatusInternalServerError, encoder.MustEncode(dsapid.Table{
+			"error": "image already exists",
+		})
+	}
+
+	log.WithFields(log.Fields{
+		"user_uuid":     user.GetId(),
+		"user_name":     user.GetName(),
+		"image_uuid":    manifest.Uuid,
+		"image_name":    manifest.Name,
+		"image_version": manifest.Version,
+	}).Info("uploading image")
 
-			if user.HasRoles(dsapid.UserRoleDatasetAdmin) {
-				manifest.State = dsapid.ManifestStateActive
+	prepareUpload(manifest, user)
+
+	var err error
+
+	if multipart_upload {
+		err = storeUploadParts(manifests, manifest, user, parts)
+	} else if err = storeUpload(manifests, manifest, &manifest.Files[0], user, file); err == nil {
+		err = addUpload(manifests, manifest)
+	}
+
+	if err != nil {
+		if manifest.Uuid != "" {
+			manifests.Delete(manifest.Uuid)
+		}
+
+		return uploadFailed(encoder, err)
+	}
+
+	return http.StatusOK, encoder.MustEncode(manifest)
+}
+
+// uploadParts assigns the form files to the files of manifest. Every file
+// needs exactly one part and no part may be left over.
+func uploadParts(manifest *dsapid.ManifestResource, form *multipart.Form) ([]*multipart.FileHeader, error) {
+	parts := make([]*multipart.FileHeader, len(manifest.Files))
+
+	for name, headers := range form.File {
+		if name == "manifest" {
+			continue
+		}
+
+		for _, header := range headers {
+			idx, ok := uploadFileIndex(manifest, name, header.Filename)
+			if !ok {
+				return nil, fmt.Errorf("unexpected file part %q", name)
 			}
 
-			if file, _, err := req.FormFile("file"); err != nil {
-				log.WithFields(log.Fields{
-					"error": err.Error(),
-				}).Error("there was an error reading the data file")
-				goto errCancel
-			} else {
-				if err = os.MkdirAll(manifests.ManifestPath(manifest), 0770); err == nil {
-					if file_out, err := os.Create(manifests.FilePath(manifest, &manifest.Files[0])); err == nil {
-						defer file_out.Close()
-
-						hash_md5 := md5.New()
-						hash_sha1 := sha1.New()
-
-						writer := io.MultiWriter(hash_md5, hash_sha1, file_out)
-
-						if _, err := io.Copy(writer, file); err == nil {
-							md5_sum := hex.EncodeToString(hash_md5.Sum(nil))
-							sha1_sum := hex.EncodeToString(hash_sha1.Sum(nil))
-
-							if manifest.Files[0].Md5 != "" && manifest.Files[0].Md5 != md5_sum {
-								log.WithFields(log.Fields{
-									"user_uuid":     user.GetId(),
-									"user_name":     user.GetName(),
-									"file_path":     manifest.Files[0].Path,
-									"checksum_algo": "md5",
-								}).Warnf("checksum missmatch on uploaded file: got %s expected %s", md5_sum, manifest.Files[0].Md5)
-								goto errCancel
-							}
-
-							if manifest.Files[0].Sha1 != "" && manifest.Files[0].Sha1 != sha1_sum {
-								log.WithFields(log.Fields{
-									"user_uuid":     user.GetId(),
-									"user_name":     user.GetName(),
-									"file_path":     manifest.Files[0].Path,
-									"checksum_algo": "sha1",
-								}).Warnf("checksum missmatch on uploaded file: got %s expected %s", sha1_sum, manifest.Files[0].Sha1)
-								goto errCancel
-							}
-
-							manifest.Files[0].Md5 = md5_sum
-							manifest.Files[0].Sha1 = sha1_sum
-
-							manifests.Add(manifest.Uuid, manifest)
-
-							return http.StatusOK, encoder.MustEncode(manifest)
-						}
-					}
-				}
+			if parts[idx] != nil {
+				return nil, fmt.Errorf("duplicate file part for %s", manifest.Files[idx].Path)
 			}
+
+			parts[idx] = header
+		}
+	}
+
+	for idx, part := range parts {
+		if part == nil {
+			return nil, fmt.Errorf("missing file part for %s", manifest.Files[idx].Path)
 		}
 	}
 
-errCancel:
-	if manifest != nil && manifest.Uuid != "" {
-		manifests.Delete(manifest.Uuid)
+	return parts, nil
+}
+
+// uploadFileIndex matches a form file to a file of manifest: "file<idx>"
+// selects by index, otherwise the part name or its filename has to equal
+// the file path. A part named "file" still works for single file images.
+func uploadFileIndex(manifest *dsapid.ManifestResource, name string, filename string) (int, bool) {
+	if strings.HasPrefix(name, "file") {
+		if idx, err := strconv.Atoi(strings.TrimPrefix(name, "file")); err == nil {
+			return idx, idx >= 0 && idx < len(manifest.Files)
+		}
+	}
+
+	for idx, file := range manifest.Files {
+		if name == path.Base(file.Path) {
+			return idx, true
+		}
+	}
+
+	for idx, file := range manifest.Files {
+		if filename != "" && path.Base(filename) == path.Base(file.Path) {
+			return idx, true
+		}
+	}
+
+	if name == "file" && len(manifest.Files) == 1 {
+		return 0, true
+	}
+
+	return 0, false
+}
+
+// uniqueFilePaths rejects manifests whose files would be stored under the
+// same name.
+func uniqueFilePaths(manifest *dsapid.ManifestResource) error {
+	seen := make(map[string]bool)
+
+	for _, file := range manifest.Files {
+		name := path.Base(file.Path)
+
+		if seen[name] {
+			return fmt.Errorf("duplicate file path %s", file.Path)
+		}
+
+		seen[name] = true
+	}
+
+	return nil
+}
+
+func storeUploadParts(manifests storage.ManifestStorage, manifest *dsapid.ManifestResource, user middleware.User, parts []*multipart.FileHeader) error {
+	for idx, part := range parts {
+		file, err := part.Open()
+		if err != nil {
+			return err
+		}
+
+		err = storeUpload(manifests, manifest, &manifest.Files[idx], user, file)
+		file.Close()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return addUpload(manifests, manifest)
+}
+
+// storeUpload copies file into the storage while computing its checksums
+// which have to match those given in the manifest.
+func storeUpload(manifests storage.ManifestStorage, manifest *dsapid.ManifestResource, manifest_file *dsapid.ManifestFileResource, user middleware.User, file io.Reader) error {
+	file_out, err := manifests.CreateFile(manifest, manifest_file)
+	if err != nil {
+		return err
+	}
+	defer file_out.Close()
+
+	hash_md5 := md5.New()
+	hash_sha1 := sha1.New()
+
+	writer := io.MultiWriter(hash_md5, hash_sha1, file_out)
+
+	size, err := io.Copy(writer, file)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err.Error(),
+		}).Error("there was an error reading the data file")
+		return err
+	}
+
+	if err := file_out.Close(); err != nil {
+		return err
+	}
+
+	md5_sum := hex.EncodeToString(hash_md5.Sum(nil))
+	sha1_sum := hex.EncodeToString(hash_sha1.Sum(nil))
+
+	if manifest_file.Md5 != "" && manifest_file.Md5 != md5_sum {
+		log.WithFields(log.Fields{
+			"user_uuid":     user.GetId(),
+			"user_name":     user.GetName(),
+			"file_path":     manifest_file.Path,
+			"checksum_algo": "md5",
+		}).Warnf("checksum missmatch on uploaded file: got %s expected %s", md5_sum, manifest_file.Md5)
+		return errChecksumMismatch
+	}
+
+	if manifest_file.Sha1 != "" && manifest_file.Sha1 != sha1_sum {
+		log.WithFields(log.Fields{
+			"user_uuid":     user.GetId(),
+			"user_name":     user.GetName(),
+			"file_path":     manifest_file.Path,
+			"checksum_algo": "sha1",
+		}).Warnf("checksum missmatch on uploaded file: got %s expected %s", sha1_sum, manifest_file.Sha1)
+		return errChecksumMismatch
+	}
+
+	if manifest_file.Size != 0 && manifest_file.Size != size {
+		log.WithFields(log.Fields{
+			"user_uuid": user.GetId(),
+			"user_name": user.GetName(),
+			"file_path": manifest_file.Path,
+		}).Warnf("size missmatch on uploaded file: got %d expected %d", size, manifest_file.Size)
+		return errSizeMismatch
+	}
+
+	manifest_file.Md5 = md5_sum
+	manifest_file.Sha1 = sha1_sum
+	manifest_file.Size = size
+
+	return nil
+}
+
+func addUpload(manifests storage.ManifestStorage, manifest *dsapid.ManifestResource) error {
+	if err := manifests.Add(manifest.Uuid, manifest); err != nil {
+		log.WithFields(log.Fields{
+			"image_uuid": manifest.Uuid,
+		}).Errorf("can't save manifest: %s", err)
+		return err
+	}
+
+	return nil
+}
+
+func uploadFailed(encoder middleware.OutputEncoder, err error) (int, []byte) {
+	if err == errChecksumMismatch || err == errSizeMismatch {
+		return http.StatusBadRequest, encoder.MustEncode(dsapid.Table{
+			"error": err.Error(),
+		})
+	}
+
+	if middleware.IsBodyTooLarge(err) {
+		return http.StatusRequestEntityTooLarge, encoder.MustEncode(dsapid.Table{
+			"error": "upload too large",
+		})
 	}
 
-errNoManifest:
 	return http.StatusInternalServerError, encoder.MustEncode(dsapid.Table{
 		"error": "upload failed",
 	})
 }
+
+// prepareUpload sets the state and owner of an uploaded manifest according
+// to the roles of user.
+func prepareUpload(manifest *dsapid.ManifestResource, user middleware.User) {
+	manifest.PublishedAt = time.Now()
+	manifest.State = dsapid.ManifestStatePending
+
+	if !user.HasRoles(dsapid.UserRoleDatasetManage) && !user.HasRoles(dsapid.UserRoleDatasetAdmin) {
+		manifest.Owner = user.GetId()
+	}
+
+	if user.HasRoles(dsapid.UserRoleDatasetAdmin) {
+		manifest.State = dsapid.ManifestStateActive
+	}
+}
@@ -0,0 +1,244 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/MerlinDMC/dsapid"
+	"github.com/MerlinDMC/dsapid/converter/decoder"
+	"github.com/MerlinDMC/dsapid/server/middleware"
+	"github.com/MerlinDMC/dsapid/server/upload"
+	"github.com/MerlinDMC/dsapid/storage"
+	log "github.com/Sirupsen/logrus"
+	"github.com/go-martini/martini"
+	"net/http"
+	"strconv"
+)
+
+// ApiPostUploadSession starts a resumable upload for the manifest in the
+// request body. The file is sent with ApiPutUploadChunk afterwards. Only
+// images with a single file can be uploaded this way; ApiPostFileUpload
+// takes images with several files as multipart/form-data.
+func ApiPostUploadSession(encoder middleware.OutputEncoder, uploads upload.Manager, users storage.UserStorage, user middleware.User, req *http.Request) (int, []byte) {
+	var data dsapid.Table
+
+	if err := json.NewDecoder(req.Body).Decode(&data); err != nil || data == nil {
+		return http.StatusBadRequest, encoder.MustEncode(dsapid.Table{
+			"error": "request body is not a JSON object",
+		})
+	}
+
+	if problems := decoder.ValidateManifest(data, decoder.ValidationOptions{}); len(problems) > 0 {
+		return invalidManifest(encoder, problems)
+	}
+
+	manifest := decoder.DecodeToManifest(data, dsapid.SyncProviderCommunity, users)
+
+	prepareUpload(manifest, user)
+
+	session, err := uploads.Create(manifest, user.GetId())
+
+	switch err {
+	case nil:
+	case upload.ErrImageExists, upload.ErrUploadInProgress:
+		return http.StatusConflict, encoder.MustEncode(dsapid.Table{
+			"error": err.Error(),
+		})
+	case upload.ErrNoFile, upload.ErrMultipleFiles, upload.ErrInvalidSize:
+		return http.StatusBadRequest, encoder.MustEncode(dsapid.Table{
+			"error": err.Error(),
+		})
+	default:
+		log.WithFields(log.Fields{
+			"image_uuid": manifest.Uuid,
+		}).Errorf("can't create upload session: %s", err)
+
+		return http.StatusInternalServerError, encoder.MustEncode(dsapid.Table{
+			"error": "upload failed",
+		})
+	}
+
+	log.WithFields(log.Fields{
+		"user_uuid":     user.GetId(),
+		"user_name":     user.GetName(),
+		"upload_uuid":   session.Uuid,
+		"image_uuid":    manifest.Uuid,
+		"image_name":    manifest.Name,
+		"image_version": manifest.Version,
+		"size":          session.Size,
+	}).Info("starting upload session")
+
+	return http.StatusCreated, encoder.MustEncode(session)
+}
+
+func ApiGetUploadSession(encoder middleware.OutputEncoder, params martini.Params, uploads upload.Manager, user middleware.User) (int, []byte) {
+	session, ok := ownUploadSession(uploads, params["id"], user)
+	if !ok {
+		return uploadSessionNotFound(encoder)
+	}
+
+	return http.StatusOK, encoder.MustEncode(session)
+}
+
+// ApiPutUploadChunk stores the byte range given by the Content-Range header
+// or by ?offset= and the length of the body.
+func ApiPutUploadChunk(encoder middleware.OutputEncoder, params martini.Params, uploads upload.Manager, user middleware.User, req *http.Request) (int, []byte) {
+	session, ok := ownUploadSession(uploads, params["id"], user)
+	if !ok {
+		return uploadSessionNotFound(encoder)
+	}
+
+	offset, length, err := chunkRange(req, session.Size)
+	if err != nil {
+		return http.StatusBadRequest, encoder.MustEncode(dsapid.Table{
+			"error": err.Error(),
+		})
+	}
+
+	session, err = uploads.Write(session.Uuid, offset, length, req.Body)
+
+	switch err {
+	case nil:
+		return http.StatusOK, encoder.MustEncode(session)
+	case upload.ErrSessionNotFound:
+		return uploadSessionNotFound(encoder)
+	case upload.ErrInvalidRange:
+		return http.StatusRequestedRangeNotSatisfiable, encoder.MustEncode(dsapid.Table{
+			"error": err.Error(),
+		})
+	}
+
+	if middleware.IsBodyTooLarge(err) {
+		return http.StatusRequestEntityTooLarge, encoder.MustEncode(dsapid.Table{
+			"error":   "chunk too large",
+			"session": session,
+		})
+	}
+
+	log.WithFields(log.Fields{
+		"user_uuid":   user.GetId(),
+		"user_name":   user.GetName(),
+		"upload_uuid": session.Uuid,
+		"offset":      offset,
+		"length":      length,
+	}).Warnf("upload chunk failed: %s", err)
+
+	// whatever was received is kept, the client resends the missing parts
+	return http.StatusBadRequest, encoder.MustEncode(dsapid.Table{
+		"error":   err.Error(),
+		"session": session,
+	})
+}
+
+func ApiPostUploadFinalize(encoder middleware.OutputEncoder, params martini.Params, uploads upload.Manager, user middleware.User) (int, []byte) {
+	session, ok := ownUploadSession(uploads, params["id"], user)
+	if !ok {
+		return uploadSessionNotFound(encoder)
+	}
+
+	manifest, err := uploads.Finalize(session.Uuid)
+
+	switch err {
+	case nil:
+		log.WithFields(log.Fields{
+			"user_uuid":     user.GetId(),
+			"user_name":     user.GetName(),
+			"upload_uuid":   session.Uuid,
+			"image_uuid":    manifest.Uuid,
+			"image_name":    manifest.Name,
+			"image_version": manifest.Version,
+		}).Info("finished upload session")
+
+		return http.StatusOK, encoder.MustEncode(manifest)
+	case upload.ErrSessionNotFound:
+		return uploadSessionNotFound(encoder)
+	case upload.ErrIncomplete:
+		return http.StatusConflict, encoder.MustEncode(dsapid.Table{
+			"error":   err.Error(),
+			"missing": session.Missing,
+		})
+	case upload.ErrImageExists:
+		return http.StatusConflict, encoder.MustEncode(dsapid.Table{
+			"error": err.Error(),
+		})
+	case upload.ErrChecksumMismatch:
+		return http.StatusBadRequest, encoder.MustEncode(dsapid.Table{
+			"error": err.Error(),
+		})
+	}
+
+	log.WithFields(log.Fields{
+		"upload_uuid": session.Uuid,
+		"image_uuid":  session.ImageUuid,
+	}).Errorf("can't finalize upload: %s", err)
+
+	return http.StatusInternalServerError, encoder.MustEncode(dsapid.Table{
+		"error": "upload failed",
+	})
+}
+
+func ApiDeleteUploadSession(encoder middleware.OutputEncoder, params martini.Params, uploads upload.Manager, user middleware.User) (int, []byte) {
+	session, ok := ownUploadSession(uploads, params["id"], user)
+	if !ok {
+		return uploadSessionNotFound(encoder)
+	}
+
+	if err := uploads.Abort(session.Uuid); err != nil {
+		return uploadSessionNotFound(encoder)
+	}
+
+	log.WithFields(log.Fields{
+		"user_uuid":   user.GetId(),
+		"user_name":   user.GetName(),
+		"upload_uuid": session.Uuid,
+		"image_uuid":  session.ImageUuid,
+	}).Info("aborted upload session")
+
+	return http.StatusNoContent, nil
+}
+
+// ownUploadSession hides sessions of other users from everyone but admins.
+func ownUploadSession(uploads upload.Manager, id string, user middleware.User) (upload.Session, bool) {
+	session, ok := uploads.Get(id)
+	if !ok || (session.Owner != user.GetId() && !user.HasRoles(dsapid.UserRoleAdmin)) {
+		return upload.Session{}, false
+	}
+
+	return session, true
+}
+
+func uploadSessionNotFound(encoder middleware.OutputEncoder) (int, []byte) {
+	return http.StatusNotFound, encoder.MustEncode(dsapid.Table{
+		"error": "upload session not found",
+	})
+}
+
+// chunkRange reads "Content-Range: bytes first-last/size" or falls back to
+// ?offset= with the Content-Length of the request.
+func chunkRange(req *http.Request, size int64) (offset int64, length int64, err error) {
+	if v := req.Header.Get("Content-Range"); v != "" {
+		var first, last int64
+		var total string
+
+		if _, err := fmt.Sscanf(v, "bytes %d-%d/%s", &first, &last, &total); err != nil || last < first {
+			return 0, 0, fmt.Errorf("invalid Content-Range: %s", v)
+		}
+
+		if total != "*" && total != strconv.FormatInt(size, 10) {
+			return 0, 0, fmt.Errorf("Content-Range size differs from the file size %d", size)
+		}
+
+		return first, last - first + 1, nil
+	}
+
+	if req.ContentLength < 0 {
+		return 0, 0, fmt.Errorf("Content-Length or Content-Range required")
+	}
+
+	if v := req.URL.Query().Get("offset"); v != "" {
+		if offset, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("invalid offset: %s", v)
+		}
+	}
+
+	return offset, req.ContentLength, nil
+}
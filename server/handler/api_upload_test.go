@@ -0,0 +1,223 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"github.com/MerlinDMC/dsapid"
+	"github.com/MerlinDMC/dsapid/server/middleware"
+	"github.com/MerlinDMC/dsapid/storage"
+	"github.com/pborman/uuid"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var testUploader = &dsapid.UserResource{
+	Uuid:  "352971aa-31ba-496c-9ade-a379feaecd52",
+	Name:  "uploader",
+	Roles: []dsapid.UserRoleName{dsapid.UserRoleDatasetUpload, dsapid.UserRoleDatasetAdmin},
+}
+
+// testUploadManifest returns a manifest for the files given by path and
+// size.
+func testUploadManifest(files ...interface{}) (string, string) {
+	id := uuid.New()
+	items := make([]string, 0)
+
+	for i := 0; i+1 < len(files); i += 2 {
+		items = append(items, fmt.Sprintf(`{"path": "%s", "size": %d}`, files[i], files[i+1]))
+	}
+
+	return id, fmt.Sprintf(`{
+		"uuid": "%s", "name": "upload", "version": "1.0.0", "os": "smartos",
+		"type": "zone-dataset", "files": [%s]
+	}`, id, strings.Join(items, ", "))
+}
+
+func postUpload(t *testing.T, manifests storage.ManifestStorage, req *http.Request) (int, string) {
+	users, _ := storage.NewUserStorage("")
+
+	status, body := ApiPostFileUpload(testEncoder{}, manifests, users, testUploader, req)
+
+	return status, string(body)
+}
+
+func checkUploadedFile(t *testing.T, manifests storage.ManifestStorage, id string, idx int, data string) {
+	manifest, ok := manifests.GetOK(id)
+	if !ok {
+		t.Fatalf("image %s was not stored", id)
+	}
+
+	r, err := manifests.OpenFile(manifest, &manifest.Files[idx])
+	if err != nil {
+		t.Fatalf("can't open file %d: %s", idx, err)
+	}
+	defer r.Close()
+
+	if stored, _ := ioutil.ReadAll(r); string(stored) != data {
+		t.Errorf("file %d holds %q instead of %q", idx, stored, data)
+	}
+}
+
+func TestApiPostFileUploadManifestHeader(t *testing.T) {
+	manifests, cleanup := newTestManifestStorage(t)
+	defer cleanup()
+
+	for _, encode := range []func(string) string{
+		func(s string) string { return s },
+		func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+	} {
+		id, manifest := testUploadManifest("upload.zfs", 9)
+
+		req, _ := http.NewRequest("POST", "/api/upload", strings.NewReader("file data"))
+		req.Header.Set("X-Manifest", encode(manifest))
+
+		if status, body := postUpload(t, manifests, req); status != http.StatusOK {
+			t.Fatalf("upload failed with %d: %s", status, body)
+		}
+
+		checkUploadedFile(t, manifests, id, 0, "file data")
+	}
+}
+
+func TestApiPostFileUploadManifestPrefix(t *testing.T) {
+	manifests, cleanup := newTestManifestStorage(t)
+	defer cleanup()
+
+	// the file follows the manifest without a separator so the decoder
+	// reads ahead into it
+	data := strings.Repeat("0123456789", 1000)
+	id, manifest := testUploadManifest("upload.zfs", len(data))
+
+	req, _ := http.NewRequest("POST", "/api/upload", strings.NewReader(manifest+data))
+
+	if status, body := postUpload(t, manifests, req); status != http.StatusOK {
+		t.Fatalf("upload failed with %d: %s", status, body)
+	}
+
+	checkUploadedFile(t, manifests, id, 0, data)
+}
+
+func TestApiPostFileUploadMultipart(t *testing.T) {
+	manifests, cleanup := newTestManifestStorage(t)
+	defer cleanup()
+
+	id, manifest := testUploadManifest("root.zfs", 4, "data.zfs", 4)
+
+	body := new(bytes.Buffer)
+	form := multipart.NewWriter(body)
+
+	w, _ := form.CreateFormFile("manifest", "manifest.json")
+	io.WriteString(w, manifest)
+	w, _ = form.CreateFormFile("file1", "upload")
+	io.WriteString(w, "data")
+	w, _ = form.CreateFormFile("upload", "root.zfs")
+	io.WriteString(w, "root")
+	form.Close()
+
+	req, _ := http.NewRequest("POST", "/api/upload", body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	if status, body := postUpload(t, manifests, req); status != http.StatusOK {
+		t.Fatalf("upload failed with %d: %s", status, body)
+	}
+
+	checkUploadedFile(t, manifests, id, 0, "root")
+	checkUploadedFile(t, manifests, id, 1, "data")
+}
+
+func TestApiPostFileUploadTooLarge(t *testing.T) {
+	manifests, cleanup := newTestManifestStorage(t)
+	defer cleanup()
+
+	id, manifest := testUploadManifest("upload.zfs", 100)
+
+	req, _ := http.NewRequest("POST", "/api/upload", ioutil.NopCloser(strings.NewReader(manifest+strings.Repeat("x", 100))))
+	req.ContentLength = -1
+
+	middleware.LimitBody(int64(len(manifest)+50)).(func(*http.Request, http.ResponseWriter))(req, httptest.NewRecorder())
+
+	if status, body := postUpload(t, manifests, req); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 but got %d: %s", status, body)
+	}
+
+	if _, ok := manifests.GetOK(id); ok {
+		t.Error("a truncated upload should not be stored")
+	}
+}
+
+func TestUploadFileIndex(t *testing.T) {
+	manifest := &dsapid.ManifestResource{
+		Files: []dsapid.ManifestFileResource{
+			{Path: "images/root.zfs"},
+			{Path: "images/data.zfs"},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		filename string
+		idx      int
+		ok       bool
+	}{
+		{"file0", "", 0, true},
+		{"file1", "whatever", 1, true},
+		{"file2", "", 2, false},
+		{"file-1", "", 0, false},
+		{"data.zfs", "", 1, true},
+		{"upload", "/tmp/root.zfs", 0, true},
+		{"file", "", 0, false},
+		{"upload", "other.zfs", 0, false},
+	}
+
+	for _, test := range tests {
+		if idx, ok := uploadFileIndex(manifest, test.name, test.filename); ok != test.ok || (ok && idx != test.idx) {
+			t.Errorf("%s (%s) should match %d %t but got %d %t", test.name, test.filename, test.idx, test.ok, idx, ok)
+		}
+	}
+
+	single := &dsapid.ManifestResource{
+		Files: []dsapid.ManifestFileResource{{Path: "root.zfs"}},
+	}
+
+	if idx, ok := uploadFileIndex(single, "file", ""); !ok || idx != 0 {
+		t.Errorf("\"file\" should select the single file but got %d %t", idx, ok)
+	}
+}
+
+func TestUploadParts(t *testing.T) {
+	manifest := &dsapid.ManifestResource{
+		Files: []dsapid.ManifestFileResource{
+			{Path: "root.zfs"},
+			{Path: "data.zfs"},
+		},
+	}
+
+	part := func(filename string) []*multipart.FileHeader {
+		return []*multipart.FileHeader{{Filename: filename}}
+	}
+
+	parts, err := uploadParts(manifest, &multipart.Form{File: map[string][]*multipart.FileHeader{
+		"manifest": part("manifest.json"),
+		"data.zfs": part("upload"),
+		"file0":    part("upload"),
+	}})
+	if err != nil || len(parts) != 2 || parts[0] == nil || parts[1] == nil {
+		t.Fatalf("parts should match both files but got %v: %v", parts, err)
+	}
+
+	for name, files := range map[string]map[string][]*multipart.FileHeader{
+		"duplicate": {"file0": part("upload"), "root.zfs": part("upload"), "file1": part("upload")},
+		"missing":   {"file0": part("upload")},
+		"extra":     {"file0": part("upload"), "file1": part("upload"), "other": part("other.zfs")},
+	} {
+		if _, err := uploadParts(manifest, &multipart.Form{File: files}); err == nil {
+			t.Errorf("%s part should be rejected", name)
+		}
+	}
+}
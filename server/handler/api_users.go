@@ -48,7 +48,16 @@ func ApiPutUsers(encoder middleware.OutputEncoder, params martini.Params, users
 			u.Uuid = uuid.New()
 		}
 
-		users.Add(u.Uuid, &u)
+		if err := users.Add(u.Uuid, &u); err != nil {
+			log.WithFields(log.Fields{
+				"user_uuid": u.Uuid,
+				"user_name": u.Name,
+			}).Errorf("can't save user: %s", err)
+
+			return http.StatusInternalServerError, encoder.MustEncode(dsapid.Table{
+				"error": "saving users failed",
+			})
+		}
 	}
 
 	return http.StatusOK, encoder.MustEncode(dsapid.Table{
@@ -61,6 +70,8 @@ func ApiUpdateUser(encoder middleware.OutputEncoder, params martini.Params, user
 	data, _ := ioutil.ReadAll(req.Body)
 
 	if u, ok := users.GetOK(params["id"]); ok {
+		var err error
+
 		switch action {
 		case "set_token":
 			log.WithFields(log.Fields{
@@ -71,7 +82,7 @@ func ApiUpdateUser(encoder middleware.OutputEncoder, params martini.Params, user
 
 			u.Token = string(data)
 
-			users.Update(u.Uuid, u)
+			err = users.Update(u.Uuid, u)
 			break
 		case "add_role":
 			log.WithFields(log.Fields{
@@ -86,7 +97,7 @@ func ApiUpdateUser(encoder middleware.OutputEncoder, params martini.Params, user
 				u.Roles = append(u.Roles, role_name)
 			}
 
-			users.Update(u.Uuid, u)
+			err = users.Update(u.Uuid, u)
 			break
 		case "remove_role":
 			log.WithFields(log.Fields{
@@ -107,10 +118,21 @@ func ApiUpdateUser(encoder middleware.OutputEncoder, params martini.Params, user
 				}
 			}
 
-			users.Update(u.Uuid, u)
+			err = users.Update(u.Uuid, u)
 			break
 		}
 
+		if err != nil {
+			log.WithFields(log.Fields{
+				"user_uuid": u.GetId(),
+				"user_name": u.GetName(),
+			}).Errorf("can't save user: %s", err)
+
+			return http.StatusInternalServerError, encoder.MustEncode(dsapid.Table{
+				"error": "user update failed",
+			})
+		}
+
 		return http.StatusOK, encoder.MustEncode(dsapid.Table{
 			"ok": "user updated",
 		})
@@ -128,7 +150,16 @@ func ApiDeleteUser(encoder middleware.OutputEncoder, params martini.Params, user
 			"user_name": u.GetName(),
 		}).Info("deleting user")
 
-		users.Delete(u.Uuid)
+		if err := users.Delete(u.Uuid); err != nil {
+			log.WithFields(log.Fields{
+				"user_uuid": u.GetId(),
+				"user_name": u.GetName(),
+			}).Errorf("can't save users: %s", err)
+
+			return http.StatusInternalServerError, encoder.MustEncode(dsapid.Table{
+				"error": "user delete failed",
+			})
+		}
 
 		return http.StatusOK, encoder.MustEncode(dsapid.Table{
 			"ok": "user deleted",
@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"encoding/json"
+	"github.com/MerlinDMC/dsapid"
+	"github.com/MerlinDMC/dsapid/converter/decoder"
+	"github.com/MerlinDMC/dsapid/server/middleware"
+	"net/http"
+	"strconv"
+)
+
+// ApiPostValidate checks the manifest in the request body the way uploads
+// do. ?allow_no_uuid and ?allow_no_files relax the checks like IMGAPI
+// CreateImage does.
+func ApiPostValidate(encoder middleware.OutputEncoder, req *http.Request) (int, []byte) {
+	var data dsapid.Table
+
+	if err := json.NewDecoder(req.Body).Decode(&data); err != nil || data == nil {
+		return http.StatusBadRequest, encoder.MustEncode(dsapid.Table{
+			"error": "request body is not a JSON object",
+		})
+	}
+
+	query := req.URL.Query()
+
+	allow_no_uuid, _ := strconv.ParseBool(query.Get("allow_no_uuid"))
+	allow_no_files, _ := strconv.ParseBool(query.Get("allow_no_files"))
+
+	problems := decoder.ValidateManifest(data, decoder.ValidationOptions{
+		AllowNoUuid:  allow_no_uuid,
+		AllowNoFiles: allow_no_files,
+	})
+
+	status := http.StatusOK
+	if len(problems) > 0 {
+		status = http.StatusUnprocessableEntity
+	}
+
+	return status, encoder.MustEncode(dsapid.Table{
+		"valid":    len(problems) == 0,
+		"problems": problems,
+	})
+}
+
+func invalidManifest(encoder middleware.OutputEncoder, problems decoder.Problems) (int, []byte) {
+	return http.StatusBadRequest, encoder.MustEncode(dsapid.Table{
+		"error":    "invalid manifest",
+		"problems": problems,
+	})
+}
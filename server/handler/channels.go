@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"github.com/MerlinDMC/dsapid"
+	"github.com/MerlinDMC/dsapid/converter"
+	"github.com/MerlinDMC/dsapid/server/middleware"
+	"github.com/MerlinDMC/dsapid/storage"
+	log "github.com/Sirupsen/logrus"
+	"github.com/go-martini/martini"
+	"net/http"
+	"net/url"
+)
+
+func ImgapiChannels(encoder middleware.OutputEncoder, channels dsapid.Channels) (int, []byte) {
+	if channels == nil {
+		channels = make(dsapid.Channels, 0)
+	}
+
+	return http.StatusOK, encoder.MustEncode(channels)
+}
+
+// imgapiChannelFilter restricts a request to the channel given by
+// ?channel= or the default channel. It returns nil if all channels are
+// requested by "*" or no channels are configured.
+func imgapiChannelFilter(channels dsapid.Channels, query url.Values) (storage.ManifestFilter, *imgapiError) {
+	name := query.Get("channel")
+
+	if len(channels) == 0 {
+		if name != "" {
+			return nil, invalidParameter("channel", name)
+		}
+
+		return nil, nil
+	}
+
+	switch name {
+	case "*":
+		return nil, nil
+	case "":
+		if channel, ok := channels.Default(); ok {
+			return storage.FilterManifestChannel(channel.Name, true), nil
+		}
+
+		return nil, nil
+	}
+
+	channel, ok := channels.Get(name)
+	if !ok {
+		return nil, invalidParameter("channel", name)
+	}
+
+	return storage.FilterManifestChannel(channel.Name, channel.Default), nil
+}
+
+// manifestChannels returns the channels manifest is in; manifests without
+// channels are in the default one.
+func manifestChannels(manifest *dsapid.ManifestResource, channels dsapid.Channels) []string {
+	if len(manifest.Channels) == 0 {
+		if channel, ok := channels.Default(); ok {
+			return []string{channel.Name}
+		}
+	}
+
+	return manifest.Channels
+}
+
+func ApiPostDatasetChannel(encoder middleware.OutputEncoder, params martini.Params, manifests storage.ManifestStorage, converter converter.DsapiManifestEncoder, channels dsapid.Channels, user middleware.User) (int, []byte) {
+	manifest, ok := manifests.GetOK(params["id"])
+	if !ok {
+		return http.StatusNotFound, encoder.MustEncode(dsapid.Table{
+			"error": "dataset not found",
+		})
+	}
+
+	if _, ok := channels.Get(params["channel"]); !ok {
+		return http.StatusNotFound, encoder.MustEncode(dsapid.Table{
+			"error": "channel not found",
+		})
+	}
+
+	current := manifestChannels(manifest, channels)
+
+	for _, name := range current {
+		if name == params["channel"] {
+			return http.StatusOK, encoder.MustEncode(converter.EncodeWithExtra(manifest))
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"user_uuid":     user.GetId(),
+		"user_name":     user.GetName(),
+		"image_uuid":    manifest.Uuid,
+		"image_name":    manifest.Name,
+		"image_version": manifest.Version,
+		"channel":       params["channel"],
+	}).Info("adding image to channel")
+
+	manifest = storage.CopyManifest(manifest)
+	manifest.Channels = append(append(make([]string, 0, len(current)+1), current...), params["channel"])
+
+	return updateChannels(encoder, manifests, converter, manifest, user)
+}
+
+func ApiDeleteDatasetChannel(encoder middleware.OutputEncoder, params martini.Params, manifests storage.ManifestStorage, converter converter.DsapiManifestEncoder, channels dsapid.Channels, user middleware.User) (int, []byte) {
+	manifest, ok := manifests.GetOK(params["id"])
+	if !ok {
+		return http.StatusNotFound, encoder.MustEncode(dsapid.Table{
+			"error": "dataset not found",
+		})
+	}
+
+	current := manifestChannels(manifest, channels)
+	remaining := make([]string, 0)
+
+	for _, name := range current {
+		if name != params["channel"] {
+			remaining = append(remaining, name)
+		}
+	}
+
+	if len(remaining) == len(current) {
+		return http.StatusNotFound, encoder.MustEncode(dsapid.Table{
+			"error": "dataset not in channel",
+		})
+	}
+
+	// without any channel the image would fall back to the default one
+	if len(remaining) == 0 {
+		return http.StatusConflict, encoder.MustEncode(dsapid.Table{
+			"error": "dataset has to stay in at least one channel",
+		})
+	}
+
+	log.WithFields(log.Fields{
+		"user_uuid":     user.GetId(),
+		"user_name":     user.GetName(),
+		"image_uuid":    manifest.Uuid,
+		"image_name":    manifest.Name,
+		"image_version": manifest.Version,
+		"channel":       params["channel"],
+	}).Info("removing image from channel")
+
+	manifest = storage.CopyManifest(manifest)
+	manifest.Channels = remaining
+
+	return updateChannels(encoder, manifests, converter, manifest, user)
+}
+
+func updateChannels(encoder middleware.OutputEncoder, manifests storage.ManifestStorage, converter converter.DsapiManifestEncoder, manifest *dsapid.ManifestResource, user middleware.User) (int, []byte) {
+	if err := manifests.Update(manifest.Uuid, manifest, revisionAuthor(user)); err != nil {
+		return http.StatusInternalServerError, encoder.MustEncode(dsapid.Table{
+			"error": "update failed",
+		})
+	}
+
+	return http.StatusOK, encoder.MustEncode(converter.EncodeWithExtra(manifest))
+}
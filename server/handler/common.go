@@ -3,6 +3,7 @@ package handler
 import (
 	"fmt"
 	"github.com/MerlinDMC/dsapid"
+	"github.com/MerlinDMC/dsapid/server/fsck"
 	"github.com/MerlinDMC/dsapid/server/middleware"
 	"github.com/MerlinDMC/dsapid/storage"
 	"net/http"
@@ -25,7 +26,7 @@ func CommonPing(encoder middleware.OutputEncoder, user middleware.User) (int, []
 	return http.StatusOK, encoder.MustEncode(pingResponse)
 }
 
-func CommonStatus(encoder middleware.OutputEncoder, manifests storage.ManifestStorage) (int, []byte) {
+func CommonStatus(encoder middleware.OutputEncoder, manifests storage.ManifestStorage, scrubber fsck.Scrubber) (int, []byte) {
 	manifests_count, manifests_size := int64(0), int64(0)
 
 	for manifest := range manifests.List() {
@@ -43,5 +44,20 @@ func CommonStatus(encoder middleware.OutputEncoder, manifests storage.ManifestSt
 		"num_goroutines": runtime.NumGoroutine(),
 	}
 
+	// only counts here, the quarantined images are listed by /api/fsck
+	fsck_status := scrubber.Status()
+	fsck_response := map[string]interface{}{
+		"running": fsck_status.Running,
+		"checked": fsck_status.Checked,
+		"total":   fsck_status.Total,
+	}
+
+	if report := fsck_status.LastReport; report != nil {
+		fsck_response["last_run"] = report.FinishedAt
+		fsck_response["quarantined"] = len(report.Quarantined)
+	}
+
+	statusResponse["fsck"] = fsck_response
+
 	return http.StatusOK, encoder.MustEncode(statusResponse)
 }
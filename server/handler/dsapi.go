@@ -3,14 +3,16 @@ package handler
 import (
 	"encoding/base64"
 	"encoding/hex"
+	"github.com/MerlinDMC/dsapid"
 	"github.com/MerlinDMC/dsapid/converter"
 	"github.com/MerlinDMC/dsapid/server/middleware"
 	"github.com/MerlinDMC/dsapid/storage"
 	"github.com/go-martini/martini"
 	"net/http"
+	"path"
 )
 
-func DsapiList(encoder middleware.OutputEncoder, params martini.Params, manifests storage.ManifestStorage, converter converter.DsapiManifestEncoder, user middleware.User, req *http.Request) (int, []byte) {
+func DsapiList(encoder middleware.OutputEncoder, params martini.Params, manifests storage.ManifestStorage, converter converter.DsapiManifestEncoder, user middleware.User, res http.ResponseWriter, req *http.Request) (int, []byte) {
 	var data []interface{} = make([]interface{}, 0)
 	var filters []storage.ManifestFilter = []storage.ManifestFilter{storage.FilterManifestEnabled()}
 
@@ -32,32 +34,43 @@ func DsapiList(encoder middleware.OutputEncoder, params martini.Params, manifest
 		filters = append(filters, storage.FilterManifestOs(v))
 	}
 
-	for manifest := range manifests.Filter(filters...) {
+	items, err := paginate(manifests, filters, res, req)
+	if err != nil {
+		return http.StatusBadRequest, encoder.MustEncode(dsapid.Table{
+			"error": err.Error(),
+		})
+	}
+
+	for _, manifest := range items {
 		data = append(data, converter.Encode(manifest))
 	}
 
 	return http.StatusOK, encoder.MustEncode(data)
 }
 
-func DsapiDetail(encoder middleware.OutputEncoder, params martini.Params, manifests storage.ManifestStorage, converter converter.DsapiManifestEncoder) (int, []byte) {
-	if manifest, ok := manifests.GetOK(params["id"]); ok {
+func DsapiDetail(encoder middleware.OutputEncoder, params martini.Params, manifests storage.ManifestStorage, converter converter.DsapiManifestEncoder, user middleware.User) (int, []byte) {
+	if manifest, ok := manifests.GetOK(params["id"]); ok && imageVisible(user, manifest) {
 		return http.StatusOK, encoder.MustEncode(converter.Encode(manifest))
 	}
 
 	return http.StatusNotFound, []byte("Not found")
 }
 
-func DsapiFile(params martini.Params, manifests storage.ManifestStorage, res http.ResponseWriter, req *http.Request) {
-	if manifest, ok := manifests.GetOK(params["id"]); ok {
+func DsapiFile(params martini.Params, manifests storage.ManifestStorage, user middleware.User, res http.ResponseWriter, req *http.Request) {
+	if manifest, ok := manifests.GetOK(params["id"]); ok && imageVisible(user, manifest) {
 		for _, file := range manifest.Files {
 			if file.Path == params["path"] {
 				if md5_sum, err := hex.DecodeString(file.Md5); err == nil {
-					res.Header().Set("Content-Type", "application/octet-stream")
-					res.Header().Set("Content-Md5", base64.StdEncoding.EncodeToString(md5_sum))
+					if fin, err := manifests.OpenFile(manifest, &file); err == nil {
+						defer fin.Close()
+
+						res.Header().Set("Content-Type", "application/octet-stream")
+						res.Header().Set("Content-Md5", base64.StdEncoding.EncodeToString(md5_sum))
 
-					http.ServeFile(res, req, manifests.FilePath(manifest, &file))
+						http.ServeContent(res, req, path.Base(file.Path), fin.ModTime(), fin)
 
-					return
+						return
+					}
 				}
 			}
 		}
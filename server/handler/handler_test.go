@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"encoding/json"
+	"github.com/MerlinDMC/dsapid"
+	"github.com/MerlinDMC/dsapid/storage"
+	"github.com/pborman/uuid"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+type testEncoder struct{}
+
+func (me testEncoder) Encode(v ...interface{}) ([]byte, error) {
+	return json.Marshal(v[0])
+}
+
+func (me testEncoder) MustEncode(v ...interface{}) []byte {
+	data, _ := me.Encode(v...)
+
+	return data
+}
+
+func newTestManifestStorage(t *testing.T) (storage.ManifestStorage, func()) {
+	basedir, err := ioutil.TempDir("", "dsapid-handler")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %s", err)
+	}
+
+	return storage.NewManifestStorage(basedir, nil), func() { os.RemoveAll(basedir) }
+}
+
+func newTestManifest(name string, published time.Time) *dsapid.ManifestResource {
+	return &dsapid.ManifestResource{
+		Uuid:        uuid.New(),
+		Name:        name,
+		Version:     "1.0.0",
+		State:       dsapid.ManifestStateActive,
+		Public:      true,
+		Type:        dsapid.ManifestTypeZone,
+		Os:          "smartos",
+		PublishedAt: published,
+	}
+}
@@ -3,52 +3,106 @@ package handler
 import (
 	"encoding/base64"
 	"encoding/hex"
+	"fmt"
+	"github.com/MerlinDMC/dsapid"
 	"github.com/MerlinDMC/dsapid/converter"
 	"github.com/MerlinDMC/dsapid/server/middleware"
 	"github.com/MerlinDMC/dsapid/storage"
 	"github.com/go-martini/martini"
 	"net/http"
+	"path"
 )
 
-func ImgapiList(encoder middleware.OutputEncoder, params martini.Params, manifests storage.ManifestStorage, converter converter.ImgapiManifestEncoder, user middleware.User, req *http.Request) (int, []byte) {
+// ImgapiList implements ListImages of sdc-imgapi including its filters.
+func ImgapiList(encoder middleware.OutputEncoder, params martini.Params, manifests storage.ManifestStorage, converter converter.ImgapiManifestEncoder, channels dsapid.Channels, user middleware.User, res http.ResponseWriter, req *http.Request) (int, []byte) {
 	var data []interface{} = make([]interface{}, 0)
-	var filters []storage.ManifestFilter = []storage.ManifestFilter{storage.FilterManifestEnabled()}
+
+	filters, ierr := imgapiListFilters(user, req.URL.Query())
+	if ierr != nil {
+		return ierr.Encode(encoder)
+	}
+
+	if filter, ierr := imgapiChannelFilter(channels, req.URL.Query()); ierr != nil {
+		return ierr.Encode(encoder)
+	} else if filter != nil {
+		filters = append(filters, filter)
+	}
+
+	items, err := paginate(manifests, filters, res, req)
+	if err, ok := err.(*invalidParamError); ok {
+		return invalidParameter(err.field, err.value).Encode(encoder)
+	} else if err != nil {
+		return http.StatusInternalServerError, encoder.MustEncode(dsapid.Table{
+			"code":    "InternalError",
+			"message": err.Error(),
+		})
+	}
+
+	for _, manifest := range items {
+		data = append(data, converter.Encode(hideAcl(user, manifest)))
+	}
+
+	return http.StatusOK, encoder.MustEncode(data)
+}
+
+// ImgapiLatest returns the newest active image of a name visible to user,
+// optionally restricted by ?os= and ?type=.
+func ImgapiLatest(encoder middleware.OutputEncoder, params martini.Params, manifests storage.ManifestStorage, converter converter.ImgapiManifestEncoder, channels dsapid.Channels, user middleware.User, req *http.Request) (int, []byte) {
+	filters := []storage.ManifestFilter{
+		storage.FilterManifestExactName(params["name"]),
+		storage.FilterManifestState(dsapid.ManifestStateActive),
+	}
 
 	if user.IsGuest() {
 		filters = append(filters, storage.FilterManifestPublic(true))
-	} else {
+	} else if !isImageAdmin(user) {
 		filters = append(filters, storage.FilterManifestForUser(user.GetId()))
 	}
 
-	if v := req.URL.Query().Get("name"); v != "" {
-		filters = append(filters, storage.FilterManifestName(v))
+	if v := req.URL.Query().Get("os"); v != "" {
+		filters = append(filters, storage.FilterManifestExactOs(v))
 	}
 
-	if v := req.URL.Query().Get("version"); v != "" {
-		filters = append(filters, storage.FilterManifestVersion(v))
+	if v := req.URL.Query().Get("type"); v != "" {
+		if _, ok := dsapid.ManifestTypeDescription[dsapid.ManifestType(v)]; !ok {
+			return invalidParameter("type", v).Encode(encoder)
+		}
+
+		filters = append(filters, storage.FilterManifestType(dsapid.ManifestType(v)))
 	}
 
-	if v := req.URL.Query().Get("os"); v != "" {
-		filters = append(filters, storage.FilterManifestOs(v))
+	if filter, ierr := imgapiChannelFilter(channels, req.URL.Query()); ierr != nil {
+		return ierr.Encode(encoder)
+	} else if filter != nil {
+		filters = append(filters, filter)
 	}
 
-	for manifest := range manifests.Filter(filters...) {
-		data = append(data, converter.Encode(manifest))
+	if manifest, ok := storage.LatestByName(manifests.Filter(filters...))[params["name"]]; ok {
+		return http.StatusOK, encoder.MustEncode(converter.Encode(hideAcl(user, manifest)))
 	}
 
-	return http.StatusOK, encoder.MustEncode(data)
+	return (&imgapiError{
+		status:  http.StatusNotFound,
+		code:    "ResourceNotFound",
+		message: fmt.Sprintf("no active image named \"%s\"", params["name"]),
+	}).Encode(encoder)
 }
 
-func ImgapiDetail(encoder middleware.OutputEncoder, params martini.Params, manifests storage.ManifestStorage, converter converter.ImgapiManifestEncoder) (int, []byte) {
-	if manifest, ok := manifests.GetOK(params["id"]); ok {
-		return http.StatusOK, encoder.MustEncode(converter.Encode(manifest))
+func ImgapiDetail(encoder middleware.OutputEncoder, params martini.Params, manifests storage.ManifestStorage, converter converter.ImgapiManifestEncoder, channels dsapid.Channels, user middleware.User, req *http.Request) (int, []byte) {
+	filter, ierr := imgapiChannelFilter(channels, req.URL.Query())
+	if ierr != nil {
+		return ierr.Encode(encoder)
+	}
+
+	if manifest, ok := manifests.GetOK(params["id"]); ok && imageVisible(user, manifest) && (filter == nil || filter.Match(manifest)) {
+		return http.StatusOK, encoder.MustEncode(converter.Encode(hideAcl(user, manifest)))
 	}
 
 	return http.StatusNotFound, []byte("Not found")
 }
 
-func ImgapiFile(params martini.Params, manifests storage.ManifestStorage, res http.ResponseWriter, req *http.Request) {
-	if manifest, ok := manifests.GetOK(params["id"]); ok {
+func ImgapiFile(params martini.Params, manifests storage.ManifestStorage, user middleware.User, res http.ResponseWriter, req *http.Request) {
+	if manifest, ok := manifests.GetOK(params["id"]); ok && imageVisible(user, manifest) {
 		var file_idx int = 0
 
 		if v, ok := params["file_idx"]; ok {
@@ -58,12 +112,16 @@ func ImgapiFile(params martini.Params, manifests storage.ManifestStorage, res ht
 		if len(manifest.Files) > file_idx {
 			file := manifest.Files[file_idx]
 			if md5_sum, err := hex.DecodeString(file.Md5); err == nil {
-				res.Header().Set("Content-Type", "application/octet-stream")
-				res.Header().Set("Content-Md5", base64.StdEncoding.EncodeToString(md5_sum))
+				if fin, err := manifests.OpenFile(manifest, &file); err == nil {
+					defer fin.Close()
+
+					res.Header().Set("Content-Type", "application/octet-stream")
+					res.Header().Set("Content-Md5", base64.StdEncoding.EncodeToString(md5_sum))
 
-				http.ServeFile(res, req, manifests.FilePath(manifest, &file))
+					http.ServeContent(res, req, path.Base(file.Path), fin.ModTime(), fin)
 
-				return
+					return
+				}
 			}
 		}
 	}
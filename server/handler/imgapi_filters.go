@@ -0,0 +1,210 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/MerlinDMC/dsapid"
+	"github.com/MerlinDMC/dsapid/converter/decoder"
+	"github.com/MerlinDMC/dsapid/server/middleware"
+	"github.com/MerlinDMC/dsapid/storage"
+	"github.com/pborman/uuid"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// imgapiError is reported in the format of sdc-imgapi so imgadm can show it.
+type imgapiError struct {
+	status  int
+	code    string
+	message string
+	field   string
+	// problems replace the single error of field
+	problems decoder.Problems
+}
+
+func (me *imgapiError) Encode(encoder middleware.OutputEncoder) (int, []byte) {
+	body := dsapid.Table{
+		"code":    me.code,
+		"message": me.message,
+	}
+
+	if len(me.problems) > 0 {
+		body["errors"] = me.problems
+	} else if me.field != "" {
+		body["errors"] = []dsapid.Table{{
+			"field":   me.field,
+			"code":    "Invalid",
+			"message": me.message,
+		}}
+	}
+
+	return me.status, encoder.MustEncode(body)
+}
+
+func invalidParameter(field string, value string) *imgapiError {
+	return &imgapiError{
+		status:  http.StatusBadRequest,
+		code:    "InvalidParameter",
+		message: fmt.Sprintf("invalid \"%s\": \"%s\"", field, value),
+		field:   field,
+	}
+}
+
+// validationFailed reports the problems of a manifest like sdc-imgapi.
+func validationFailed(problems decoder.Problems) *imgapiError {
+	return &imgapiError{
+		status:   http.StatusUnprocessableEntity,
+		code:     "ValidationFailed",
+		message:  "invalid manifest",
+		problems: problems,
+	}
+}
+
+func notAuthorized(message string) *imgapiError {
+	return &imgapiError{
+		status:  http.StatusForbidden,
+		code:    "NotAuthorized",
+		message: message,
+	}
+}
+
+func isImageAdmin(user middleware.User) bool {
+	return !user.IsGuest() && (user.HasRoles(dsapid.UserRoleAdmin) || user.HasRoles(dsapid.UserRoleDatasetAdmin))
+}
+
+// imageVisible applies the visibility rules of the listings to a single
+// image.
+func imageVisible(user middleware.User, manifest *dsapid.ManifestResource) bool {
+	if manifest.Public || isImageAdmin(user) {
+		return true
+	}
+
+	return !user.IsGuest() && storage.FilterManifestForUser(user.GetId()).Match(manifest)
+}
+
+// hideAcl returns manifest without its acl unless user owns the image or
+// is an admin.
+func hideAcl(user middleware.User, manifest *dsapid.ManifestResource) *dsapid.ManifestResource {
+	if len(manifest.Acl) == 0 || isImageAdmin(user) || (!user.IsGuest() && manifest.Owner == user.GetId()) {
+		return manifest
+	}
+
+	stripped := *manifest
+	stripped.Acl = nil
+
+	return &stripped
+}
+
+// imgapiListFilters translates the ListImages query parameters of
+// sdc-imgapi. Admins see all images and may list any state including
+// "all"; everybody else sees public images, their own and those shared
+// with them. States other than active or deprecated require
+// authentication and list only the images owned by or shared with the user,
+// public or not.
+func imgapiListFilters(user middleware.User, query url.Values) ([]storage.ManifestFilter, *imgapiError) {
+	var filters []storage.ManifestFilter
+
+	admin := isImageAdmin(user)
+
+	switch account := query.Get("account"); {
+	case account != "" && uuid.Parse(account) == nil:
+		return nil, invalidParameter("account", account)
+	case account != "" && !admin && account != user.GetId():
+		return nil, notAuthorized("listing images for another account requires admin")
+	case account != "":
+		filters = append(filters, storage.FilterManifestForUser(account))
+	case user.IsGuest():
+		filters = append(filters, storage.FilterManifestPublic(true))
+	case !admin:
+		filters = append(filters, storage.FilterManifestForUser(user.GetId()))
+	}
+
+	switch state := dsapid.ManifestState(query.Get("state")); state {
+	case "":
+		filters = append(filters, storage.FilterManifestEnabled())
+	case "all":
+		if !admin {
+			return nil, notAuthorized("state=all requires admin")
+		}
+	case dsapid.ManifestStateActive, dsapid.ManifestStateDeprecated:
+		filters = append(filters, storage.FilterManifestState(state))
+	default:
+		if _, ok := dsapid.ManifestStateDescription[state]; !ok {
+			return nil, invalidParameter("state", string(state))
+		}
+
+		if !admin && user.IsGuest() {
+			return nil, notAuthorized(fmt.Sprintf("state=%s requires authentication", state))
+		}
+
+		if !admin {
+			filters = append(filters, storage.FilterManifestOwnerOrAcl(user.GetId()))
+		}
+
+		filters = append(filters, storage.FilterManifestState(state))
+	}
+
+	if v := query.Get("owner"); v != "" {
+		if uuid.Parse(v) == nil {
+			return nil, invalidParameter("owner", v)
+		}
+
+		filters = append(filters, storage.FilterManifestOwner(v))
+	}
+
+	if v := query.Get("name"); v != "" {
+		filters = append(filters, storage.FilterManifestExactName(v))
+	}
+
+	if v := query.Get("version"); v != "" {
+		filters = append(filters, storage.FilterManifestExactVersion(v))
+	}
+
+	if v := query.Get("os"); v != "" {
+		filters = append(filters, storage.FilterManifestExactOs(v))
+	}
+
+	if v := query.Get("type"); v != "" {
+		if _, ok := dsapid.ManifestTypeDescription[dsapid.ManifestType(v)]; !ok {
+			return nil, invalidParameter("type", v)
+		}
+
+		filters = append(filters, storage.FilterManifestType(dsapid.ManifestType(v)))
+	}
+
+	if v := query.Get("public"); v != "" {
+		public, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, invalidParameter("public", v)
+		}
+
+		filters = append(filters, storage.FilterManifestPublic(public))
+	}
+
+	if v := query.Get("billing_tag"); v != "" {
+		filters = append(filters, storage.FilterManifestBillingTag(v))
+	}
+
+	if ranges, err := rangeFilters(query); err != nil {
+		return nil, invalidParameter(err.field, err.value)
+	} else {
+		filters = append(filters, ranges...)
+	}
+
+	for k, values := range query {
+		if !strings.HasPrefix(k, "tag.") {
+			continue
+		}
+
+		if len(k) == len("tag.") {
+			return nil, invalidParameter(k, values[0])
+		}
+
+		for _, v := range values {
+			filters = append(filters, storage.FilterManifestTag(k[len("tag."):], v))
+		}
+	}
+
+	return filters, nil
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"github.com/MerlinDMC/dsapid"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestImgapiListFiltersStateHonorsAcl(t *testing.T) {
+	partner := &dsapid.UserResource{
+		Uuid: "0ba7f8b8-a0c5-4f1c-8b1e-5f3b8a0d1b0e",
+		Name: "partner",
+	}
+
+	shared := newTestManifest("shared", time.Now())
+	shared.State = dsapid.ManifestStateDisabled
+	shared.Public = false
+	shared.Owner = "352971aa-31ba-496c-9ade-a379feaecd52"
+	shared.Acl = []string{partner.Uuid}
+
+	hidden := newTestManifest("hidden", time.Now())
+	hidden.State = dsapid.ManifestStateDisabled
+	hidden.Public = false
+	hidden.Owner = shared.Owner
+
+	public := newTestManifest("public", time.Now())
+	public.State = dsapid.ManifestStateDisabled
+	public.Owner = shared.Owner
+
+	filters, ierr := imgapiListFilters(partner, url.Values{"state": {"disabled"}})
+	if ierr != nil {
+		t.Fatalf("should accept state=disabled: %s", ierr.message)
+	}
+
+	match := func(m *dsapid.ManifestResource) bool {
+		for _, f := range filters {
+			if !f.Match(m) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	if !match(shared) {
+		t.Error("should list the disabled image shared with the user")
+	}
+
+	if match(hidden) {
+		t.Error("should not list disabled images of others")
+	}
+
+	if match(public) {
+		t.Error("should not list public disabled images of others")
+	}
+
+	own := newTestManifest("own", time.Now())
+	own.State = dsapid.ManifestStateDisabled
+	own.Owner = partner.Uuid
+
+	if !match(own) {
+		t.Error("should list the disabled image owned by the user")
+	}
+
+	if _, ierr := imgapiListFilters(&dsapid.UserResource{Name: dsapid.DefaultUserGuestName}, url.Values{"state": {"disabled"}}); ierr == nil {
+		t.Error("should require authentication for state=disabled")
+	}
+}
@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/MerlinDMC/dsapid"
+	"github.com/MerlinDMC/dsapid/converter"
+	"github.com/MerlinDMC/dsapid/server/middleware"
+	"github.com/MerlinDMC/dsapid/storage"
+	log "github.com/Sirupsen/logrus"
+	"github.com/go-martini/martini"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+)
+
+var iconContentTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+}
+
+// ImgapiAddImageIcon implements AddImageIcon. The request body has to be
+// a PNG, JPEG or GIF image of at most MaxIconSize bytes matching the
+// Content-Type header.
+func ImgapiAddImageIcon(encoder middleware.OutputEncoder, params martini.Params, manifests storage.ManifestStorage, converter converter.ImgapiManifestEncoder, user middleware.User, req *http.Request) (int, []byte) {
+	manifest, ierr := writableImage(manifests, user, params["id"])
+	if ierr != nil {
+		return ierr.Encode(encoder)
+	}
+
+	content_type, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || !iconContentTypes[content_type] {
+		return invalidParameter("content-type", req.Header.Get("Content-Type")).Encode(encoder)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(req.Body, dsapid.MaxIconSize+1))
+	if err != nil {
+		return (&imgapiError{
+			status:  http.StatusBadRequest,
+			code:    "UploadError",
+			message: err.Error(),
+		}).Encode(encoder)
+	}
+
+	if int64(len(data)) > dsapid.MaxIconSize {
+		return (&imgapiError{
+			status:  http.StatusRequestEntityTooLarge,
+			code:    "UploadError",
+			message: fmt.Sprintf("icon is larger than %d bytes", dsapid.MaxIconSize),
+		}).Encode(encoder)
+	}
+
+	if http.DetectContentType(data) != content_type {
+		return invalidParameter("content-type", content_type).Encode(encoder)
+	}
+
+	logImage(user, manifest).Info("adding image icon")
+
+	if err := manifests.SaveIcon(manifest, data); err != nil {
+		log.WithFields(log.Fields{
+			"image_uuid": manifest.Uuid,
+		}).Errorf("can't save icon: %s", err)
+
+		return http.StatusInternalServerError, encoder.MustEncode(dsapid.Table{
+			"code":    "InternalError",
+			"message": "storing the icon failed",
+		})
+	}
+
+	manifest.Icon = true
+
+	return updateImage(encoder, manifests, converter, manifest, user)
+}
+
+func ImgapiDeleteImageIcon(encoder middleware.OutputEncoder, params martini.Params, manifests storage.ManifestStorage, converter converter.ImgapiManifestEncoder, user middleware.User) (int, []byte) {
+	manifest, ierr := writableImage(manifests, user, params["id"])
+	if ierr != nil {
+		return ierr.Encode(encoder)
+	}
+
+	logImage(user, manifest).Info("deleting image icon")
+
+	if err := manifests.RemoveIcon(manifest); err != nil {
+		log.WithFields(log.Fields{
+			"image_uuid": manifest.Uuid,
+		}).Errorf("can't remove icon: %s", err)
+
+		return http.StatusInternalServerError, encoder.MustEncode(dsapid.Table{
+			"code":    "InternalError",
+			"message": "removing the icon failed",
+		})
+	}
+
+	manifest.Icon = false
+
+	return updateImage(encoder, manifests, converter, manifest, user)
+}
+
+func ImgapiGetImageIcon(encoder middleware.OutputEncoder, params martini.Params, manifests storage.ManifestStorage, user middleware.User, res http.ResponseWriter, req *http.Request) {
+	if manifest, ok := manifests.GetOK(params["id"]); ok && manifest.Icon && imageVisible(user, manifest) {
+		if icon, err := manifests.OpenIcon(manifest); err == nil {
+			defer icon.Close()
+
+			head := make([]byte, 512)
+			n, _ := io.ReadFull(icon, head)
+
+			if _, err := icon.Seek(0, io.SeekStart); err == nil {
+				res.Header().Set("Content-Type", http.DetectContentType(head[:n]))
+
+				http.ServeContent(res, req, "", icon.ModTime(), icon)
+
+				return
+			}
+		}
+	}
+
+	status, body := imageNotFound(params["id"]).Encode(encoder)
+
+	res.WriteHeader(status)
+	res.Write(body)
+}
@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"bytes"
+	"github.com/MerlinDMC/dsapid"
+	"github.com/go-martini/martini"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestImgapiImageIcon(t *testing.T) {
+	manifests, cleanup := newTestManifestStorage(t)
+	defer cleanup()
+
+	owner := &dsapid.UserResource{
+		Uuid:  "f9e4be48-9466-11e1-bc41-9f993f5dff36",
+		Name:  "owner",
+		Roles: []dsapid.UserRoleName{dsapid.UserRoleDatasetUpload},
+	}
+
+	other := &dsapid.UserResource{
+		Uuid:  "0ba7f8b8-a0c5-4f1c-8b1e-5f3b8a0d1b0e",
+		Name:  "other",
+		Roles: []dsapid.UserRoleName{dsapid.UserRoleDatasetUpload},
+	}
+
+	guest := &dsapid.UserResource{Name: dsapid.DefaultUserGuestName}
+
+	manifest := newTestManifest("base", time.Now())
+	manifest.Owner = owner.Uuid
+
+	if err := manifests.Add(manifest.Uuid, manifest); err != nil {
+		t.Fatalf("can't add manifest: %s", err)
+	}
+
+	params := martini.Params{"id": manifest.Uuid}
+	png := []byte("\x89PNG\r\n\x1a\nicon")
+
+	add := func(user *dsapid.UserResource, content_type string, data []byte) int {
+		req, _ := http.NewRequest("PUT", "/images/"+manifest.Uuid+"/icon", bytes.NewReader(data))
+		req.Header.Set("Content-Type", content_type)
+
+		status, _ := ImgapiAddImageIcon(testEncoder{}, params, manifests, testManifestEncoder{}, user, req)
+
+		return status
+	}
+
+	get := func() *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+
+		ImgapiGetImageIcon(testEncoder{}, params, manifests, guest, res, httptest.NewRequest("GET", "/images/"+manifest.Uuid+"/icon", nil))
+
+		return res
+	}
+
+	tests := []struct {
+		name         string
+		user         *dsapid.UserResource
+		content_type string
+		data         []byte
+		expected     int
+	}{
+		{"unsupported content type", owner, "text/plain", png, http.StatusBadRequest},
+		{"content type not matching the data", owner, "image/gif", png, http.StatusBadRequest},
+		{"too large", owner, "image/png", append(png, make([]byte, dsapid.MaxIconSize)...), http.StatusRequestEntityTooLarge},
+		{"not the owner", other, "image/png", png, http.StatusForbidden},
+		{"guest", guest, "image/png", png, http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		if status := add(test.user, test.content_type, test.data); status != test.expected {
+			t.Errorf("%s: should be %d but got %d", test.name, test.expected, status)
+		}
+
+		if manifests.Get(manifest.Uuid).Icon {
+			t.Fatalf("%s: should not set the icon", test.name)
+		}
+	}
+
+	if res := get(); res.Code != http.StatusNotFound {
+		t.Errorf("should not serve a missing icon but got %d", res.Code)
+	}
+
+	if status := add(owner, "image/png; charset=binary", png); status != http.StatusOK {
+		t.Fatalf("adding the icon failed with %d", status)
+	}
+
+	if !manifests.Get(manifest.Uuid).Icon {
+		t.Error("should set the icon")
+	}
+
+	res := get()
+	if res.Code != http.StatusOK || res.Header().Get("Content-Type") != "image/png" || !bytes.Equal(res.Body.Bytes(), png) {
+		t.Errorf("should serve the icon but got %d %q", res.Code, res.Header().Get("Content-Type"))
+	}
+
+	if status, _ := ImgapiDeleteImageIcon(testEncoder{}, params, manifests, testManifestEncoder{}, other); status != http.StatusForbidden {
+		t.Errorf("deleting the icon of another account should be %d but got %d", http.StatusForbidden, status)
+	}
+
+	if status, _ := ImgapiDeleteImageIcon(testEncoder{}, params, manifests, testManifestEncoder{}, owner); status != http.StatusOK {
+		t.Fatalf("deleting the icon failed with %d", status)
+	}
+
+	if manifests.Get(manifest.Uuid).Icon {
+		t.Error("should clear the icon")
+	}
+
+	if res := get(); res.Code != http.StatusNotFound {
+		t.Errorf("should not serve a deleted icon but got %d", res.Code)
+	}
+}
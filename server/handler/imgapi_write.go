@@ -0,0 +1,562 @@
+package handler
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/MerlinDMC/dsapid"
+	"github.com/MerlinDMC/dsapid/converter"
+	"github.com/MerlinDMC/dsapid/converter/decoder"
+	imgapi_converter "github.com/MerlinDMC/dsapid/converter/imgapi"
+	"github.com/MerlinDMC/dsapid/server/middleware"
+	"github.com/MerlinDMC/dsapid/storage"
+	log "github.com/Sirupsen/logrus"
+	"github.com/go-martini/martini"
+	"github.com/pborman/uuid"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// imgapiUpdatable lists the fields UpdateImage accepts.
+var imgapiUpdatable = map[string]bool{
+	"name":         true,
+	"version":      true,
+	"description":  true,
+	"homepage":     true,
+	"os":           true,
+	"type":         true,
+	"public":       true,
+	"tags":         true,
+	"billing_tags": true,
+	"requirements": true,
+	"nic_driver":   true,
+	"disk_driver":  true,
+	"cpu_type":     true,
+	"image_size":   true,
+}
+
+func imageNotFound(id string) *imgapiError {
+	return &imgapiError{
+		status:  http.StatusNotFound,
+		code:    "ResourceNotFound",
+		message: fmt.Sprintf("image \"%s\" not found", id),
+	}
+}
+
+func imageConflict(code string, message string) *imgapiError {
+	return &imgapiError{
+		status:  http.StatusConflict,
+		code:    code,
+		message: message,
+	}
+}
+
+// requireImageWriter allows uploaders and image admins to publish.
+func requireImageWriter(user middleware.User) *imgapiError {
+	if user.IsGuest() {
+		return &imgapiError{
+			status:  http.StatusUnauthorized,
+			code:    "Unauthorized",
+			message: "authentication required",
+		}
+	}
+
+	if !isImageAdmin(user) && !user.HasRoles(dsapid.UserRoleDatasetUpload) {
+		return notAuthorized("publishing images requires the upload role")
+	}
+
+	return nil
+}
+
+// writableImage returns a copy of the image :id if user may change it. The
+// copy can be modified freely and passed to Update.
+func writableImage(manifests storage.ManifestStorage, user middleware.User, id string) (*dsapid.ManifestResource, *imgapiError) {
+	if ierr := requireImageWriter(user); ierr != nil {
+		return nil, ierr
+	}
+
+	manifest, ok := manifests.GetOK(id)
+	if !ok || manifest.State == dsapid.ManifestStateNuked {
+		return nil, imageNotFound(id)
+	}
+
+	if !isImageAdmin(user) && manifest.Owner != user.GetId() {
+		return nil, notAuthorized(fmt.Sprintf("image \"%s\" is owned by another account", id))
+	}
+
+	return storage.CopyManifest(manifest), nil
+}
+
+func decodeBody(req *http.Request) (data dsapid.Table, ierr *imgapiError) {
+	if err := json.NewDecoder(req.Body).Decode(&data); err != nil || data == nil {
+		return nil, &imgapiError{
+			status:  http.StatusBadRequest,
+			code:    "InvalidParameter",
+			message: "request body is not a JSON object",
+		}
+	}
+
+	return data, nil
+}
+
+func logImage(user middleware.User, manifest *dsapid.ManifestResource) *log.Entry {
+	return log.WithFields(log.Fields{
+		"user_uuid":     user.GetId(),
+		"user_name":     user.GetName(),
+		"image_uuid":    manifest.Uuid,
+		"image_name":    manifest.Name,
+		"image_version": manifest.Version,
+	})
+}
+
+// ImgapiCreateImage implements CreateImage: the manifest is stored
+// unactivated until a file is added and ActivateImage is called. An icon
+// in the body is ignored as is the channels list unless sent by an admin;
+// those channels have to be configured.
+func ImgapiCreateImage(encoder middleware.OutputEncoder, manifests storage.ManifestStorage, users storage.UserStorage, converter converter.ImgapiManifestEncoder, channels dsapid.Channels, user middleware.User, req *http.Request) (int, []byte) {
+	if ierr := requireImageWriter(user); ierr != nil {
+		return ierr.Encode(encoder)
+	}
+
+	if action := req.URL.Query().Get("action"); action != "" && action != "create" {
+		return invalidParameter("action", action).Encode(encoder)
+	}
+
+	data, ierr := decodeBody(req)
+	if ierr != nil {
+		return ierr.Encode(encoder)
+	}
+
+	if _, ok := data["owner"]; !ok || !isImageAdmin(user) {
+		data["owner"] = user.GetId()
+	}
+
+	// icons are only set by uploading one and channels are managed by admins
+	delete(data, "icon")
+
+	if !isImageAdmin(user) {
+		delete(data, "channels")
+	}
+
+	// always decode as an IMGAPI manifest
+	data["v"] = float64(imgapi_converter.CurrentManifestVersion)
+
+	// the uuid may be assigned here and files are added afterwards
+	if problems := decoder.ValidateManifest(data, decoder.ValidationOptions{
+		AllowNoUuid:  true,
+		AllowNoFiles: true,
+	}); len(problems) > 0 {
+		return validationFailed(problems).Encode(encoder)
+	}
+
+	_, public := data["public"]
+
+	manifest := decoder.DecodeToManifest(data, dsapid.SyncProviderCommunity, users)
+
+	for _, name := range manifest.Channels {
+		if _, ok := channels.Get(name); !ok {
+			return invalidParameter("channels", name).Encode(encoder)
+		}
+	}
+
+	if manifest.Uuid == "" {
+		manifest.Uuid = uuid.New()
+	} else if _, ok := manifests.GetOK(manifest.Uuid); ok {
+		return imageConflict("ImageUuidAlreadyExists", fmt.Sprintf("image \"%s\" already exists", manifest.Uuid)).Encode(encoder)
+	}
+
+	// IMGAPI images are private unless asked otherwise
+	manifest.Public = public && manifest.Public
+	manifest.State = dsapid.ManifestStateInactive
+	manifest.Disabled = false
+	manifest.PublishedAt = time.Time{}
+	manifest.Files = make([]dsapid.ManifestFileResource, 0)
+
+	if manifest.CreatedAt.IsZero() {
+		manifest.CreatedAt = time.Now()
+	}
+
+	logImage(user, manifest).Info("creating image")
+
+	if err := manifests.Add(manifest.Uuid, manifest); err != nil {
+		log.WithFields(log.Fields{
+			"image_uuid": manifest.Uuid,
+		}).Errorf("can't save manifest: %s", err)
+
+		return http.StatusInternalServerError, encoder.MustEncode(dsapid.Table{
+			"code":    "InternalError",
+			"message": "creating the image failed",
+		})
+	}
+
+	return http.StatusOK, encoder.MustEncode(converter.Encode(manifest))
+}
+
+// ImgapiAddImageFile implements AddImageFile and stores the request body
+// as the file of an unactivated image.
+func ImgapiAddImageFile(encoder middleware.OutputEncoder, params martini.Params, manifests storage.ManifestStorage, converter converter.ImgapiManifestEncoder, user middleware.User, req *http.Request) (int, []byte) {
+	manifest, ierr := writableImage(manifests, user, params["id"])
+	if ierr != nil {
+		return ierr.Encode(encoder)
+	}
+
+	if manifest.State != dsapid.ManifestStateInactive {
+		return imageConflict("ImageFilesImmutable", "files of an activated image can't be changed").Encode(encoder)
+	}
+
+	query := req.URL.Query()
+
+	compression := dsapid.CompressionType(query.Get("compression"))
+	if compression == "" {
+		compression = dsapid.CompressionTypeNone
+	}
+
+	file := dsapid.ManifestFileResource{
+		Path:        imageFilePath(manifest, 0, compression),
+		Compression: compression,
+	}
+
+	if file.Path == "" {
+		return invalidParameter("compression", string(compression)).Encode(encoder)
+	}
+
+	var expected_size int64 = -1
+
+	if v := query.Get("size"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return invalidParameter("size", v).Encode(encoder)
+		}
+
+		expected_size = n
+	}
+
+	file_out, err := manifests.CreateFile(manifest, &file)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"image_uuid": manifest.Uuid,
+		}).Errorf("can't create file: %s", err)
+
+		return http.StatusInternalServerError, encoder.MustEncode(dsapid.Table{
+			"code":    "InternalError",
+			"message": "storing the file failed",
+		})
+	}
+	defer file_out.Close()
+
+	logImage(user, manifest).Info("uploading image file")
+
+	hash_md5 := md5.New()
+	hash_sha1 := sha1.New()
+
+	size, err := io.Copy(io.MultiWriter(hash_md5, hash_sha1, file_out), req.Body)
+	if err == nil {
+		err = file_out.Close()
+	}
+
+	if err != nil {
+		return (&imgapiError{
+			status:  http.StatusBadRequest,
+			code:    "UploadError",
+			message: err.Error(),
+		}).Encode(encoder)
+	}
+
+	file.Size = size
+	file.Md5 = hex.EncodeToString(hash_md5.Sum(nil))
+	file.Sha1 = hex.EncodeToString(hash_sha1.Sum(nil))
+
+	if expected_size >= 0 && expected_size != file.Size {
+		return invalidParameter("size", query.Get("size")).Encode(encoder)
+	}
+
+	if v := query.Get("sha1"); v != "" && v != file.Sha1 {
+		return invalidParameter("sha1", v).Encode(encoder)
+	}
+
+	manifest.Files = []dsapid.ManifestFileResource{file}
+
+	return updateImage(encoder, manifests, converter, manifest, user)
+}
+
+// ImgapiImageAction dispatches the ?action= requests on a single image.
+func ImgapiImageAction(encoder middleware.OutputEncoder, params martini.Params, manifests storage.ManifestStorage, converter converter.ImgapiManifestEncoder, user middleware.User, req *http.Request) (int, []byte) {
+	manifest, ierr := writableImage(manifests, user, params["id"])
+	if ierr != nil {
+		return ierr.Encode(encoder)
+	}
+
+	switch action := req.URL.Query().Get("action"); action {
+	case "activate":
+		if manifest.State != dsapid.ManifestStateInactive {
+			return imageConflict("ImageAlreadyActivated", fmt.Sprintf("image \"%s\" is already activated", manifest.Uuid)).Encode(encoder)
+		}
+
+		if len(manifest.Files) == 0 {
+			return imageConflict("NoActivationNoFile", fmt.Sprintf("image \"%s\" has no file", manifest.Uuid)).Encode(encoder)
+		}
+
+		logImage(user, manifest).Info("activating image")
+
+		manifest.PublishedAt = time.Now()
+
+		if manifest.Disabled {
+			manifest.State = dsapid.ManifestStateDisabled
+		} else {
+			manifest.State = dsapid.ManifestStateActive
+		}
+	case "enable":
+		logImage(user, manifest).Info("enabling image")
+
+		manifest.Disabled = false
+
+		if manifest.State == dsapid.ManifestStateDisabled {
+			manifest.State = dsapid.ManifestStateActive
+		}
+	case "disable":
+		logImage(user, manifest).Info("disabling image")
+
+		manifest.Disabled = true
+
+		if manifest.State != dsapid.ManifestStateInactive {
+			manifest.State = dsapid.ManifestStateDisabled
+		}
+	case "update":
+		data, ierr := decodeBody(req)
+		if ierr != nil {
+			return ierr.Encode(encoder)
+		}
+
+		updated, ierr := applyImageUpdate(*manifest, data)
+		if ierr != nil {
+			return ierr.Encode(encoder)
+		}
+
+		logImage(user, manifest).Info("updating image")
+
+		*manifest = updated
+	default:
+		return invalidParameter("action", action).Encode(encoder)
+	}
+
+	return updateImage(encoder, manifests, converter, manifest, user)
+}
+
+// ImgapiDeleteImage implements DeleteImage.
+func ImgapiDeleteImage(encoder middleware.OutputEncoder, params martini.Params, manifests storage.ManifestStorage, user middleware.User) (int, []byte) {
+	manifest, ierr := writableImage(manifests, user, params["id"])
+	if ierr != nil {
+		return ierr.Encode(encoder)
+	}
+
+	logImage(user, manifest).Info("deleting image")
+
+	manifests.Delete(manifest.Uuid)
+
+	return http.StatusNoContent, nil
+}
+
+// applyImageUpdate sets the fields of data on manifest and returns it.
+func applyImageUpdate(manifest dsapid.ManifestResource, data dsapid.Table) (dsapid.ManifestResource, *imgapiError) {
+	invalid := func(field string) (dsapid.ManifestResource, *imgapiError) {
+		return manifest, invalidParameter(field, fmt.Sprint(data[field]))
+	}
+
+	options := make(dsapid.Table)
+	for k, v := range manifest.Options {
+		options[k] = v
+	}
+
+	for field, value := range data {
+		if !imgapiUpdatable[field] {
+			return manifest, &imgapiError{
+				status:  http.StatusBadRequest,
+				code:    "InvalidParameter",
+				message: fmt.Sprintf("\"%s\" can't be updated", field),
+				field:   field,
+			}
+		}
+
+		switch field {
+		case "public":
+			v, ok := value.(bool)
+			if !ok {
+				return invalid(field)
+			}
+
+			manifest.Public = v
+		case "tags", "requirements":
+			v, ok := value.(map[string]interface{})
+			if !ok {
+				return invalid(field)
+			}
+
+			if field == "tags" {
+				manifest.Tags = dsapid.Table(v)
+			} else {
+				manifest.Requirements = dsapid.Table(v)
+			}
+		case "billing_tags":
+			v, ok := value.([]interface{})
+			if !ok {
+				return invalid(field)
+			}
+
+			tags := make([]string, 0, len(v))
+
+			for _, t := range v {
+				s, ok := t.(string)
+				if !ok {
+					return invalid(field)
+				}
+
+				tags = append(tags, s)
+			}
+
+			manifest.BillingTags = tags
+		case "image_size":
+			v, ok := value.(float64)
+			if !ok || v < 0 {
+				return invalid(field)
+			}
+
+			options[field] = int64(v)
+		default:
+			v, ok := value.(string)
+			if !ok {
+				return invalid(field)
+			}
+
+			switch field {
+			case "name":
+				manifest.Name = v
+			case "version":
+				manifest.Version = v
+			case "description":
+				manifest.Description = v
+			case "homepage":
+				manifest.Homepage = v
+			case "os":
+				manifest.Os = v
+			case "type":
+				if _, ok := dsapid.ManifestTypeDescription[dsapid.ManifestType(v)]; !ok {
+					return invalid(field)
+				}
+
+				manifest.Type = dsapid.ManifestType(v)
+			default:
+				options[field] = v
+			}
+		}
+	}
+
+	if manifest.Name == "" {
+		return invalid("name")
+	}
+
+	if manifest.Version == "" {
+		return invalid("version")
+	}
+
+	manifest.Options = options
+
+	_, name := data["name"]
+	_, version := data["version"]
+
+	if name || version {
+		manifest.Urn = imageUrn(&manifest)
+	}
+
+	return manifest, nil
+}
+
+func updateImage(encoder middleware.OutputEncoder, manifests storage.ManifestStorage, converter converter.ImgapiManifestEncoder, manifest *dsapid.ManifestResource, user middleware.User) (int, []byte) {
+	if err := manifests.Update(manifest.Uuid, manifest, revisionAuthor(user)); err != nil {
+		log.WithFields(log.Fields{
+			"image_uuid": manifest.Uuid,
+		}).Errorf("can't save manifest: %s", err)
+
+		return http.StatusInternalServerError, encoder.MustEncode(dsapid.Table{
+			"code":    "InternalError",
+			"message": "updating the image failed",
+		})
+	}
+
+	return http.StatusOK, encoder.MustEncode(converter.Encode(manifest))
+}
+
+// handlers name their encoder converter which hides the package
+func imageFilePath(manifest *dsapid.ManifestResource, file_idx int, compression dsapid.CompressionType) string {
+	return converter.ComputeFilePath(manifest, file_idx, compression)
+}
+
+func imageUrn(manifest *dsapid.ManifestResource) string {
+	return converter.ComputeUrn(manifest)
+}
+
+// ImgapiImageAcl implements AddImageAcl and RemoveImageAcl which take a
+// list of user uuids as body.
+func ImgapiImageAcl(encoder middleware.OutputEncoder, params martini.Params, manifests storage.ManifestStorage, converter converter.ImgapiManifestEncoder, user middleware.User, req *http.Request) (int, []byte) {
+	manifest, ierr := writableImage(manifests, user, params["id"])
+	if ierr != nil {
+		return ierr.Encode(encoder)
+	}
+
+	var ids []string
+
+	if err := json.NewDecoder(req.Body).Decode(&ids); err != nil {
+		return (&imgapiError{
+			status:  http.StatusBadRequest,
+			code:    "InvalidParameter",
+			message: "request body is not a list of uuids",
+			field:   "acl",
+		}).Encode(encoder)
+	}
+
+	for _, id := range ids {
+		if uuid.Parse(id) == nil {
+			return invalidParameter("acl", id).Encode(encoder)
+		}
+	}
+
+	acl := make([]string, 0, len(manifest.Acl)+len(ids))
+
+	switch action := req.URL.Query().Get("action"); action {
+	case "add":
+		acl = append(acl, manifest.Acl...)
+
+	nextId:
+		for _, id := range ids {
+			for _, v := range acl {
+				if v == id {
+					continue nextId
+				}
+			}
+
+			acl = append(acl, id)
+		}
+	case "remove":
+	nextAcl:
+		for _, v := range manifest.Acl {
+			for _, id := range ids {
+				if v == id {
+					continue nextAcl
+				}
+			}
+
+			acl = append(acl, v)
+		}
+	default:
+		return invalidParameter("action", action).Encode(encoder)
+	}
+
+	logImage(user, manifest).WithField("acl", acl).Info("changing image acl")
+
+	manifest.Acl = acl
+
+	return updateImage(encoder, manifests, converter, manifest, user)
+}
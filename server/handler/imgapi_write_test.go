@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/MerlinDMC/dsapid"
+	"github.com/MerlinDMC/dsapid/storage"
+	"github.com/go-martini/martini"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type testManifestEncoder struct{}
+
+func (me testManifestEncoder) Encode(manifest *dsapid.ManifestResource) interface{} {
+	return manifest
+}
+
+func (me testManifestEncoder) EncodeWithExtra(manifest *dsapid.ManifestResource) interface{} {
+	return manifest
+}
+
+func TestImgapiWritesLeaveStoredManifestsAlone(t *testing.T) {
+	manifests, cleanup := newTestManifestStorage(t)
+	defer cleanup()
+
+	admin := &dsapid.UserResource{
+		Uuid:  "352971aa-31ba-496c-9ade-a379feaecd52",
+		Name:  "admin",
+		Roles: []dsapid.UserRoleName{dsapid.UserRoleAdmin},
+	}
+
+	data := []byte("shared image data")
+	sum := sha1.Sum(data)
+
+	shared := newTestManifest("shared", time.Now())
+	shared.Files = []dsapid.ManifestFileResource{{
+		Path: "shared.zfs",
+		Size: int64(len(data)),
+		Sha1: hex.EncodeToString(sum[:]),
+	}}
+
+	w, _ := manifests.CreateFile(shared, &shared.Files[0])
+	w.Write(data)
+	w.Close()
+
+	if err := manifests.Add(shared.Uuid, shared); err != nil {
+		t.Fatalf("can't add manifest: %s", err)
+	}
+
+	image := newTestManifest("image", time.Now())
+	image.State = dsapid.ManifestStateInactive
+	image.Files = make([]dsapid.ManifestFileResource, 0)
+
+	if err := manifests.Add(image.Uuid, image); err != nil {
+		t.Fatalf("can't add manifest: %s", err)
+	}
+
+	params := martini.Params{"id": image.Uuid}
+	stored := manifests.Get(image.Uuid)
+
+	req, _ := http.NewRequest("PUT", "/images/"+image.Uuid+"/file", bytes.NewReader(data))
+
+	if status, body := ImgapiAddImageFile(testEncoder{}, params, manifests, testManifestEncoder{}, admin, req); status != http.StatusOK {
+		t.Fatalf("adding the file failed with %d: %s", status, body)
+	}
+
+	if len(stored.Files) != 0 {
+		t.Error("AddImageFile should not modify the stored manifest in place")
+	}
+
+	stored = manifests.Get(image.Uuid)
+
+	req, _ = http.NewRequest("POST", "/images/"+image.Uuid+"?action=update", bytes.NewBufferString(`{"description": "changed"}`))
+
+	if status, body := ImgapiImageAction(testEncoder{}, params, manifests, testManifestEncoder{}, admin, req); status != http.StatusOK {
+		t.Fatalf("updating the image failed with %d: %s", status, body)
+	}
+
+	if stored.Description != "" {
+		t.Error("ImageAction should not modify the stored manifest in place")
+	}
+
+	// the file is shared with another image and must survive the delete
+	manifests.Delete(image.Uuid)
+
+	r, err := manifests.OpenFile(shared, &shared.Files[0])
+	if err != nil {
+		t.Fatalf("file of the other image is gone: %s", err)
+	}
+
+	r.Close()
+}
+
+func TestImgapiCreateImageFiltersChannelsAndIcon(t *testing.T) {
+	manifests, cleanup := newTestManifestStorage(t)
+	defer cleanup()
+
+	users, _ := storage.NewUserStorage("")
+
+	channels := dsapid.Channels{{Name: "release", Default: true}, {Name: "staging"}}
+
+	uploader := &dsapid.UserResource{
+		Uuid:  "f9e4be48-9466-11e1-bc41-9f993f5dff36",
+		Name:  "uploader",
+		Roles: []dsapid.UserRoleName{dsapid.UserRoleDatasetUpload},
+	}
+
+	admin := &dsapid.UserResource{
+		Uuid:  "352971aa-31ba-496c-9ade-a379feaecd52",
+		Name:  "admin",
+		Roles: []dsapid.UserRoleName{dsapid.UserRoleAdmin},
+	}
+
+	create := func(user *dsapid.UserResource, body string) (int, *dsapid.ManifestResource) {
+		req, _ := http.NewRequest("POST", "/images", bytes.NewBufferString(body))
+
+		status, data := ImgapiCreateImage(testEncoder{}, manifests, users, testManifestEncoder{}, channels, user, req)
+
+		manifest := new(dsapid.ManifestResource)
+		json.Unmarshal(data, manifest)
+
+		return status, manifest
+	}
+
+	body := `{"name": "base", "version": "1.0.0", "os": "smartos", "type": "zvol", "icon": true, "channels": ["staging"]}`
+
+	if status, manifest := create(uploader, body); status != http.StatusOK || manifest.Icon || len(manifest.Channels) != 0 {
+		t.Errorf("uploader should get an image without icon and channels but got %d: %v %v", status, manifest.Icon, manifest.Channels)
+	}
+
+	if status, manifest := create(admin, body); status != http.StatusOK || manifest.Icon || len(manifest.Channels) != 1 || manifest.Channels[0] != "staging" {
+		t.Errorf("admin should get an image in staging without icon but got %d: %v %v", status, manifest.Icon, manifest.Channels)
+	}
+
+	if status, _ := create(admin, `{"name": "base", "version": "1.0.0", "os": "smartos", "type": "zvol", "channels": ["unknown"]}`); status != http.StatusBadRequest {
+		t.Errorf("unknown channels should be rejected but got %d", status)
+	}
+}
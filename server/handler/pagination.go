@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/MerlinDMC/dsapid"
+	"github.com/MerlinDMC/dsapid/storage"
+	"github.com/pborman/uuid"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxPageLimit int = 1000
+)
+
+// invalidParamError reports a query parameter with an unusable value.
+type invalidParamError struct {
+	field string
+	value string
+}
+
+func (me *invalidParamError) Error() string {
+	return fmt.Sprintf("invalid %s", me.field)
+}
+
+// paginate returns the manifests matching filters which follow the image
+// given by ?marker= up to ?limit= of them. Without limit everything after
+// the marker is returned. If more manifests follow the page a Link header
+// to the next page is added to res; its marker carries the position of the
+// last image so the next page still follows if that image gets deleted.
+// With ?latest=true only the newest version of each name is listed.
+func paginate(manifests storage.ManifestStorage, filters []storage.ManifestFilter, res http.ResponseWriter, req *http.Request) ([]*dsapid.ManifestResource, error) {
+	limit := 0
+
+	if v := req.URL.Query().Get("latest"); v != "" {
+		latest, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, &invalidParamError{"latest", v}
+		}
+
+		if latest {
+			filters = append(filters, storage.FilterManifestLatest(storage.LatestByName(manifests.Filter(filters...))))
+		}
+	}
+
+	if v := req.URL.Query().Get("marker"); v != "" {
+		published, id, ok := parseMarker(manifests, v)
+		if !ok {
+			return nil, &invalidParamError{"marker", v}
+		}
+
+		filters = append(filters, storage.FilterManifestAfterPosition(published, id))
+	}
+
+	if v := req.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, &invalidParamError{"limit", v}
+		}
+
+		if limit = n; limit > maxPageLimit {
+			limit = maxPageLimit
+		}
+	}
+
+	items := make([]*dsapid.ManifestResource, 0)
+	more := false
+
+	// drain the channel so the producer can finish
+	for manifest := range manifests.Filter(filters...) {
+		if limit > 0 && len(items) == limit {
+			more = true
+
+			continue
+		}
+
+		items = append(items, manifest)
+	}
+
+	if more {
+		u := *req.URL
+		query := u.Query()
+		query.Set("marker", formatMarker(items[len(items)-1]))
+		query.Set("limit", strconv.Itoa(limit))
+		u.RawQuery = query.Encode()
+
+		res.Header().Set("Link", "<"+u.RequestURI()+">; rel=\"next\"")
+	}
+
+	return items, nil
+}
+
+// parseMarker accepts the uuid of a stored image or a position written by
+// formatMarker as "<published_at unix time>-<uuid>".
+func parseMarker(manifests storage.ManifestStorage, value string) (time.Time, string, bool) {
+	if uuid.Parse(value) != nil {
+		if marker, ok := manifests.GetOK(value); ok {
+			return marker.PublishedAt, marker.Uuid, true
+		}
+
+		return time.Time{}, "", false
+	}
+
+	parts := strings.SplitN(value, "-", 2)
+	if len(parts) != 2 || uuid.Parse(parts[1]) == nil {
+		return time.Time{}, "", false
+	}
+
+	published, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, "", false
+	}
+
+	return time.Unix(published, 0), parts[1], true
+}
+
+func formatMarker(manifest *dsapid.ManifestResource) string {
+	return fmt.Sprintf("%d-%s", manifest.PublishedAt.Unix(), manifest.Uuid)
+}
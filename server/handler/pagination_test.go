@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPaginateSurvivesDeletedMarker(t *testing.T) {
+	manifests, cleanup := newTestManifestStorage(t)
+	defer cleanup()
+
+	var ids []string
+
+	for i := 0; i < 5; i++ {
+		m := newTestManifest("base", time.Unix(int64(1000-i), 0))
+		manifests.Add(m.Uuid, m)
+
+		ids = append(ids, m.Uuid)
+	}
+
+	res := httptest.NewRecorder()
+
+	page, err := paginate(manifests, nil, res, httptest.NewRequest("GET", "/images?limit=2", nil))
+	if err != nil || len(page) != 2 {
+		t.Fatalf("should return the first page but got %d: %v", len(page), err)
+	}
+
+	link := res.Header().Get("Link")
+	if !strings.HasPrefix(link, "<") || !strings.Contains(link, ">") {
+		t.Fatalf("should link the next page but got %q", link)
+	}
+
+	next, _ := url.Parse(link[1:strings.Index(link, ">")])
+
+	// the last image of the page goes away before the next one is fetched
+	manifests.Delete(page[1].Uuid)
+
+	page, err = paginate(manifests, nil, httptest.NewRecorder(), httptest.NewRequest("GET", next.String(), nil))
+	if err != nil {
+		t.Fatalf("should accept the marker of a deleted image: %s", err)
+	}
+
+	if len(page) != 2 || page[0].Uuid != ids[2] || page[1].Uuid != ids[3] {
+		t.Errorf("should continue after the deleted marker but got %d images", len(page))
+	}
+
+	if _, err := paginate(manifests, nil, httptest.NewRecorder(), httptest.NewRequest("GET", "/images?marker="+ids[1], nil)); err == nil {
+		t.Error("should reject the uuid of an unknown image as marker")
+	}
+}
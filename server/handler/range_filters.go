@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"github.com/MerlinDMC/dsapid/converter"
+	"github.com/MerlinDMC/dsapid/storage"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// rangeFilters translates the date range and size parameters shared by
+// the listings. Dates take the formats accepted by converter.ParseDateTime,
+// sizes are given in bytes and apply to the sum of all files of an image.
+func rangeFilters(query url.Values) ([]storage.ManifestFilter, *invalidParamError) {
+	var filters []storage.ManifestFilter
+
+	dates := []struct {
+		field  string
+		filter func(time.Time) storage.ManifestFilter
+	}{
+		{"published_after", storage.FilterManifestPublishedAfter},
+		{"published_before", storage.FilterManifestPublishedBefore},
+		{"created_after", storage.FilterManifestCreatedAfter},
+		{"created_before", storage.FilterManifestCreatedBefore},
+	}
+
+	for _, date := range dates {
+		if v := query.Get(date.field); v != "" {
+			t, err := converter.ParseDateTime(v)
+			if err != nil {
+				return nil, &invalidParamError{date.field, v}
+			}
+
+			filters = append(filters, date.filter(t))
+		}
+	}
+
+	sizes := []struct {
+		field  string
+		filter func(int64) storage.ManifestFilter
+	}{
+		{"min_size", storage.FilterManifestMinSize},
+		{"max_size", storage.FilterManifestMaxSize},
+	}
+
+	for _, size := range sizes {
+		if v := query.Get(size.field); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				return nil, &invalidParamError{size.field, v}
+			}
+
+			filters = append(filters, size.filter(n))
+		}
+	}
+
+	return filters, nil
+}
@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"encoding/json"
+	"github.com/MerlinDMC/dsapid"
+	"github.com/go-martini/martini"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestRangeFilters(t *testing.T) {
+	published := time.Date(2016, 3, 2, 12, 0, 0, 0, time.UTC)
+
+	manifest := newTestManifest("base", published)
+	manifest.CreatedAt = published
+	manifest.Files = []dsapid.ManifestFileResource{{Size: 100}, {Size: 50}}
+
+	undated := newTestManifest("undated", published)
+
+	tests := []struct {
+		query    string
+		manifest *dsapid.ManifestResource
+		expected bool
+		field    string
+	}{
+		{"published_after=2016-03-02T12:00:00Z", manifest, true, ""},
+		{"published_after=2016-03-02T12:00:01Z", manifest, false, ""},
+		{"published_before=2016-03-02T12:00:00Z", manifest, false, ""},
+		{"published_before=2016-03-02T12:01Z", manifest, true, ""},
+		{"created_after=2016-03-02T12:00:00.000Z", manifest, true, ""},
+		{"created_before=2016-03-02T12:00:00", manifest, false, ""},
+		{"created_after=2000-01-01T00:00:00Z", undated, false, ""},
+		{"created_before=2100-01-01T00:00:00Z", undated, false, ""},
+		{"min_size=150", manifest, true, ""},
+		{"min_size=151", manifest, false, ""},
+		{"max_size=150", manifest, true, ""},
+		{"max_size=149", manifest, false, ""},
+		{"min_size=100&max_size=200&published_after=2016-01-01T00:00:00Z", manifest, true, ""},
+		{"published_after=yesterday", manifest, false, "published_after"},
+		{"published_before=2016-03-02", manifest, false, "published_before"},
+		{"created_after=1456920000", manifest, false, "created_after"},
+		{"created_before=2016-13-02T12:00:00Z", manifest, false, "created_before"},
+		{"min_size=-1", manifest, false, "min_size"},
+		{"max_size=1k", manifest, false, "max_size"},
+		{"max_size=1.5", manifest, false, "max_size"},
+	}
+
+	for _, test := range tests {
+		query, _ := url.ParseQuery(test.query)
+
+		filters, err := rangeFilters(query)
+
+		if test.field != "" {
+			if err == nil || err.field != test.field {
+				t.Errorf("%s: should reject %s but got %v", test.query, test.field, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: should be accepted but got %s", test.query, err)
+			continue
+		}
+
+		match := true
+
+		for _, f := range filters {
+			match = match && f.Match(test.manifest)
+		}
+
+		if match != test.expected {
+			t.Errorf("%s: should be %v but got %v", test.query, test.expected, match)
+		}
+	}
+}
+
+func TestRangeFiltersAreRejectedByListings(t *testing.T) {
+	manifests, cleanup := newTestManifestStorage(t)
+	defer cleanup()
+
+	guest := &dsapid.UserResource{Name: dsapid.DefaultUserGuestName}
+
+	for _, field := range []string{"published_after", "published_before", "created_after", "created_before", "min_size", "max_size"} {
+		req := httptest.NewRequest("GET", "/datasets?"+field+"=bogus", nil)
+
+		status, body := ApiDatasetsList(testEncoder{}, martini.Params{}, manifests, guest, httptest.NewRecorder(), req)
+		if status != http.StatusBadRequest {
+			t.Errorf("dsapi %s: should be %d but got %d", field, http.StatusBadRequest, status)
+		}
+
+		var result dsapid.Table
+		json.Unmarshal(body, &result)
+
+		if result["error"] != "invalid "+field {
+			t.Errorf("dsapi %s: should name the field but got %s", field, body)
+		}
+
+		req = httptest.NewRequest("GET", "/images?"+field+"=bogus", nil)
+
+		status, body = ImgapiList(testEncoder{}, martini.Params{}, manifests, testManifestEncoder{}, nil, guest, httptest.NewRecorder(), req)
+		if status != http.StatusBadRequest {
+			t.Errorf("imgapi %s: should be %d but got %d", field, http.StatusBadRequest, status)
+		}
+
+		var problems struct {
+			Errors []dsapid.Table `json:"errors"`
+		}
+		json.Unmarshal(body, &problems)
+
+		if len(problems.Errors) != 1 || problems.Errors[0]["field"] != field {
+			t.Errorf("imgapi %s: should name the field but got %s", field, body)
+		}
+	}
+}
@@ -2,14 +2,18 @@ package main
 
 import (
 	"crypto/tls"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/MerlinDMC/dsapid"
 	"github.com/MerlinDMC/dsapid/converter"
 	"github.com/MerlinDMC/dsapid/converter/dsapi"
 	"github.com/MerlinDMC/dsapid/converter/imgapi"
+	"github.com/MerlinDMC/dsapid/server/fsck"
+	"github.com/MerlinDMC/dsapid/server/gc"
 	"github.com/MerlinDMC/dsapid/server/middleware"
 	dsapid_sync "github.com/MerlinDMC/dsapid/server/sync"
+	"github.com/MerlinDMC/dsapid/server/upload"
 	"github.com/MerlinDMC/dsapid/storage"
 	log "github.com/Sirupsen/logrus"
 	"github.com/go-martini/martini"
@@ -18,6 +22,7 @@ import (
 	"os"
 	"runtime"
 	"sync"
+	"time"
 )
 
 var (
@@ -27,6 +32,8 @@ var (
 	flagMaxFetches   int
 	flagLogLevel     string
 	flagPrettifyJson bool
+	flagMigrate      bool
+	flagFsck         bool
 )
 
 func init() {
@@ -36,6 +43,8 @@ func init() {
 	flag.IntVar(&flagMaxFetches, "max_fetches", 1, "number of parallel sync fetches")
 	flag.StringVar(&flagLogLevel, "log_level", "error", "log level for console logs [debug,info,warn,error,fatal,panic]")
 	flag.BoolVar(&flagPrettifyJson, "prettify", false, "prettify json output")
+	flag.BoolVar(&flagMigrate, "migrate", false, "import users and datasets from the files into the configured storage and exit")
+	flag.BoolVar(&flagFsck, "fsck", false, "verify the files of all datasets, quarantine broken ones and exit")
 }
 
 func main() {
@@ -80,6 +89,16 @@ func main() {
 
 	runtime.GOMAXPROCS(flagMaxCpu)
 
+	if flagMigrate {
+		if err := migrateStorage(config); err != nil {
+			log.Fatalf("error migrating storage: %s", err)
+
+			os.Exit(2)
+		}
+
+		os.Exit(0)
+	}
+
 	router := martini.NewRouter()
 
 	registerRoutes(router, config)
@@ -87,22 +106,59 @@ func main() {
 	handler := martini.New()
 	handler.Action(router.Handle)
 
-	log.WithFields(log.Fields{
-		"config": config.UsersConfig,
-	}).Debug("loading users")
-	user_storage := storage.NewUserStorage(config.UsersConfig)
+	user_storage, manifest_storage, err := openStorage(config)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"storage": config.Storage,
+		}).Fatalf("error loading storage: %s", err)
+
+		os.Exit(2)
+	}
+
+	if config.Watch.Enabled {
+		if watcher, err := storage.NewManifestWatcher(manifest_storage, time.Duration(config.Watch.Delay)); err == nil {
+			defer watcher.Close()
+
+			log.WithFields(log.Fields{
+				"directory": config.DataDir,
+			}).Debug("watching datasets")
+		} else {
+			log.WithFields(log.Fields{
+				"directory": config.DataDir,
+			}).Errorf("can't watch datasets: %s", err)
+		}
+	}
+
+	scrubber := fsck.NewScrubber(manifest_storage, time.Duration(config.Fsck.Interval))
 
-	log.WithFields(log.Fields{
-		"directory": config.DataDir,
-	}).Debug("loading datasets")
-	manifest_storage := storage.NewManifestStorage(config.DataDir)
+	if flagFsck {
+		report, _ := scrubber.Scrub()
+
+		if data, err := json.MarshalIndent(report, "", "  "); err == nil {
+			fmt.Println(string(data))
+		}
+
+		if len(report.Quarantined) > 0 {
+			os.Exit(1)
+		}
+
+		os.Exit(0)
+	}
+
+	collector := gc.NewCollector(manifest_storage, time.Duration(config.GarbageCollect.Interval), time.Duration(config.GarbageCollect.Grace), config.GarbageCollect.DryRun)
 
 	sync_manager := dsapid_sync.NewManager(flagMaxFetches, user_storage, manifest_storage)
 	sync_manager.Init()
 
+	uploads := upload.NewManager(manifest_storage, config.UploadDir(), time.Duration(config.Uploads.Expiry))
+
 	handler.MapTo(user_storage, (*storage.UserStorage)(nil))
 	handler.MapTo(manifest_storage, (*storage.ManifestStorage)(nil))
 	handler.MapTo(sync_manager, (*dsapid_sync.SyncManager)(nil))
+	handler.MapTo(collector, (*gc.Collector)(nil))
+	handler.MapTo(scrubber, (*fsck.Scrubber)(nil))
+	handler.MapTo(uploads, (*upload.Manager)(nil))
+	handler.Map(config.Channels)
 
 	handler.MapTo(dsapi.NewEncoder(config.BaseUrl, user_storage), (*converter.DsapiManifestEncoder)(nil))
 	handler.MapTo(imgapi.NewEncoder(config.BaseUrl, user_storage), (*converter.ImgapiManifestEncoder)(nil))
@@ -142,6 +198,24 @@ func main() {
 		os.Exit(2)
 	}
 
+	if err := collector.Run(); err != nil {
+		log.Fatalf("error starting garbage collector: %s", err)
+
+		os.Exit(2)
+	}
+
+	if err := scrubber.Run(); err != nil {
+		log.Fatalf("error starting scrubber: %s", err)
+
+		os.Exit(2)
+	}
+
+	if err := uploads.Run(); err != nil {
+		log.Fatalf("error starting upload manager: %s", err)
+
+		os.Exit(2)
+	}
+
 	var wg sync.WaitGroup
 
 	for server_name, server_config := range config.Listen {
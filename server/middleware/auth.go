@@ -28,14 +28,16 @@ func Auth(user_storage storage.UserStorage) martini.Handler {
 
 			parts := strings.SplitN(h[0], " ", 2)
 
-			if parts[0] == "Basic" {
+			if (parts[0] == "Token" || parts[0] == "Bearer") && len(parts) == 2 {
+				token = parts[1]
+			} else if parts[0] == "Basic" && len(parts) == 2 {
 				if b, err := base64.StdEncoding.DecodeString(parts[1]); err == nil {
 					parts = strings.Split(string(b), ":")
 					if len(parts) == 2 {
 						if len(parts[0]) > 0 {
 							token = parts[0]
 						} else if len(parts[1]) > 0 {
-							token = parts[0]
+							token = parts[1]
 						}
 					}
 				}
@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"github.com/MerlinDMC/dsapid"
+	"github.com/MerlinDMC/dsapid/storage"
+	"github.com/go-martini/martini"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ConditionalGet tags catalog responses with an ETag built from the
+// generations of the manifest and user storages and the requesting user,
+// who decides which images are visible, and answers If-None-Match and
+// If-Modified-Since with 304 Not Modified while neither changed. Only
+// successful responses are tagged; errors pass through untouched.
+func ConditionalGet() martini.Handler {
+	return func(ctx martini.Context, manifests storage.ManifestStorage, users storage.UserStorage, user User, res http.ResponseWriter, req *http.Request) {
+		if req.Method != "GET" && req.Method != "HEAD" {
+			return
+		}
+
+		manifest_generation, modified := manifests.Generation()
+		user_generation, users_modified := users.Generation()
+
+		if users_modified.After(modified) {
+			modified = users_modified
+		}
+
+		res.Header().Add("Vary", "Authorization")
+
+		ctx.MapTo(&conditionalResponseWriter{
+			ResponseWriter: res,
+			req:            req,
+			etag:           fmt.Sprintf("\"%x-%x-%x\"", manifest_generation, user_generation, userFingerprint(user)),
+			modified:       modified,
+		}, (*http.ResponseWriter)(nil))
+
+		ctx.Next()
+	}
+}
+
+// conditionalResponseWriter adds the validators once the status is known
+// and turns a 200 into a 304 without body if the preconditions match.
+type conditionalResponseWriter struct {
+	http.ResponseWriter
+
+	req      *http.Request
+	etag     string
+	modified time.Time
+
+	wroteHeader bool
+	discardBody bool
+}
+
+func (me *conditionalResponseWriter) WriteHeader(status int) {
+	if me.wroteHeader {
+		return
+	}
+
+	me.wroteHeader = true
+
+	if status == http.StatusOK {
+		header := me.Header()
+
+		header.Set("ETag", me.etag)
+		header.Set("Last-Modified", me.modified.UTC().Format(http.TimeFormat))
+
+		if notModified(me.req, me.etag, me.modified) {
+			header.Del("Content-Type")
+			header.Del("Content-Length")
+
+			status = http.StatusNotModified
+			me.discardBody = true
+		}
+	}
+
+	me.ResponseWriter.WriteHeader(status)
+}
+
+func (me *conditionalResponseWriter) Write(data []byte) (int, error) {
+	if !me.wroteHeader {
+		me.WriteHeader(http.StatusOK)
+	}
+
+	if me.discardBody {
+		return len(data), nil
+	}
+
+	return me.ResponseWriter.Write(data)
+}
+
+func userFingerprint(user User) []byte {
+	sum := sha1.Sum([]byte(fmt.Sprintf("%s:%t:%t",
+		user.GetId(),
+		!user.IsGuest() && user.HasRoles(dsapid.UserRoleAdmin),
+		!user.IsGuest() && user.HasRoles(dsapid.UserRoleDatasetAdmin),
+	)))
+
+	return sum[:8]
+}
+
+// notModified evaluates the preconditions; If-Modified-Since is only
+// looked at without an If-None-Match header.
+func notModified(req *http.Request, etag string, modified time.Time) bool {
+	if v := req.Header.Get("If-None-Match"); v != "" {
+		for _, tag := range strings.Split(v, ",") {
+			if tag = strings.TrimSpace(tag); tag == etag || tag == "*" {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if v := req.Header.Get("If-Modified-Since"); v != "" {
+		if t, err := http.ParseTime(v); err == nil {
+			return !modified.Truncate(time.Second).After(t)
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"github.com/MerlinDMC/dsapid"
+	"github.com/MerlinDMC/dsapid/storage"
+	"github.com/go-martini/martini"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func newConditionalGetServer(t *testing.T, users storage.UserStorage, status int) (*martini.Martini, func()) {
+	basedir, err := ioutil.TempDir("", "dsapid-middleware")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %s", err)
+	}
+
+	m := martini.New()
+
+	m.MapTo(storage.NewManifestStorage(basedir, nil), (*storage.ManifestStorage)(nil))
+	m.MapTo(users, (*storage.UserStorage)(nil))
+	m.MapTo(users.GuestUser(), (*User)(nil))
+
+	router := martini.NewRouter()
+	router.Get("/", ConditionalGet(), func() (int, string) {
+		return status, "body"
+	})
+
+	m.Action(router.Handle)
+
+	return m, func() {
+		os.RemoveAll(basedir)
+	}
+}
+
+func conditionalGet(m *martini.Martini, etag string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", "/", nil)
+
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	res := httptest.NewRecorder()
+	m.ServeHTTP(res, req)
+
+	return res
+}
+
+func TestConditionalGetTagsSuccessOnly(t *testing.T) {
+	users, _ := storage.NewUserStorage("")
+
+	m, cleanup := newConditionalGetServer(t, users, http.StatusNotFound)
+	defer cleanup()
+
+	if res := conditionalGet(m, ""); res.Code != http.StatusNotFound || res.Header().Get("ETag") != "" || res.Header().Get("Last-Modified") != "" {
+		t.Errorf("a 404 should not be tagged but got %d with ETag %q", res.Code, res.Header().Get("ETag"))
+	}
+
+	if res := conditionalGet(m, "*"); res.Code != http.StatusNotFound {
+		t.Errorf("a 404 should never turn into a 304 but got %d", res.Code)
+	}
+}
+
+func TestConditionalGetNotModified(t *testing.T) {
+	users, _ := storage.NewUserStorage("")
+
+	m, cleanup := newConditionalGetServer(t, users, http.StatusOK)
+	defer cleanup()
+
+	res := conditionalGet(m, "")
+
+	etag := res.Header().Get("ETag")
+	if res.Code != http.StatusOK || etag == "" || res.Body.String() != "body" {
+		t.Fatalf("expected a tagged 200 but got %d with ETag %q", res.Code, etag)
+	}
+
+	if res := conditionalGet(m, etag); res.Code != http.StatusNotModified || res.Body.Len() != 0 {
+		t.Errorf("expected 304 without body but got %d: %q", res.Code, res.Body.String())
+	}
+
+	// renaming a user changes what the catalog shows
+	users.Add("352971aa-31ba-496c-9ade-a379feaecd52", &dsapid.UserResource{
+		Uuid: "352971aa-31ba-496c-9ade-a379feaecd52",
+		Name: "renamed",
+	})
+
+	if res := conditionalGet(m, etag); res.Code != http.StatusOK || res.Header().Get("ETag") == etag {
+		t.Errorf("a user change should change the ETag but got %d with %q", res.Code, res.Header().Get("ETag"))
+	}
+}
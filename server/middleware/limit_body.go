@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"errors"
+	"github.com/go-martini/martini"
+	"io"
+	"net/http"
+)
+
+// ErrBodyTooLarge is returned while reading a body exceeding the limit of
+// LimitBody.
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// LimitBody rejects request bodies larger than max bytes. Bodies without a
+// known length fail with ErrBodyTooLarge while being read. A max of 0
+// disables the limit.
+func LimitBody(max int64) martini.Handler {
+	return func(req *http.Request, res http.ResponseWriter) {
+		if max <= 0 {
+			return
+		}
+
+		if req.ContentLength > max {
+			http.Error(res, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		req.Body = &limitedBody{
+			ReadCloser: req.Body,
+			res:        res,
+			remaining:  max,
+		}
+	}
+}
+
+// IsBodyTooLarge reports whether err was caused by a body exceeding the
+// limit of LimitBody. Readers like mime/multipart wrap the errors of the
+// body so the chain of Unwrap methods is followed.
+func IsBodyTooLarge(err error) bool {
+	for err != nil {
+		if err == ErrBodyTooLarge {
+			return true
+		}
+
+		wrapped, ok := err.(interface {
+			Unwrap() error
+		})
+		if !ok {
+			return false
+		}
+
+		err = wrapped.Unwrap()
+	}
+
+	return false
+}
+
+type limitedBody struct {
+	io.ReadCloser
+
+	res       http.ResponseWriter
+	remaining int64
+	exceeded  bool
+}
+
+func (me *limitedBody) Read(p []byte) (int, error) {
+	if me.exceeded {
+		return 0, ErrBodyTooLarge
+	}
+
+	// read a byte past the limit to tell a body of exactly max bytes from
+	// a larger one
+	if int64(len(p)) > me.remaining+1 {
+		p = p[:me.remaining+1]
+	}
+
+	n, err := me.ReadCloser.Read(p)
+
+	if int64(n) <= me.remaining {
+		me.remaining -= int64(n)
+
+		return n, err
+	}
+
+	me.exceeded = true
+
+	// the rest of the body is never read so the connection can't be reused
+	me.res.Header().Set("Connection", "close")
+
+	return int(me.remaining), ErrBodyTooLarge
+}
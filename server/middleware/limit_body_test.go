@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func limitBody(max int64, req *http.Request) *httptest.ResponseRecorder {
+	res := httptest.NewRecorder()
+
+	LimitBody(max).(func(*http.Request, http.ResponseWriter))(req, res)
+
+	return res
+}
+
+func TestLimitBodyKnownLength(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/", strings.NewReader("0123456789"))
+
+	if res := limitBody(8, req); res.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 but got %d", res.Code)
+	}
+}
+
+func TestLimitBodyUnknownLength(t *testing.T) {
+	for body, too_large := range map[string]bool{"01234567": false, "012345678": true} {
+		req, _ := http.NewRequest("POST", "/", ioutil.NopCloser(strings.NewReader(body)))
+		req.ContentLength = -1
+
+		res := limitBody(8, req)
+
+		data, err := ioutil.ReadAll(req.Body)
+
+		if IsBodyTooLarge(err) != too_large {
+			t.Errorf("reading %d bytes should fail %t but got %v", len(body), too_large, err)
+		}
+
+		if too_large && (len(data) != 8 || res.Header().Get("Connection") != "close") {
+			t.Errorf("expected the first 8 bytes and a closed connection but got %q", data)
+		}
+	}
+}
+
+type wrappedError struct {
+	err error
+}
+
+func (me wrappedError) Error() string {
+	return "wrapped: " + me.err.Error()
+}
+
+func (me wrappedError) Unwrap() error {
+	return me.err
+}
+
+func TestIsBodyTooLargeUnwraps(t *testing.T) {
+	if !IsBodyTooLarge(wrappedError{wrappedError{ErrBodyTooLarge}}) {
+		t.Error("should find ErrBodyTooLarge in wrapped errors")
+	}
+
+	if IsBodyTooLarge(wrappedError{io.ErrUnexpectedEOF}) {
+		t.Error("should not match other wrapped errors")
+	}
+
+	body := "--b\r\nContent-Disposition: form-data; name=\"file\"\r\n\r\n" + strings.Repeat("x", 64) + "\r\n--b\r\n"
+
+	req, _ := http.NewRequest("POST", "/", ioutil.NopCloser(strings.NewReader(body)))
+	req.ContentLength = -1
+
+	limitBody(32, req)
+
+	reader := multipart.NewReader(req.Body, "b")
+
+	_, err := reader.NextPart()
+	for err == nil {
+		_, err = reader.NextPart()
+	}
+
+	if !IsBodyTooLarge(err) {
+		t.Errorf("should detect the limit behind multipart errors but got %v", err)
+	}
+}
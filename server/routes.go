@@ -13,27 +13,52 @@ func registerRoutes(router martini.Router, config Config) {
 	router.Get("/status", handler.CommonStatus)
 
 	// dsapi
-	router.Get("/datasets", middleware.AllowCORS(), handler.DsapiList)
-	router.Get("/datasets/:id", middleware.AllowCORS(), handler.DsapiDetail)
+	router.Get("/datasets", middleware.AllowCORS(), middleware.ConditionalGet(), handler.DsapiList)
+	router.Get("/datasets/:id", middleware.AllowCORS(), middleware.ConditionalGet(), handler.DsapiDetail)
 	router.Get("/datasets/:id/:path", handler.DsapiFile)
 
 	// imgapi
-	router.Get("/images", middleware.AllowCORS(), handler.ImgapiList)
-	router.Get("/images/:id", middleware.AllowCORS(), handler.ImgapiDetail)
+	router.Get("/images", middleware.AllowCORS(), middleware.ConditionalGet(), handler.ImgapiList)
+	router.Post("/images", handler.ImgapiCreateImage)
+	router.Get("/images/latest/:name", middleware.AllowCORS(), middleware.ConditionalGet(), handler.ImgapiLatest)
+	router.Get("/images/:id", middleware.AllowCORS(), middleware.ConditionalGet(), handler.ImgapiDetail)
+	router.Post("/images/:id", handler.ImgapiImageAction)
+	router.Delete("/images/:id", handler.ImgapiDeleteImage)
+	router.Put("/images/:id/file", handler.ImgapiAddImageFile)
+	router.Post("/images/:id/acl", handler.ImgapiImageAcl)
+	router.Get("/images/:id/icon", middleware.AllowCORS(), handler.ImgapiGetImageIcon)
+	router.Post("/images/:id/icon", handler.ImgapiAddImageIcon)
+	router.Delete("/images/:id/icon", handler.ImgapiDeleteImageIcon)
 	router.Get("/images/:id/file", handler.ImgapiFile)
 	router.Get("/images/:id/file:file_idx", handler.ImgapiFile)
+	router.Get("/channels", middleware.AllowCORS(), handler.ImgapiChannels)
 
 	// public api
 	router.Group("/api", func(router martini.Router) {
-		router.Get("/datasets", middleware.AllowCORS(), handler.ApiDatasetsList)
-		router.Get("/datasets/:id", middleware.AllowCORS(), handler.ApiDatasetsDetail)
+		router.Get("/datasets", middleware.AllowCORS(), middleware.ConditionalGet(), handler.ApiDatasetsList)
+		router.Get("/datasets/:id", middleware.AllowCORS(), middleware.ConditionalGet(), handler.ApiDatasetsDetail)
+		router.Get("/search", middleware.AllowCORS(), middleware.ConditionalGet(), handler.ApiSearch)
 		router.Get("/export/:id", handler.ApiDatasetExport)
+		router.Post("/validate", handler.ApiPostValidate)
 	}, middleware.Throttle(config.Throttle.Api.ToQuota()))
 
 	// private api - update
 	router.Group("/api", func(router martini.Router) {
 		router.Post("/reload/datasets", handler.ApiPostReloadDatasets)
 		router.Post("/datasets/:id", handler.ApiPostDatasetUpdate)
+		router.Get("/datasets/:id/revisions", handler.ApiGetDatasetRevisions)
+		router.Get("/datasets/:id/revisions/:revision", handler.ApiGetDatasetRevision)
+		router.Get("/datasets/:id/revisions/:revision/diff", handler.ApiGetDatasetRevisionDiff)
+		router.Post("/datasets/:id/revisions/:revision/rollback", handler.ApiPostDatasetRollback)
+		router.Post("/datasets/:id/channels/:channel", handler.ApiPostDatasetChannel)
+		router.Delete("/datasets/:id/channels/:channel", handler.ApiDeleteDatasetChannel)
+		router.Get("/gc", handler.ApiGetCollectGarbage)
+		router.Post("/gc", handler.ApiPostCollectGarbage)
+		router.Get("/fsck", handler.ApiGetFsck)
+		router.Post("/fsck", handler.ApiPostFsck)
+		router.Get("/import", handler.ApiGetImports)
+		router.Post("/import", handler.ApiPostImport)
+		router.Get("/import/:id", handler.ApiGetImport)
 	}, middleware.RequireRoles(dsapid.UserRoleDatasetAdmin))
 
 	// private api - users
@@ -45,5 +70,14 @@ func registerRoutes(router martini.Router, config Config) {
 	}, middleware.RequireAdmin())
 
 	// private api - upload
-	router.Post("/api/upload", middleware.RequireRoles(dsapid.UserRoleDatasetUpload), handler.ApiPostFileUpload)
+	router.Post("/api/upload", middleware.RequireRoles(dsapid.UserRoleDatasetUpload), middleware.LimitBody(config.Uploads.MaxBodySize), handler.ApiPostFileUpload)
+
+	// private api - resumable uploads
+	router.Group("/api/uploads", func(router martini.Router) {
+		router.Post("", handler.ApiPostUploadSession)
+		router.Get("/:id", handler.ApiGetUploadSession)
+		router.Put("/:id", middleware.LimitBody(config.Uploads.MaxBodySize), handler.ApiPutUploadChunk)
+		router.Post("/:id/finalize", handler.ApiPostUploadFinalize)
+		router.Delete("/:id", handler.ApiDeleteUploadSession)
+	}, middleware.RequireRoles(dsapid.UserRoleDatasetUpload))
 }
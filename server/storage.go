@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"github.com/MerlinDMC/dsapid"
+	"github.com/MerlinDMC/dsapid/storage"
+	log "github.com/Sirupsen/logrus"
+)
+
+func openFileBackend(config Config) (storage.BlobBackend, error) {
+	switch config.Files.Backend {
+	case dsapid.FileBackendTypeLocal, "":
+		// manifest storages default to the local backend
+		return nil, nil
+	case dsapid.FileBackendTypeS3:
+		log.WithFields(log.Fields{
+			"endpoint": config.Files.S3.Endpoint,
+			"bucket":   config.Files.S3.Bucket,
+		}).Debug("using s3 file backend")
+
+		return storage.NewS3BlobBackend(config.Files.S3)
+	}
+
+	return nil, fmt.Errorf("unknown file backend: %s", config.Files.Backend)
+}
+
+func openStorage(config Config) (users storage.UserStorage, manifests storage.ManifestStorage, err error) {
+	backend, err := openFileBackend(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch config.Storage {
+	case dsapid.StorageTypeFilesystem, "":
+		log.WithFields(log.Fields{
+			"config": config.UsersConfig,
+		}).Debug("loading users")
+
+		if users, err = storage.NewUserStorage(config.UsersConfig); err != nil {
+			return nil, nil, err
+		}
+
+		log.WithFields(log.Fields{
+			"directory": config.DataDir,
+		}).Debug("loading datasets")
+
+		manifests = storage.NewManifestStorage(config.DataDir, backend)
+		break
+	case dsapid.StorageTypeBolt:
+		log.WithFields(log.Fields{
+			"database": config.DatabaseFile(),
+		}).Debug("opening database")
+
+		db, err := storage.OpenBoltDB(config.DatabaseFile())
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if users, err = storage.NewBoltUserStorage(db); err != nil {
+			return nil, nil, err
+		}
+
+		if manifests, err = storage.NewBoltManifestStorage(db, config.DataDir, backend); err != nil {
+			return nil, nil, err
+		}
+		break
+	default:
+		return nil, nil, fmt.Errorf("unknown storage type: %s", config.Storage)
+	}
+
+	return users, manifests, nil
+}
+
+// migrateStorage imports the users file and the manifests from datadir into
+// the storage backend selected by config.
+func migrateStorage(config Config) error {
+	if config.Storage == dsapid.StorageTypeFilesystem || config.Storage == "" {
+		return fmt.Errorf("nothing to migrate for storage type: %s", dsapid.StorageTypeFilesystem)
+	}
+
+	from_users, err := storage.NewUserStorage(config.UsersConfig)
+	if err != nil {
+		return err
+	}
+
+	backend, err := openFileBackend(config)
+	if err != nil {
+		return err
+	}
+
+	from_manifests := storage.NewManifestStorage(config.DataDir, backend)
+
+	to_users, to_manifests, err := openStorage(config)
+	if err != nil {
+		return err
+	}
+
+	count, err := storage.MigrateUsers(to_users, from_users)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"count": count,
+	}).Info("migrated users")
+
+	count, err = storage.MigrateManifests(to_manifests, from_manifests)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"count": count,
+	}).Info("migrated manifests")
+
+	return nil
+}
@@ -7,4 +7,10 @@ import (
 var (
 	ErrSyncAlreadyRunning  error = errors.New("sync already running")
 	ErrChecksumNotMatching error = errors.New("checksum mismatch")
+	ErrIconTooLarge        error = errors.New("icon too large")
+	ErrInvalidImageUuid    error = errors.New("invalid image uuid")
+	ErrImageExists         error = errors.New("image already exists")
+	ErrUnknownSyncType     error = errors.New("unknown sync type")
+	ErrNoFiles             error = errors.New("image has no files")
+	ErrSyncStopped         error = errors.New("sync stopped")
 )
@@ -0,0 +1,245 @@
+package sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/MerlinDMC/dsapid"
+	"github.com/MerlinDMC/dsapid/converter"
+	"github.com/MerlinDMC/dsapid/converter/decoder"
+	log "github.com/Sirupsen/logrus"
+	"github.com/pborman/uuid"
+	"net/http"
+	"sort"
+	"time"
+)
+
+type ImportState string
+
+const (
+	ImportStateQueued      ImportState = "queued"
+	ImportStateFetching    ImportState = "fetching"
+	ImportStateDownloading ImportState = "downloading"
+	ImportStateDone        ImportState = "done"
+	ImportStateFailed      ImportState = "failed"
+)
+
+// DefaultImportRetention is how long finished import jobs are kept for their
+// status to be queried.
+const DefaultImportRetention = 24 * time.Hour
+
+// ImportJob reports the progress of importing a single remote image.
+type ImportJob struct {
+	Uuid       string          `json:"uuid"`
+	Image      string          `json:"image_uuid"`
+	Source     string          `json:"source"`
+	Type       dsapid.SyncType `json:"type"`
+	State      ImportState     `json:"state"`
+	Error      string          `json:"error,omitempty"`
+	Files      int             `json:"files"`
+	FilesDone  int             `json:"files_done"`
+	CreatedAt  time.Time       `json:"created_at"`
+	FinishedAt time.Time       `json:"finished_at"`
+}
+
+// importer is implemented by syncers able to fetch a single image.
+type importer interface {
+	Syncer
+	fetchJob(string) (*syncerDownloadJob, error)
+}
+
+// Import fetches the image id from source and queues its files for
+// download. The returned job tracks the progress.
+func (me *syncManager) Import(source dsapid.SyncSourceResource, id string) (ImportJob, error) {
+	if uuid.Parse(id) == nil {
+		return ImportJob{}, ErrInvalidImageUuid
+	}
+
+	if _, ok := me.manifests.GetOK(id); ok {
+		return ImportJob{}, ErrImageExists
+	}
+
+	if source.Name == "" {
+		source.Name = "import"
+	}
+
+	var syncer importer
+
+	switch source.Type {
+	case dsapid.SyncTypeDsapi:
+		syncer = &dsapiSyncer{
+			source:    &source,
+			users:     me.users,
+			manifests: me.manifests,
+		}
+	case dsapid.SyncTypeImgapi:
+		syncer = &imgapiSyncer{
+			source:    &source,
+			users:     me.users,
+			manifests: me.manifests,
+		}
+	default:
+		return ImportJob{}, ErrUnknownSyncType
+	}
+
+	if err := syncer.Init(me.q_download); err != nil {
+		return ImportJob{}, err
+	}
+
+	job := &ImportJob{
+		Uuid:      uuid.New(),
+		Image:     id,
+		Source:    source.Source,
+		Type:      source.Type,
+		State:     ImportStateQueued,
+		CreatedAt: time.Now(),
+	}
+
+	me.importLock.Lock()
+	me.expireImports()
+	me.imports[job.Uuid] = job
+	me.importLock.Unlock()
+
+	log.WithFields(log.Fields{
+		"job_uuid":   job.Uuid,
+		"image_uuid": id,
+		"source":     source.Source,
+	}).Info("importing image")
+
+	go me.runImport(syncer, job)
+
+	return *job, nil
+}
+
+func (me *syncManager) ImportStatus(id string) (ImportJob, bool) {
+	me.importLock.Lock()
+	defer me.importLock.Unlock()
+
+	me.expireImports()
+
+	if job, ok := me.imports[id]; ok {
+		return *job, true
+	}
+
+	return ImportJob{}, false
+}
+
+// ImportJobs returns all import jobs, newest first.
+func (me *syncManager) ImportJobs() []ImportJob {
+	me.importLock.Lock()
+	defer me.importLock.Unlock()
+
+	me.expireImports()
+
+	jobs := make([]ImportJob, 0, len(me.imports))
+
+	for _, job := range me.imports {
+		jobs = append(jobs, *job)
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+
+	return jobs
+}
+
+// expireImports forgets the jobs finished longer than ImportRetention ago;
+// importLock has to be held.
+func (me *syncManager) expireImports() {
+	for id, job := range me.imports {
+		if !job.FinishedAt.IsZero() && time.Since(job.FinishedAt) > me.ImportRetention {
+			delete(me.imports, id)
+		}
+	}
+}
+
+func (me *syncManager) runImport(syncer importer, job *ImportJob) {
+	me.updateImport(job, func() {
+		job.State = ImportStateFetching
+	})
+
+	download, err := syncer.fetchJob(job.Image)
+	if err == nil && len(download.files) == 0 {
+		err = ErrNoFiles
+	}
+
+	if err != nil {
+		me.finishImport(job, err)
+		return
+	}
+
+	download.status = job
+
+	me.updateImport(job, func() {
+		job.State = ImportStateDownloading
+		job.Files = len(download.files)
+	})
+
+	select {
+	case me.q_download <- download:
+	case <-me.s_stop:
+		me.finishImport(job, ErrSyncStopped)
+	}
+}
+
+func (me *syncManager) updateImport(job *ImportJob, fn func()) {
+	if job == nil {
+		return
+	}
+
+	me.importLock.Lock()
+	defer me.importLock.Unlock()
+
+	fn()
+}
+
+func (me *syncManager) finishImport(job *ImportJob, err error) {
+	me.updateImport(job, func() {
+		job.FinishedAt = time.Now()
+
+		if err != nil {
+			job.State = ImportStateFailed
+			job.Error = err.Error()
+		} else {
+			job.State = ImportStateDone
+		}
+	})
+
+	if job != nil {
+		log.WithFields(log.Fields{
+			"job_uuid":   job.Uuid,
+			"image_uuid": job.Image,
+		}).Infof("import finished: %s", job.State)
+	}
+}
+
+// fetchManifest downloads, validates and decodes the manifest of the image
+// id.
+func fetchManifest(client *http.Client, src string, manifest_decoder converter.ManifestDecoder, imgapi bool, id string) (*dsapid.ManifestResource, error) {
+	res, err := client.Get(src)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", src, res.Status)
+	}
+
+	var data dsapid.Table
+
+	if err := json.NewDecoder(res.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+
+	if problems := decoder.ValidateManifest(data, decoder.ValidationOptions{Imgapi: imgapi}); len(problems) > 0 {
+		return nil, problems
+	}
+
+	manifest := manifest_decoder.Decode(data)
+	if manifest == nil || manifest.Uuid != id {
+		return nil, fmt.Errorf("%s: not the manifest of %s", src, id)
+	}
+
+	return manifest, nil
+}
@@ -0,0 +1,216 @@
+package sync
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"github.com/MerlinDMC/dsapid"
+	"github.com/MerlinDMC/dsapid/storage"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testImageUuid = "f9e4be48-9466-11e1-bc41-9f993f5dff36"
+
+// newTestUpstream serves the image testImageUuid with a file for each data
+// like an IMGAPI. Requests to broken fail.
+func newTestUpstream(broken string, data ...string) *httptest.Server {
+	files := make([]string, 0, len(data))
+	mux := http.NewServeMux()
+
+	for idx, d := range data {
+		sum := sha1.Sum([]byte(d))
+
+		files = append(files, fmt.Sprintf(`{"compression": "gzip", "size": %d, "sha1": "%s"}`, len(d), hex.EncodeToString(sum[:])))
+
+		file_url := "/images/" + testImageUuid + "/file"
+		if idx > 0 {
+			file_url = fmt.Sprintf("%s%d", file_url, idx)
+		}
+
+		d := d
+
+		mux.HandleFunc(file_url, func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte(d))
+		})
+	}
+
+	mux.HandleFunc("/images/"+testImageUuid, func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, `{
+			"v": 2, "uuid": "%s", "name": "base", "version": "1.0.0",
+			"os": "smartos", "type": "zone-dataset", "state": "active",
+			"published_at": "2012-05-02T15:15:24Z",
+			"files": [%s]
+		}`, testImageUuid, strings.Join(files, ", "))
+	})
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == broken {
+			http.Error(w, "broken", http.StatusInternalServerError)
+			return
+		}
+
+		mux.ServeHTTP(w, req)
+	}))
+}
+
+func newTestManager(t *testing.T) (*syncManager, storage.ManifestStorage, func()) {
+	basedir, err := ioutil.TempDir("", "dsapid-sync")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %s", err)
+	}
+
+	users, _ := storage.NewUserStorage("")
+	manifests := storage.NewManifestStorage(basedir, nil)
+
+	manager := NewManager(1, users, manifests).(*syncManager)
+	manager.Init()
+	manager.Run()
+
+	return manager, manifests, func() {
+		manager.Stop()
+		os.RemoveAll(basedir)
+	}
+}
+
+func waitForImport(t *testing.T, manager *syncManager, id string) ImportJob {
+	for i := 0; i < 500; i++ {
+		if job, ok := manager.ImportStatus(id); ok && !job.FinishedAt.IsZero() {
+			return job
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("import %s didn't finish", id)
+
+	return ImportJob{}
+}
+
+func TestImport(t *testing.T) {
+	upstream := newTestUpstream("", "image data")
+	defer upstream.Close()
+
+	manager, manifests, cleanup := newTestManager(t)
+	defer cleanup()
+
+	source := dsapid.SyncSourceResource{
+		Type:     dsapid.SyncTypeImgapi,
+		Provider: dsapid.SyncProviderCommunity,
+		Source:   upstream.URL,
+	}
+
+	if _, err := manager.Import(source, "not-a-uuid"); err != ErrInvalidImageUuid {
+		t.Errorf("expected ErrInvalidImageUuid but got %v", err)
+	}
+
+	job, err := manager.Import(source, testImageUuid)
+	if err != nil {
+		t.Fatalf("import failed: %s", err)
+	}
+
+	if job.State != ImportStateQueued {
+		t.Errorf("new job should be queued but is %s", job.State)
+	}
+
+	if job = waitForImport(t, manager, job.Uuid); job.State != ImportStateDone || job.Files != 1 || job.FilesDone != 1 {
+		t.Fatalf("import should be done but is %s (%d/%d files): %s", job.State, job.FilesDone, job.Files, job.Error)
+	}
+
+	manifest, ok := manifests.GetOK(testImageUuid)
+	if !ok {
+		t.Fatal("imported image is missing")
+	}
+
+	if r, err := manifests.OpenFile(manifest, &manifest.Files[0]); err != nil {
+		t.Errorf("file of the imported image is missing: %s", err)
+	} else {
+		r.Close()
+	}
+
+	if _, err := manager.Import(source, testImageUuid); err != ErrImageExists {
+		t.Errorf("expected ErrImageExists but got %v", err)
+	}
+}
+
+func TestImportFailure(t *testing.T) {
+	upstream := newTestUpstream("", "image data")
+	defer upstream.Close()
+
+	manager, manifests, cleanup := newTestManager(t)
+	defer cleanup()
+
+	id := "352971aa-31ba-496c-9ade-a379feaecd52"
+
+	job, err := manager.Import(dsapid.SyncSourceResource{
+		Type:   dsapid.SyncTypeImgapi,
+		Source: upstream.URL,
+	}, id)
+	if err != nil {
+		t.Fatalf("import failed: %s", err)
+	}
+
+	if job = waitForImport(t, manager, job.Uuid); job.State != ImportStateFailed || job.Error == "" {
+		t.Errorf("import of an unknown image should fail but is %s", job.State)
+	}
+
+	if _, ok := manifests.GetOK(id); ok {
+		t.Error("failed import should not add an image")
+	}
+}
+
+func TestImportJobsExpire(t *testing.T) {
+	manager, _, cleanup := newTestManager(t)
+	defer cleanup()
+
+	running := &ImportJob{Uuid: "running", State: ImportStateDownloading, CreatedAt: time.Now()}
+	finished := &ImportJob{Uuid: "finished", CreatedAt: time.Now()}
+
+	manager.imports[running.Uuid] = running
+	manager.imports[finished.Uuid] = finished
+
+	manager.finishImport(finished, nil)
+
+	if jobs := manager.ImportJobs(); len(jobs) != 2 {
+		t.Errorf("finished jobs should be kept for a while but got %d jobs", len(jobs))
+	}
+
+	manager.ImportRetention = 0
+
+	if _, ok := manager.ImportStatus(finished.Uuid); ok {
+		t.Error("finished job should have expired")
+	}
+
+	if jobs := manager.ImportJobs(); len(jobs) != 1 || jobs[0].Uuid != running.Uuid {
+		t.Errorf("only the running job should be left but got %v", jobs)
+	}
+}
+
+func TestImportFailsWithoutPublishingPartialImages(t *testing.T) {
+	upstream := newTestUpstream("/images/"+testImageUuid+"/file1", "root data", "data data")
+	defer upstream.Close()
+
+	manager, manifests, cleanup := newTestManager(t)
+	defer cleanup()
+
+	job, err := manager.Import(dsapid.SyncSourceResource{
+		Type:   dsapid.SyncTypeImgapi,
+		Source: upstream.URL,
+	}, testImageUuid)
+	if err != nil {
+		t.Fatalf("import failed: %s", err)
+	}
+
+	if job = waitForImport(t, manager, job.Uuid); job.State != ImportStateFailed || job.Files != 2 || job.FilesDone != 1 {
+		t.Errorf("import should fail after 1 of 2 files but is %s (%d/%d files)", job.State, job.FilesDone, job.Files)
+	}
+
+	if _, ok := manifests.GetOK(testImageUuid); ok {
+		t.Error("failed import should not publish the image")
+	}
+}
@@ -5,13 +5,16 @@ import (
 	"crypto/sha1"
 	"crypto/tls"
 	"encoding/hex"
+	"fmt"
 	"github.com/MerlinDMC/dsapid"
 	"github.com/MerlinDMC/dsapid/storage"
 	log "github.com/Sirupsen/logrus"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
-	"os"
+	"sync"
+	"time"
 )
 
 type Syncer interface {
@@ -25,15 +28,23 @@ type SyncManager interface {
 	Stop()
 	Add(Syncer) error
 	NewSyncer(dsapid.SyncSourceResource) error
+	Import(dsapid.SyncSourceResource, string) (ImportJob, error)
+	ImportStatus(string) (ImportJob, bool)
+	ImportJobs() []ImportJob
 }
 
 type syncerDownloadJob struct {
 	manifest *dsapid.ManifestResource
 	files    []*url.URL
+	// icon is fetched along with the files if set
+	icon *url.URL
+	// status of an import job or nil for synced images
+	status *ImportJob
 }
 
 type syncManager struct {
 	ParallelFetches int
+	ImportRetention time.Duration
 	client          *http.Client
 
 	users     storage.UserStorage
@@ -42,11 +53,15 @@ type syncManager struct {
 	syncer     []Syncer
 	q_download chan *syncerDownloadJob
 	s_stop     chan struct{}
+
+	imports    map[string]*ImportJob
+	importLock sync.Mutex
 }
 
 func NewManager(parallel_fetches int, users storage.UserStorage, manifests storage.ManifestStorage) SyncManager {
 	manager := &syncManager{
 		ParallelFetches: parallel_fetches,
+		ImportRetention: DefaultImportRetention,
 		users:           users,
 		manifests:       manifests,
 	}
@@ -57,6 +72,7 @@ func NewManager(parallel_fetches int, users storage.UserStorage, manifests stora
 func (me *syncManager) Init() error {
 	me.syncer = make([]Syncer, 0)
 	me.q_download = make(chan *syncerDownloadJob)
+	me.imports = make(map[string]*ImportJob)
 
 	me.client = &http.Client{
 		Transport: &http.Transport{
@@ -132,46 +148,10 @@ func (me *syncManager) processDownloadJobs() {
 			log.Info("received stop signal. exiting processing loop.")
 			return
 		case job := <-me.q_download:
-			if _, ok := me.manifests.GetOK(job.manifest.Uuid); !ok {
-				log.WithFields(log.Fields{
-					"image_uuid":    job.manifest.Uuid,
-					"image_name":    job.manifest.Name,
-					"image_version": job.manifest.Version,
-				}).Info("need to fetch new image")
-
-				if err := os.MkdirAll(me.manifests.ManifestPath(job.manifest), 0770); err == nil {
-					for file_idx, src := range job.files {
-						filename := me.manifests.FilePath(job.manifest, &job.manifest.Files[file_idx])
-
-					retryFetch:
-						for retry := 0; retry < 3; retry++ {
-							if err := me.downloadManifestFile(src, filename, &job.manifest.Files[file_idx]); err != nil {
-								log.Errorf("download error: %s", err)
-
-								// .. check size and maybe delete erroneous file
-								if fs, err := os.Stat(filename); err == nil {
-									if fs.Size() >= job.manifest.Files[file_idx].Size {
-										if err := os.Remove(filename); err != nil {
-											log.Errorf("can't remove erroneous file: %s", err)
-										}
-									}
-								}
-
-								log.Infof("retry download on file: %s", src.String())
-
-								continue retryFetch
-							} else {
-								me.manifests.Add(job.manifest.Uuid, job.manifest)
-
-								break
-							}
-						}
-					}
-				} else {
-					log.WithFields(log.Fields{
-						"directory": me.manifests.ManifestPath(job.manifest),
-					}).Error("can't create manifest directory")
-				}
+			err := me.download(job)
+
+			if job.status != nil {
+				me.finishImport(job.status, err)
 			}
 
 			break
@@ -179,20 +159,106 @@ func (me *syncManager) processDownloadJobs() {
 	}
 }
 
-func (me *syncManager) downloadManifestFile(src *url.URL, filename string, file *dsapid.ManifestFileResource) (err error) {
+// download fetches the icon and every file of job and only then adds the
+// manifest, so an image is never published with files still missing. The
+// manifest belongs to the storage afterwards and isn't touched anymore.
+func (me *syncManager) download(job *syncerDownloadJob) error {
+	if _, ok := me.manifests.GetOK(job.manifest.Uuid); ok {
+		return ErrImageExists
+	}
+
+	log.WithFields(log.Fields{
+		"image_uuid":    job.manifest.Uuid,
+		"image_name":    job.manifest.Name,
+		"image_version": job.manifest.Version,
+	}).Info("need to fetch new image")
+
+	if job.icon != nil {
+		if err := me.downloadIcon(job.icon, job.manifest); err != nil {
+			log.WithFields(log.Fields{
+				"image_uuid": job.manifest.Uuid,
+			}).Errorf("icon download error: %s", err)
+
+			job.manifest.Icon = false
+		}
+	}
+
+	for file_idx, src := range job.files {
+		var err error
+
+		for retry := 0; retry < 3; retry++ {
+			if err = me.downloadManifestFile(src, job.manifest, &job.manifest.Files[file_idx]); err == nil {
+				break
+			}
+
+			log.Errorf("download error: %s", err)
+
+			log.Infof("retry download on file: %s", src.String())
+		}
+
+		// files written so far are left to the garbage collector
+		if err != nil {
+			return err
+		}
+
+		me.updateImport(job.status, func() {
+			job.status.FilesDone++
+		})
+	}
+
+	// a concurrent sync or import may have added the image meanwhile
+	if err := me.manifests.Insert(job.manifest.Uuid, job.manifest); err == storage.ErrStorageItemExists {
+		return ErrImageExists
+	} else if err != nil {
+		log.WithFields(log.Fields{
+			"image_uuid": job.manifest.Uuid,
+		}).Errorf("can't save manifest: %s", err)
+
+		return err
+	}
+
+	return nil
+}
+
+// downloadIcon stores the icon at src for manifest; icons exceeding the
+// size accepted on upload are refused.
+func (me *syncManager) downloadIcon(src *url.URL, manifest *dsapid.ManifestResource) error {
+	res, err := me.client.Get(src.String())
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", src, res.Status)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(res.Body, dsapid.MaxIconSize+1))
+	if err != nil {
+		return err
+	}
+
+	if int64(len(data)) > dsapid.MaxIconSize {
+		return ErrIconTooLarge
+	}
+
+	return me.manifests.SaveIcon(manifest, data)
+}
+
+func (me *syncManager) downloadManifestFile(src *url.URL, manifest *dsapid.ManifestResource, file *dsapid.ManifestFileResource) (err error) {
 	hash_md5 := md5.New()
 	hash_sha1 := sha1.New()
 
 	log.WithFields(log.Fields{
-		"src":      src.String(),
-		"filename": filename,
-		"size":     file.Size,
+		"src":   src.String(),
+		"image": manifest.Uuid,
+		"size":  file.Size,
 	}).Info("starting download of manifest file")
 
 	if wget, err := me.client.Get(src.String()); err == nil {
 		defer wget.Body.Close()
 
-		if file_out, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY, 0660); err == nil {
+		if file_out, err := me.manifests.CreateFile(manifest, file); err == nil {
 			defer file_out.Close()
 
 			writer := io.MultiWriter(file_out, hash_md5, hash_sha1)
@@ -221,9 +287,9 @@ func (me *syncManager) downloadManifestFile(src *url.URL, filename string, file
 				file.Sha1 = sha1_sum
 
 				log.WithFields(log.Fields{
-					"src":      src.String(),
-					"filename": filename,
-					"size":     file.Size,
+					"src":   src.String(),
+					"image": manifest.Uuid,
+					"size":  file.Size,
 				}).Info("finished download of manifest file")
 			} else {
 				log.Error(err.Error())
@@ -0,0 +1,170 @@
+package sync
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/MerlinDMC/dsapid"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func newTestDownloadJob(t *testing.T, base string, files map[string]string, paths ...string) *syncerDownloadJob {
+	job := &syncerDownloadJob{
+		manifest: &dsapid.ManifestResource{
+			Uuid:    testImageUuid,
+			Name:    "base",
+			Version: "1.0.0",
+			State:   dsapid.ManifestStateActive,
+			Type:    dsapid.ManifestTypeZone,
+		},
+	}
+
+	for _, p := range paths {
+		sum := sha1.Sum([]byte(files[p]))
+
+		job.manifest.Files = append(job.manifest.Files, dsapid.ManifestFileResource{
+			Path: p,
+			Size: int64(len(files[p])),
+			Sha1: hex.EncodeToString(sum[:]),
+		})
+
+		u, err := url.Parse(base + "/" + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		job.files = append(job.files, u)
+	}
+
+	return job
+}
+
+func newTestFileServer(files map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if data, ok := files[req.URL.Path[1:]]; ok {
+			w.Write([]byte(data))
+		} else {
+			http.NotFound(w, req)
+		}
+	}))
+}
+
+func TestDownloadAddsCompleteImagesOnly(t *testing.T) {
+	files := map[string]string{"root.zfs": "root", "data.zfs": "data"}
+
+	upstream := newTestFileServer(files)
+	defer upstream.Close()
+
+	manager, manifests, cleanup := newTestManager(t)
+	defer cleanup()
+
+	// the second file can't be fetched so nothing may be published
+	job := newTestDownloadJob(t, upstream.URL, files, "root.zfs", "missing.zfs")
+
+	if err := manager.download(job); err == nil {
+		t.Error("download with a missing file should fail")
+	}
+
+	if _, ok := manifests.GetOK(testImageUuid); ok {
+		t.Fatal("image with a missing file should not be published")
+	}
+
+	job = newTestDownloadJob(t, upstream.URL, files, "root.zfs", "data.zfs")
+
+	if err := manager.download(job); err != nil {
+		t.Fatalf("download failed: %s", err)
+	}
+
+	manifest, ok := manifests.GetOK(testImageUuid)
+	if !ok {
+		t.Fatal("downloaded image is missing")
+	}
+
+	for i := range manifest.Files {
+		if r, err := manifests.OpenFile(manifest, &manifest.Files[i]); err != nil {
+			t.Errorf("file %s is missing: %s", manifest.Files[i].Path, err)
+		} else {
+			r.Close()
+		}
+	}
+
+	if err := manager.download(newTestDownloadJob(t, upstream.URL, files, "root.zfs")); err != ErrImageExists {
+		t.Errorf("expected ErrImageExists but got %v", err)
+	}
+}
+
+func TestDownloadCopiesIcon(t *testing.T) {
+	icon := "\x89PNG\r\n\x1a\nicon"
+	files := map[string]string{"root.zfs": "root", "icon": icon}
+
+	upstream := newTestFileServer(files)
+	defer upstream.Close()
+
+	manager, manifests, cleanup := newTestManager(t)
+	defer cleanup()
+
+	job := newTestDownloadJob(t, upstream.URL, files, "root.zfs")
+	job.manifest.Icon = true
+	job.icon, _ = url.Parse(upstream.URL + "/icon")
+
+	if err := manager.download(job); err != nil {
+		t.Fatalf("download failed: %s", err)
+	}
+
+	manifest, ok := manifests.GetOK(testImageUuid)
+	if !ok || !manifest.Icon {
+		t.Fatal("image should be added with its icon")
+	}
+
+	r, err := manifests.OpenIcon(manifest)
+	if err != nil {
+		t.Fatalf("can't open icon: %s", err)
+	}
+	defer r.Close()
+
+	if data, _ := ioutil.ReadAll(r); string(data) != icon {
+		t.Errorf("icon should be %q but got %q", icon, data)
+	}
+}
+
+func TestDownloadSkipsTooLargeIcon(t *testing.T) {
+	files := map[string]string{"root.zfs": "root", "icon": strings.Repeat("x", int(dsapid.MaxIconSize)+1)}
+
+	upstream := newTestFileServer(files)
+	defer upstream.Close()
+
+	manager, manifests, cleanup := newTestManager(t)
+	defer cleanup()
+
+	icon, _ := url.Parse(upstream.URL + "/icon")
+
+	if err := manager.downloadIcon(icon, &dsapid.ManifestResource{Uuid: testImageUuid}); err != ErrIconTooLarge {
+		t.Errorf("should refuse the icon with ErrIconTooLarge but got %v", err)
+	}
+
+	job := newTestDownloadJob(t, upstream.URL, files, "root.zfs")
+	job.manifest.Icon = true
+	job.icon = icon
+
+	if err := manager.download(job); err != nil {
+		t.Fatalf("download failed: %s", err)
+	}
+
+	manifest, ok := manifests.GetOK(testImageUuid)
+	if !ok {
+		t.Fatal("image should be added without its icon")
+	}
+
+	if manifest.Icon {
+		t.Error("image should be added without icon")
+	}
+
+	if r, err := manifests.OpenIcon(manifest); err == nil {
+		r.Close()
+		t.Error("too large icon should not be stored")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/MerlinDMC/dsapid"
 	"github.com/MerlinDMC/dsapid/converter"
+	"github.com/MerlinDMC/dsapid/converter/decoder"
 	"github.com/MerlinDMC/dsapid/converter/dsapi"
 	"github.com/MerlinDMC/dsapid/storage"
 	log "github.com/Sirupsen/logrus"
@@ -90,6 +91,15 @@ func (me *dsapiSyncer) Run(stop chan struct{}) error {
 
 				nextItem:
 					for _, item := range entries {
+						if problems := decoder.ValidateManifest(item, decoder.ValidationOptions{}); len(problems) > 0 {
+							log.WithFields(log.Fields{
+								"name":       me.source.Name,
+								"image_uuid": converter.DecodeToString(item["uuid"]),
+							}).Warnf("sync error: %s", problems)
+
+							continue nextItem
+						}
+
 						if manifest := me.decoder.Decode(item); manifest == nil {
 							log.WithFields(log.Fields{
 								"name": me.source.Name,
@@ -97,22 +107,7 @@ func (me *dsapiSyncer) Run(stop chan struct{}) error {
 
 							continue nextItem
 						} else {
-							job := syncerDownloadJob{
-								manifest: manifest,
-								files:    make([]*url.URL, 0),
-							}
-
-							manifest.SyncInfo["time"] = time.Now().Format(time.RFC3339)
-							manifest.SyncInfo["from"] = me.source.Source
-							manifest.SyncInfo["type"] = me.source.Type
-
-							for _, file := range manifest.Files {
-								if u, err := url.Parse(fmt.Sprintf("/datasets/%s/%s", manifest.Uuid, file.Path)); err == nil {
-									job.files = append(job.files, me.base.ResolveReference(u))
-								}
-							}
-
-							me.queue <- &job
+							me.queue <- me.newJob(manifest)
 						}
 					}
 				} else {
@@ -132,3 +127,37 @@ func (me *dsapiSyncer) Run(stop chan struct{}) error {
 
 	return nil
 }
+
+func (me *dsapiSyncer) newJob(manifest *dsapid.ManifestResource) *syncerDownloadJob {
+	job := &syncerDownloadJob{
+		manifest: manifest,
+		files:    make([]*url.URL, 0),
+	}
+
+	manifest.SyncInfo["time"] = time.Now().Format(time.RFC3339)
+	manifest.SyncInfo["from"] = me.source.Source
+	manifest.SyncInfo["type"] = me.source.Type
+
+	for _, file := range manifest.Files {
+		if u, err := url.Parse(fmt.Sprintf("/datasets/%s/%s", manifest.Uuid, file.Path)); err == nil {
+			job.files = append(job.files, me.base.ResolveReference(u))
+		}
+	}
+
+	return job
+}
+
+// fetchJob prepares the download of the single dataset id.
+func (me *dsapiSyncer) fetchJob(id string) (*syncerDownloadJob, error) {
+	u, err := url.Parse(fmt.Sprintf("/datasets/%s", id))
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := fetchManifest(me.client, me.base.ResolveReference(u).String(), me.decoder, false, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return me.newJob(manifest), nil
+}
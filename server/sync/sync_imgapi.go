@@ -6,11 +6,13 @@ import (
 	"fmt"
 	"github.com/MerlinDMC/dsapid"
 	"github.com/MerlinDMC/dsapid/converter"
+	"github.com/MerlinDMC/dsapid/converter/decoder"
 	"github.com/MerlinDMC/dsapid/converter/imgapi"
 	"github.com/MerlinDMC/dsapid/storage"
 	log "github.com/Sirupsen/logrus"
 	"net/http"
 	"net/url"
+	"strconv"
 	"time"
 )
 
@@ -79,10 +81,8 @@ func (me *imgapiSyncer) Run(stop chan struct{}) error {
 					"name": me.source.Name,
 				}).Info("sync started")
 
-				if res, err := me.client.Get(me.source.Source); err == nil {
-					var entries []dsapid.Table
-
-					if err = json.NewDecoder(res.Body).Decode(&entries); err != nil {
+				if entries, err := me.fetchEntries(); err == nil || len(entries) > 0 {
+					if err != nil {
 						log.WithFields(log.Fields{
 							"name": me.source.Name,
 						}).Errorf("sync error: %s", err)
@@ -90,6 +90,15 @@ func (me *imgapiSyncer) Run(stop chan struct{}) error {
 
 				nextItem:
 					for _, item := range entries {
+						if problems := decoder.ValidateManifest(item, decoder.ValidationOptions{Imgapi: true}); len(problems) > 0 {
+							log.WithFields(log.Fields{
+								"name":       me.source.Name,
+								"image_uuid": converter.DecodeToString(item["uuid"]),
+							}).Warnf("sync error: %s", problems)
+
+							continue nextItem
+						}
+
 						if manifest := me.decoder.Decode(item); manifest == nil {
 							log.WithFields(log.Fields{
 								"name": me.source.Name,
@@ -97,30 +106,7 @@ func (me *imgapiSyncer) Run(stop chan struct{}) error {
 
 							continue nextItem
 						} else {
-							job := syncerDownloadJob{
-								manifest: manifest,
-								files:    make([]*url.URL, 0),
-							}
-
-							manifest.SyncInfo["time"] = time.Now().Format(time.RFC3339)
-							manifest.SyncInfo["from"] = me.source.Source
-							manifest.SyncInfo["type"] = me.source.Type
-
-							for file_idx, _ := range manifest.Files {
-								var file_url string
-
-								if file_idx > 0 {
-									file_url = fmt.Sprintf("/images/%s/file%d", manifest.Uuid, file_idx)
-								} else {
-									file_url = fmt.Sprintf("/images/%s/file", manifest.Uuid)
-								}
-
-								if u, err := url.Parse(file_url); err == nil {
-									job.files = append(job.files, me.base.ResolveReference(u))
-								}
-							}
-
-							me.queue <- &job
+							me.queue <- me.newJob(manifest)
 						}
 					}
 				} else {
@@ -140,3 +126,123 @@ func (me *imgapiSyncer) Run(stop chan struct{}) error {
 
 	return nil
 }
+
+func (me *imgapiSyncer) newJob(manifest *dsapid.ManifestResource) *syncerDownloadJob {
+	job := &syncerDownloadJob{
+		manifest: manifest,
+		files:    make([]*url.URL, 0),
+	}
+
+	manifest.SyncInfo["time"] = time.Now().Format(time.RFC3339)
+	manifest.SyncInfo["from"] = me.source.Source
+	manifest.SyncInfo["type"] = me.source.Type
+
+	for file_idx, _ := range manifest.Files {
+		var file_url string
+
+		if file_idx > 0 {
+			file_url = fmt.Sprintf("/images/%s/file%d", manifest.Uuid, file_idx)
+		} else {
+			file_url = fmt.Sprintf("/images/%s/file", manifest.Uuid)
+		}
+
+		if u, err := url.Parse(file_url); err == nil {
+			job.files = append(job.files, me.base.ResolveReference(u))
+		}
+	}
+
+	if manifest.Icon {
+		if u, err := url.Parse(fmt.Sprintf("/images/%s/icon", manifest.Uuid)); err == nil {
+			job.icon = me.base.ResolveReference(u)
+		}
+	}
+
+	return job
+}
+
+// fetchJob prepares the download of the single image id.
+func (me *imgapiSyncer) fetchJob(id string) (*syncerDownloadJob, error) {
+	u, err := url.Parse(fmt.Sprintf("/images/%s", id))
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := fetchManifest(me.client, me.base.ResolveReference(u).String(), me.decoder, true, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return me.newJob(manifest), nil
+}
+
+// fetchEntries lists the upstream images. With a page size configured the
+// list is requested page by page using the uuid of the last image as
+// marker. IMGAPI includes the marker image in the next page so images
+// already seen are skipped. Servers cap the page size, 1000 for IMGAPI and
+// dsapid, so a short page doesn't mean the end; that is reached once a page
+// brings no new image.
+func (me *imgapiSyncer) fetchEntries() ([]dsapid.Table, error) {
+	if me.source.PageSize <= 0 {
+		return me.fetchPage(me.source.Source)
+	}
+
+	u, err := url.Parse(me.source.Source)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []dsapid.Table
+	seen := make(map[string]bool)
+
+	query := u.Query()
+	query.Set("limit", strconv.Itoa(me.source.PageSize))
+
+	for {
+		u.RawQuery = query.Encode()
+
+		page, err := me.fetchPage(u.String())
+		if err != nil {
+			return entries, err
+		}
+
+		added := 0
+
+		for _, item := range page {
+			id, _ := item["uuid"].(string)
+
+			if seen[id] {
+				continue
+			}
+
+			seen[id] = true
+			entries = append(entries, item)
+			added++
+
+			query.Set("marker", id)
+		}
+
+		if added == 0 {
+			return entries, nil
+		}
+	}
+}
+
+func (me *imgapiSyncer) fetchPage(src string) ([]dsapid.Table, error) {
+	res, err := me.client.Get(src)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", src, res.Status)
+	}
+
+	var entries []dsapid.Table
+
+	if err := json.NewDecoder(res.Body).Decode(&entries); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
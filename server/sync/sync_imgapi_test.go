@@ -0,0 +1,59 @@
+package sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/MerlinDMC/dsapid"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestImgapiFetchEntriesBeyondServerPageLimit(t *testing.T) {
+	ids := make([]string, 0)
+
+	for i := 0; i < 7; i++ {
+		ids = append(ids, fmt.Sprintf("00000000-0000-0000-0000-%012d", i))
+	}
+
+	// the server caps the page size at 3 like IMGAPI caps it at 1000 and
+	// starts a page with the marker image
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		limit, _ := strconv.Atoi(req.URL.Query().Get("limit"))
+		if limit > 3 {
+			limit = 3
+		}
+
+		start := 0
+
+		for i, id := range ids {
+			if id == req.URL.Query().Get("marker") {
+				start = i
+			}
+		}
+
+		page := make([]dsapid.Table, 0)
+
+		for i := start; i < len(ids) && len(page) < limit; i++ {
+			page = append(page, dsapid.Table{"uuid": ids[i]})
+		}
+
+		json.NewEncoder(w).Encode(page)
+	}))
+	defer upstream.Close()
+
+	syncer := &imgapiSyncer{
+		source: &dsapid.SyncSourceResource{Source: upstream.URL + "/images", PageSize: 5},
+		client: http.DefaultClient,
+	}
+
+	entries, err := syncer.fetchEntries()
+	if err != nil {
+		t.Fatalf("fetching failed: %s", err)
+	}
+
+	if len(entries) != len(ids) {
+		t.Errorf("expected %d images but got %d", len(ids), len(entries))
+	}
+}
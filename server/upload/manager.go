@@ -0,0 +1,564 @@
+package upload
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/MerlinDMC/dsapid"
+	"github.com/MerlinDMC/dsapid/storage"
+	log "github.com/Sirupsen/logrus"
+	"github.com/pborman/uuid"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSessionFilename string = "session.json"
+	defaultDataFilename    string = "data"
+
+	expireInterval time.Duration = time.Minute
+)
+
+var (
+	ErrManagerAlreadyRunning error = errors.New("upload manager already running")
+	ErrSessionNotFound       error = errors.New("upload session not found")
+	ErrImageExists           error = errors.New("image already exists")
+	ErrUploadInProgress      error = errors.New("image is already being uploaded")
+	ErrNoFile                error = errors.New("manifest has no file")
+	ErrMultipleFiles         error = errors.New("resumable uploads carry exactly one file, use multipart/form-data")
+	ErrInvalidSize           error = errors.New("file size missing from manifest")
+	ErrInvalidRange          error = errors.New("range outside of the file")
+	ErrShortChunk            error = errors.New("chunk shorter than announced")
+	ErrIncomplete            error = errors.New("upload incomplete")
+	ErrChecksumMismatch      error = errors.New("checksum mismatch")
+)
+
+// Manager keeps upload sessions which receive the file of an image in
+// byte ranges written in any order. Checksums are computed as the
+// received data becomes contiguous and computed anew once a write replaces
+// data already hashed. Sessions untouched for longer than the expiry are
+// removed.
+//
+// A session receives a single file: the byte ranges, the checksum state and
+// the data file on disk all describe that one file. Images with several
+// files are uploaded as multipart/form-data instead.
+type Manager interface {
+	Run() error
+	Stop()
+	Create(*dsapid.ManifestResource, string) (Session, error)
+	Get(string) (Session, bool)
+	Write(string, int64, int64, io.Reader) (Session, error)
+	// Finalize stores the manifest with the uploaded file
+	Finalize(string) (*dsapid.ManifestResource, error)
+	Abort(string) error
+}
+
+type Session struct {
+	Uuid      string    `json:"uuid"`
+	ImageUuid string    `json:"image_uuid"`
+	Owner     string    `json:"owner"`
+	Size      int64     `json:"size"`
+	Bytes     int64     `json:"bytes_received"`
+	Received  Ranges    `json:"received"`
+	Missing   Ranges    `json:"missing"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// sessionState is persisted next to the data so sessions survive restarts.
+type sessionState struct {
+	Uuid      string                   `json:"uuid"`
+	Owner     string                   `json:"owner"`
+	Manifest  *dsapid.ManifestResource `json:"manifest"`
+	Received  Ranges                   `json:"received"`
+	Hashed    int64                    `json:"hashed"`
+	Md5       []byte                   `json:"md5_state,omitempty"`
+	Sha1      []byte                   `json:"sha1_state,omitempty"`
+	CreatedAt time.Time                `json:"created_at"`
+	UpdatedAt time.Time                `json:"updated_at"`
+}
+
+type session struct {
+	lock    sync.Mutex
+	state   sessionState
+	md5     hash.Hash
+	sha1    hash.Hash
+	removed bool
+}
+
+type manager struct {
+	manifests storage.ManifestStorage
+	dir       string
+	expiry    time.Duration
+
+	lock     sync.Mutex
+	sessions map[string]*session
+	s_stop   chan struct{}
+}
+
+// NewManager creates a Manager keeping its sessions below dir.
+func NewManager(manifests storage.ManifestStorage, dir string, expiry time.Duration) Manager {
+	return &manager{
+		manifests: manifests,
+		dir:       dir,
+		expiry:    expiry,
+		sessions:  make(map[string]*session),
+	}
+}
+
+func (me *manager) Run() error {
+	if me.s_stop != nil {
+		return ErrManagerAlreadyRunning
+	}
+
+	if err := os.MkdirAll(me.dir, 0770); err != nil {
+		return err
+	}
+
+	me.load()
+
+	me.s_stop = make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(expireInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-me.s_stop:
+				return
+			case <-ticker.C:
+				me.expire()
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (me *manager) Stop() {
+	if me.s_stop != nil {
+		close(me.s_stop)
+	}
+}
+
+func (me *manager) Create(manifest *dsapid.ManifestResource, owner string) (Session, error) {
+	if len(manifest.Files) == 0 {
+		return Session{}, ErrNoFile
+	}
+
+	if len(manifest.Files) > 1 {
+		return Session{}, ErrMultipleFiles
+	}
+
+	if manifest.Files[0].Size <= 0 {
+		return Session{}, ErrInvalidSize
+	}
+
+	if _, ok := me.manifests.GetOK(manifest.Uuid); ok {
+		return Session{}, ErrImageExists
+	}
+
+	s := &session{
+		state: sessionState{
+			Uuid:      uuid.New(),
+			Owner:     owner,
+			Manifest:  manifest,
+			Received:  make(Ranges, 0),
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		},
+		md5:  md5.New(),
+		sha1: sha1.New(),
+	}
+
+	me.lock.Lock()
+	defer me.lock.Unlock()
+
+	for _, cur := range me.sessions {
+		if cur.state.Manifest.Uuid == manifest.Uuid {
+			return Session{}, ErrUploadInProgress
+		}
+	}
+
+	if err := os.MkdirAll(me.sessionPath(s.state.Uuid), 0770); err != nil {
+		return Session{}, err
+	}
+
+	fout, err := os.OpenFile(me.dataPath(s.state.Uuid), os.O_CREATE|os.O_WRONLY, 0660)
+	if err == nil {
+		err = fout.Truncate(manifest.Files[0].Size)
+		fout.Close()
+	}
+
+	if err == nil {
+		err = me.save(s)
+	}
+
+	if err != nil {
+		os.RemoveAll(me.sessionPath(s.state.Uuid))
+
+		return Session{}, err
+	}
+
+	me.sessions[s.state.Uuid] = s
+
+	return me.status(s), nil
+}
+
+func (me *manager) Get(id string) (Session, bool) {
+	s, ok := me.get(id)
+	if !ok {
+		return Session{}, false
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return me.status(s), !s.removed
+}
+
+// Write stores length bytes read from r at offset. Bytes received before
+// a read error are kept so the client only has to resend the rest.
+func (me *manager) Write(id string, offset int64, length int64, r io.Reader) (Session, error) {
+	s, ok := me.get(id)
+	if !ok {
+		return Session{}, ErrSessionNotFound
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.removed {
+		return Session{}, ErrSessionNotFound
+	}
+
+	if offset < 0 || length <= 0 || offset+length > s.size() {
+		return me.status(s), ErrInvalidRange
+	}
+
+	// the checksums have to follow the data file so they start over once
+	// data already hashed is replaced
+	if offset < s.state.Hashed {
+		s.resetChecksums()
+	}
+
+	fout, err := os.OpenFile(me.dataPath(id), os.O_WRONLY, 0)
+	if err != nil {
+		return me.status(s), err
+	}
+
+	var n int64
+
+	if _, err = fout.Seek(offset, io.SeekStart); err == nil {
+		n, err = io.CopyN(fout, r, length)
+	}
+
+	if err == io.EOF {
+		err = ErrShortChunk
+	}
+
+	if cerr := fout.Close(); err == nil {
+		err = cerr
+	}
+
+	s.state.Received = s.state.Received.add(Range{Offset: offset, Length: n})
+	s.state.UpdatedAt = time.Now()
+
+	if herr := me.advance(s); err == nil {
+		err = herr
+	}
+
+	if serr := me.save(s); err == nil {
+		err = serr
+	}
+
+	return me.status(s), err
+}
+
+func (me *manager) Finalize(id string) (*dsapid.ManifestResource, error) {
+	s, ok := me.get(id)
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.removed {
+		return nil, ErrSessionNotFound
+	}
+
+	if len(s.state.Received.missing(s.size())) > 0 {
+		return nil, ErrIncomplete
+	}
+
+	if err := me.advance(s); err != nil {
+		return nil, err
+	}
+
+	// the session keeps the manifest as sent so a failed attempt can be
+	// retried
+	manifest := storage.CopyManifest(s.state.Manifest)
+	file := &manifest.Files[0]
+
+	md5_sum := hex.EncodeToString(s.md5.Sum(nil))
+	sha1_sum := hex.EncodeToString(s.sha1.Sum(nil))
+
+	// the session is kept so the client can send the broken ranges again
+	if (file.Md5 != "" && file.Md5 != md5_sum) || (file.Sha1 != "" && file.Sha1 != sha1_sum) {
+		log.WithFields(log.Fields{
+			"upload_uuid": id,
+			"image_uuid":  manifest.Uuid,
+			"file_path":   file.Path,
+		}).Warnf("checksum missmatch on uploaded file: got md5 %s sha1 %s", md5_sum, sha1_sum)
+
+		return nil, ErrChecksumMismatch
+	}
+
+	if _, ok := me.manifests.GetOK(manifest.Uuid); ok {
+		me.remove(s)
+
+		return nil, ErrImageExists
+	}
+
+	file.Md5 = md5_sum
+	file.Sha1 = sha1_sum
+
+	// nothing is published unless store succeeds, so there is nothing to
+	// undo on errors
+	if err := me.store(id, manifest); err == storage.ErrStorageItemExists {
+		me.remove(s)
+
+		return nil, ErrImageExists
+	} else if err != nil {
+		return nil, err
+	}
+
+	me.remove(s)
+
+	return manifest, nil
+}
+
+func (me *manager) Abort(id string) error {
+	s, ok := me.get(id)
+	if !ok {
+		return ErrSessionNotFound
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.removed {
+		return ErrSessionNotFound
+	}
+
+	me.remove(s)
+
+	return nil
+}
+
+func (me *manager) get(id string) (*session, bool) {
+	me.lock.Lock()
+	defer me.lock.Unlock()
+
+	s, ok := me.sessions[id]
+
+	return s, ok
+}
+
+// store copies the uploaded data into the manifest storage. It fails with
+// storage.ErrStorageItemExists if the image was added meanwhile.
+func (me *manager) store(id string, manifest *dsapid.ManifestResource) error {
+	fin, err := os.Open(me.dataPath(id))
+	if err != nil {
+		return err
+	}
+	defer fin.Close()
+
+	fout, err := me.manifests.CreateFile(manifest, &manifest.Files[0])
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(fout, fin); err != nil {
+		fout.Close()
+
+		return err
+	}
+
+	if err := fout.Close(); err != nil {
+		return err
+	}
+
+	manifest.PublishedAt = time.Now()
+
+	return me.manifests.Insert(manifest.Uuid, manifest)
+}
+
+// advance feeds the checksums with the data received contiguously after
+// what has been hashed so far.
+func (me *manager) advance(s *session) error {
+	end := s.state.Received.contiguous(s.state.Hashed)
+	if end <= s.state.Hashed {
+		return nil
+	}
+
+	fin, err := os.Open(me.dataPath(s.state.Uuid))
+	if err != nil {
+		return err
+	}
+	defer fin.Close()
+
+	n, err := io.Copy(io.MultiWriter(s.md5, s.sha1), io.NewSectionReader(fin, s.state.Hashed, end-s.state.Hashed))
+	s.state.Hashed += n
+
+	return err
+}
+
+func (me *manager) remove(s *session) {
+	s.removed = true
+
+	me.lock.Lock()
+	delete(me.sessions, s.state.Uuid)
+	me.lock.Unlock()
+
+	if err := os.RemoveAll(me.sessionPath(s.state.Uuid)); err != nil {
+		log.WithFields(log.Fields{
+			"upload_uuid": s.state.Uuid,
+		}).Errorf("can't remove upload session: %s", err)
+	}
+}
+
+func (me *manager) expire() {
+	me.lock.Lock()
+	sessions := make([]*session, 0, len(me.sessions))
+	for _, s := range me.sessions {
+		sessions = append(sessions, s)
+	}
+	me.lock.Unlock()
+
+	deadline := time.Now().Add(-me.expiry)
+
+	for _, s := range sessions {
+		s.lock.Lock()
+
+		if !s.removed && s.state.UpdatedAt.Before(deadline) {
+			log.WithFields(log.Fields{
+				"upload_uuid": s.state.Uuid,
+				"image_uuid":  s.state.Manifest.Uuid,
+			}).Info("expiring upload session")
+
+			me.remove(s)
+		}
+
+		s.lock.Unlock()
+	}
+}
+
+func (me *manager) save(s *session) error {
+	if data, err := s.md5.(encoding.BinaryMarshaler).MarshalBinary(); err == nil {
+		s.state.Md5 = data
+	}
+
+	if data, err := s.sha1.(encoding.BinaryMarshaler).MarshalBinary(); err == nil {
+		s.state.Sha1 = data
+	}
+
+	data, err := json.Marshal(s.state)
+	if err != nil {
+		return err
+	}
+
+	filename := path.Join(me.sessionPath(s.state.Uuid), defaultSessionFilename)
+
+	if err := ioutil.WriteFile(filename+".tmp", data, 0660); err != nil {
+		return err
+	}
+
+	return os.Rename(filename+".tmp", filename)
+}
+
+// load restores the sessions left over by a previous run.
+func (me *manager) load() {
+	items, err := ioutil.ReadDir(me.dir)
+	if err != nil {
+		return
+	}
+
+	me.lock.Lock()
+	defer me.lock.Unlock()
+
+	for _, item := range items {
+		if !item.IsDir() || uuid.Parse(item.Name()) == nil {
+			continue
+		}
+
+		s := &session{
+			md5:  md5.New(),
+			sha1: sha1.New(),
+		}
+
+		data, err := ioutil.ReadFile(path.Join(me.dir, item.Name(), defaultSessionFilename))
+		if err == nil {
+			err = json.Unmarshal(data, &s.state)
+		}
+
+		if err != nil || s.state.Uuid != item.Name() || s.state.Manifest == nil || len(s.state.Manifest.Files) == 0 {
+			log.WithFields(log.Fields{
+				"upload_uuid": item.Name(),
+			}).Warn("removing broken upload session")
+
+			os.RemoveAll(path.Join(me.dir, item.Name()))
+
+			continue
+		}
+
+		// rehash from the start if the checksum state can't be restored
+		if s.md5.(encoding.BinaryUnmarshaler).UnmarshalBinary(s.state.Md5) != nil ||
+			s.sha1.(encoding.BinaryUnmarshaler).UnmarshalBinary(s.state.Sha1) != nil {
+			s.resetChecksums()
+		}
+
+		me.sessions[s.state.Uuid] = s
+	}
+}
+
+func (me *manager) status(s *session) Session {
+	return Session{
+		Uuid:      s.state.Uuid,
+		ImageUuid: s.state.Manifest.Uuid,
+		Owner:     s.state.Owner,
+		Size:      s.size(),
+		Bytes:     s.state.Received.received(),
+		Received:  s.state.Received,
+		Missing:   s.state.Received.missing(s.size()),
+		CreatedAt: s.state.CreatedAt,
+		ExpiresAt: s.state.UpdatedAt.Add(me.expiry),
+	}
+}
+
+func (me *manager) sessionPath(id string) string {
+	return path.Join(me.dir, id)
+}
+
+func (me *manager) dataPath(id string) string {
+	return path.Join(me.sessionPath(id), defaultDataFilename)
+}
+
+func (me *session) size() int64 {
+	return me.state.Manifest.Files[0].Size
+}
+
+func (me *session) resetChecksums() {
+	me.md5, me.sha1 = md5.New(), sha1.New()
+	me.state.Hashed = 0
+}
@@ -0,0 +1,273 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/MerlinDMC/dsapid"
+	"github.com/MerlinDMC/dsapid/storage"
+	"github.com/pborman/uuid"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testData = "0123456789abcdefghij"
+
+func newTestManager(t *testing.T) (*manager, storage.ManifestStorage, func()) {
+	basedir, err := ioutil.TempDir("", "dsapid-upload")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %s", err)
+	}
+
+	manifests := storage.NewManifestStorage(path.Join(basedir, "manifests"), nil)
+
+	m := NewManager(manifests, path.Join(basedir, "uploads"), time.Hour).(*manager)
+	if err := m.Run(); err != nil {
+		t.Fatalf("can't run manager: %s", err)
+	}
+
+	return m, manifests, func() {
+		m.Stop()
+		os.RemoveAll(basedir)
+	}
+}
+
+func newTestUploadManifest(sha1_sum string) *dsapid.ManifestResource {
+	return &dsapid.ManifestResource{
+		Uuid:    uuid.New(),
+		Name:    "upload",
+		Version: "1.0.0",
+		State:   dsapid.ManifestStateActive,
+		Type:    dsapid.ManifestTypeZone,
+		Files: []dsapid.ManifestFileResource{{
+			Path: "upload.zfs",
+			Size: int64(len(testData)),
+			Sha1: sha1_sum,
+		}},
+	}
+}
+
+func testDataSha1() string {
+	sum := sha1.Sum([]byte(testData))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func writeChunk(t *testing.T, m Manager, id string, offset int64, data string) Session {
+	s, err := m.Write(id, offset, int64(len(data)), strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("writing %d bytes at %d failed: %s", len(data), offset, err)
+	}
+
+	return s
+}
+
+func checkStoredFile(t *testing.T, manifests storage.ManifestStorage, manifest *dsapid.ManifestResource) {
+	if manifest.Files[0].Sha1 != testDataSha1() {
+		t.Errorf("expected sha1 %s but got %s", testDataSha1(), manifest.Files[0].Sha1)
+	}
+
+	r, err := manifests.OpenFile(manifest, &manifest.Files[0])
+	if err != nil {
+		t.Fatalf("can't open stored file: %s", err)
+	}
+	defer r.Close()
+
+	if data, _ := ioutil.ReadAll(r); !bytes.Equal(data, []byte(testData)) {
+		t.Errorf("stored file holds %q", data)
+	}
+}
+
+func TestManagerOutOfOrderChunks(t *testing.T) {
+	m, manifests, cleanup := newTestManager(t)
+	defer cleanup()
+
+	s, err := m.Create(newTestUploadManifest(testDataSha1()), "")
+	if err != nil {
+		t.Fatalf("can't create session: %s", err)
+	}
+
+	writeChunk(t, m, s.Uuid, 15, testData[15:])
+	s = writeChunk(t, m, s.Uuid, 5, testData[5:10])
+
+	if len(s.Missing) != 2 || s.Bytes != 10 {
+		t.Errorf("expected 2 gaps and 10 bytes but got %v and %d", s.Missing, s.Bytes)
+	}
+
+	if _, err := m.Finalize(s.Uuid); err != ErrIncomplete {
+		t.Errorf("expected ErrIncomplete but got %v", err)
+	}
+
+	writeChunk(t, m, s.Uuid, 10, testData[10:15])
+	writeChunk(t, m, s.Uuid, 0, testData[:5])
+
+	manifest, err := m.Finalize(s.Uuid)
+	if err != nil {
+		t.Fatalf("finalize failed: %s", err)
+	}
+
+	checkStoredFile(t, manifests, manifest)
+
+	if _, ok := m.Get(s.Uuid); ok {
+		t.Error("finalized session should be gone")
+	}
+}
+
+func TestManagerOverwriteRehashes(t *testing.T) {
+	m, manifests, cleanup := newTestManager(t)
+	defer cleanup()
+
+	s, err := m.Create(newTestUploadManifest(""), "")
+	if err != nil {
+		t.Fatalf("can't create session: %s", err)
+	}
+
+	// the first attempt is hashed before it is replaced by the resend
+	writeChunk(t, m, s.Uuid, 0, strings.Repeat("x", len(testData)))
+	writeChunk(t, m, s.Uuid, 5, testData[5:])
+	writeChunk(t, m, s.Uuid, 0, testData[:5])
+
+	manifest, err := m.Finalize(s.Uuid)
+	if err != nil {
+		t.Fatalf("finalize failed: %s", err)
+	}
+
+	checkStoredFile(t, manifests, manifest)
+}
+
+func TestManagerResumesAfterRestart(t *testing.T) {
+	m, manifests, cleanup := newTestManager(t)
+	defer cleanup()
+
+	s, err := m.Create(newTestUploadManifest(testDataSha1()), "")
+	if err != nil {
+		t.Fatalf("can't create session: %s", err)
+	}
+
+	writeChunk(t, m, s.Uuid, 0, testData[:8])
+
+	// a new manager picks up the sessions on disk like after a restart
+	restarted := NewManager(manifests, m.dir, time.Hour)
+	if err := restarted.Run(); err != nil {
+		t.Fatalf("can't run manager: %s", err)
+	}
+	defer restarted.Stop()
+
+	if resumed, ok := restarted.Get(s.Uuid); !ok || resumed.Bytes != 8 {
+		t.Fatalf("session should be restored with 8 bytes but got %v", resumed)
+	}
+
+	writeChunk(t, restarted, s.Uuid, 8, testData[8:])
+
+	manifest, err := restarted.Finalize(s.Uuid)
+	if err != nil {
+		t.Fatalf("finalize failed: %s", err)
+	}
+
+	checkStoredFile(t, manifests, manifest)
+}
+
+func TestManagerExpiresSessions(t *testing.T) {
+	m, _, cleanup := newTestManager(t)
+	defer cleanup()
+
+	s, err := m.Create(newTestUploadManifest(""), "")
+	if err != nil {
+		t.Fatalf("can't create session: %s", err)
+	}
+
+	m.expire()
+
+	if _, ok := m.Get(s.Uuid); !ok {
+		t.Fatal("active session should not expire")
+	}
+
+	m.expiry = 0
+	m.expire()
+
+	if _, ok := m.Get(s.Uuid); ok {
+		t.Error("idle session should have expired")
+	}
+
+	if _, err := os.Stat(m.sessionPath(s.Uuid)); !os.IsNotExist(err) {
+		t.Error("expired session should be removed from disk")
+	}
+}
+
+func TestManagerRejectsMultipleFiles(t *testing.T) {
+	m, _, cleanup := newTestManager(t)
+	defer cleanup()
+
+	manifest := newTestUploadManifest("")
+	manifest.Files = append(manifest.Files, dsapid.ManifestFileResource{Path: "data.zfs", Size: 10})
+
+	if _, err := m.Create(manifest, ""); err != ErrMultipleFiles {
+		t.Errorf("expected ErrMultipleFiles but got %v", err)
+	}
+}
+
+func TestManagerKeepsSessionOnChecksumMismatch(t *testing.T) {
+	m, manifests, cleanup := newTestManager(t)
+	defer cleanup()
+
+	s, err := m.Create(newTestUploadManifest(testDataSha1()), "")
+	if err != nil {
+		t.Fatalf("can't create session: %s", err)
+	}
+
+	writeChunk(t, m, s.Uuid, 0, testData[:10])
+	writeChunk(t, m, s.Uuid, 10, "xxxxxxxxxx")
+
+	if _, err := m.Finalize(s.Uuid); err != ErrChecksumMismatch {
+		t.Fatalf("expected ErrChecksumMismatch but got %v", err)
+	}
+
+	if _, ok := m.Get(s.Uuid); !ok {
+		t.Fatal("session should be kept after a checksum mismatch")
+	}
+
+	// resending the broken range completes the upload
+	writeChunk(t, m, s.Uuid, 10, testData[10:])
+
+	manifest, err := m.Finalize(s.Uuid)
+	if err != nil {
+		t.Fatalf("finalize failed: %s", err)
+	}
+
+	checkStoredFile(t, manifests, manifest)
+}
+
+func TestManagerStoreKeepsConcurrentImage(t *testing.T) {
+	m, manifests, cleanup := newTestManager(t)
+	defer cleanup()
+
+	manifest := newTestUploadManifest(testDataSha1())
+
+	s, err := m.Create(manifest, "")
+	if err != nil {
+		t.Fatalf("can't create session: %s", err)
+	}
+
+	writeChunk(t, m, s.Uuid, 0, testData)
+
+	// another upload publishes the image between the check and the store
+	other := storage.CopyManifest(manifest)
+	other.Name = "other"
+	other.Files = nil
+
+	if err := manifests.Add(other.Uuid, other); err != nil {
+		t.Fatalf("can't add manifest: %s", err)
+	}
+
+	if err := m.store(s.Uuid, storage.CopyManifest(manifest)); err != storage.ErrStorageItemExists {
+		t.Errorf("expected ErrStorageItemExists but got %v", err)
+	}
+
+	if stored, ok := manifests.GetOK(manifest.Uuid); !ok || stored.Name != "other" {
+		t.Error("the image published by the other upload should be kept")
+	}
+}
@@ -0,0 +1,93 @@
+package upload
+
+import (
+	"sort"
+)
+
+// Range is a span of bytes received for an upload.
+type Range struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+func (me Range) end() int64 {
+	return me.Offset + me.Length
+}
+
+// Ranges is kept sorted by offset without overlapping or adjacent spans.
+type Ranges []Range
+
+// add merges r into the ranges.
+func (me Ranges) add(r Range) Ranges {
+	if r.Length <= 0 {
+		return me
+	}
+
+	merged := make(Ranges, 0, len(me)+1)
+
+	for _, cur := range me {
+		if cur.end() < r.Offset || r.end() < cur.Offset {
+			merged = append(merged, cur)
+			continue
+		}
+
+		start, end := cur.Offset, cur.end()
+		if r.Offset < start {
+			start = r.Offset
+		}
+		if r.end() > end {
+			end = r.end()
+		}
+
+		r = Range{Offset: start, Length: end - start}
+	}
+
+	merged = append(merged, r)
+
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].Offset < merged[j].Offset
+	})
+
+	return merged
+}
+
+// received returns the number of bytes covered.
+func (me Ranges) received() (n int64) {
+	for _, r := range me {
+		n += r.Length
+	}
+
+	return n
+}
+
+// contiguous returns the end of the range starting at or covering offset.
+func (me Ranges) contiguous(offset int64) int64 {
+	for _, r := range me {
+		if r.Offset <= offset && offset < r.end() {
+			return r.end()
+		}
+	}
+
+	return offset
+}
+
+// missing returns the gaps between 0 and size.
+func (me Ranges) missing(size int64) Ranges {
+	gaps := make(Ranges, 0)
+
+	var offset int64
+
+	for _, r := range me {
+		if r.Offset > offset {
+			gaps = append(gaps, Range{Offset: offset, Length: r.Offset - offset})
+		}
+
+		offset = r.end()
+	}
+
+	if offset < size {
+		gaps = append(gaps, Range{Offset: offset, Length: size - offset})
+	}
+
+	return gaps
+}
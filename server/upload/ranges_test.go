@@ -0,0 +1,67 @@
+package upload
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRangesAdd(t *testing.T) {
+	var r Ranges
+
+	r = r.add(Range{Offset: 20, Length: 10})
+	r = r.add(Range{Offset: 0, Length: 5})
+	r = r.add(Range{Offset: 40, Length: 0})
+
+	if expected := (Ranges{{0, 5}, {20, 10}}); !reflect.DeepEqual(r, expected) {
+		t.Errorf("expected %v but got %v", expected, r)
+	}
+
+	// adjacent and overlapping spans are merged
+	r = r.add(Range{Offset: 5, Length: 3})
+	r = r.add(Range{Offset: 25, Length: 10})
+
+	if expected := (Ranges{{0, 8}, {20, 15}}); !reflect.DeepEqual(r, expected) {
+		t.Errorf("expected %v but got %v", expected, r)
+	}
+
+	// a span bridging the gap joins both sides
+	r = r.add(Range{Offset: 6, Length: 16})
+
+	if expected := (Ranges{{0, 35}}); !reflect.DeepEqual(r, expected) {
+		t.Errorf("expected %v but got %v", expected, r)
+	}
+
+	if n := r.received(); n != 35 {
+		t.Errorf("expected 35 bytes but got %d", n)
+	}
+}
+
+func TestRangesMissing(t *testing.T) {
+	r := Ranges{{5, 5}, {20, 10}}
+
+	if expected, missing := (Ranges{{0, 5}, {10, 10}, {30, 10}}), r.missing(40); !reflect.DeepEqual(missing, expected) {
+		t.Errorf("expected %v but got %v", expected, missing)
+	}
+
+	if missing := r.missing(30); len(missing) != 2 {
+		t.Errorf("expected 2 gaps but got %v", missing)
+	}
+
+	if missing := (Ranges{{0, 30}}).missing(30); len(missing) != 0 {
+		t.Errorf("expected no gaps but got %v", missing)
+	}
+
+	if expected, missing := (Ranges{{0, 30}}), (Ranges{}).missing(30); !reflect.DeepEqual(missing, expected) {
+		t.Errorf("expected %v but got %v", expected, missing)
+	}
+}
+
+func TestRangesContiguous(t *testing.T) {
+	r := Ranges{{0, 10}, {20, 10}}
+
+	for offset, expected := range map[int64]int64{0: 10, 5: 10, 10: 10, 15: 15, 20: 30, 29: 30, 30: 30} {
+		if end := r.contiguous(offset); end != expected {
+			t.Errorf("contiguous(%d) should be %d but is %d", offset, expected, end)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// writeFileAtomic replaces filename with data so that readers either see the
+// old or the new content but never a truncated file. The data is written to a
+// temp file in the same directory, synced to disk and renamed into place.
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) (err error) {
+	dir := path.Dir(filename)
+
+	tmp, err := ioutil.TempFile(dir, "."+path.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmp.Name(), filename); err != nil {
+		return err
+	}
+
+	// persist the rename itself
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,252 @@
+package storage
+
+import (
+	"encoding/hex"
+	"github.com/MerlinDMC/dsapid"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBlobDirname string = ".blobs"
+)
+
+// BlobBackend stores image files content-addressed by key.
+type BlobBackend interface {
+	Open(string) (BlobReader, error)
+	// Put moves the local file into the backend under key
+	Put(string, string) error
+	Exists(string) bool
+	Remove(string) error
+	// Walk calls fn with the key and size of every stored blob
+	Walk(fn func(key string, size int64) error) error
+}
+
+type BlobReader interface {
+	io.ReadSeeker
+	io.Closer
+	Size() int64
+	ModTime() time.Time
+}
+
+// manifestFiles implements the file handling shared by every ManifestStorage.
+// New files are written below basedir and moved into the blob backend on Add
+// keyed by their sha1 so identical files uploaded or synced under different
+// manifests are stored only once. Files of manifests stored before the blob
+// backend existed are still read from basedir.
+type manifestFiles struct {
+	basedir string
+	blobs   *blobStore
+}
+
+// blobStore tracks which keys are handed to the backend. The number of
+// manifests referencing a blob is kept by the manifest index; a blob is
+// removed once that count drops to zero.
+type blobStore struct {
+	backend BlobBackend
+
+	// lock serializes the writers of a storage and Reload so a blob that
+	// is about to be referenced again is never removed underneath and no
+	// write gets lost by a concurrent reload
+	lock sync.Mutex
+}
+
+func newManifestFiles(basedir string, backend BlobBackend) manifestFiles {
+	if backend == nil {
+		backend = NewLocalBlobBackend(path.Join(basedir, defaultBlobDirname))
+	}
+
+	return manifestFiles{
+		basedir: basedir,
+		blobs: &blobStore{
+			backend: backend,
+		},
+	}
+}
+
+func (me *manifestFiles) ManifestPath(manifest *dsapid.ManifestResource) string {
+	return path.Join(me.basedir, manifest.Uuid)
+}
+
+func (me *manifestFiles) OpenFile(manifest *dsapid.ManifestResource, file *dsapid.ManifestFileResource) (BlobReader, error) {
+	if key, ok := blobKey(file.Sha1); ok {
+		// errors other than a missing blob must not look like one
+		if fin, err := me.blobs.backend.Open(key); err == nil || !IsFileNotFound(err) {
+			return fin, err
+		}
+	}
+
+	return openLocalBlob(me.uploadPath(manifest, file))
+}
+
+// IsFileNotFound reports whether err means a file or blob doesn't exist.
+func IsFileNotFound(err error) bool {
+	return err == ErrStorageFileNotFound || os.IsNotExist(err)
+}
+
+func (me *manifestFiles) CreateFile(manifest *dsapid.ManifestResource, file *dsapid.ManifestFileResource) (io.WriteCloser, error) {
+	if err := os.MkdirAll(me.ManifestPath(manifest), 0770); err != nil {
+		return nil, err
+	}
+
+	return os.Create(me.uploadPath(manifest, file))
+}
+
+// fileComplete reports whether file is stored with the size recorded in the
+// manifest.
+func (me *manifestFiles) fileComplete(manifest *dsapid.ManifestResource, file *dsapid.ManifestFileResource) bool {
+	fin, err := me.OpenFile(manifest, file)
+	if err != nil {
+		return false
+	}
+	defer fin.Close()
+
+	return file.Size == 0 || fin.Size() == file.Size
+}
+
+func (me *manifestFiles) uploadPath(manifest *dsapid.ManifestResource, file *dsapid.ManifestFileResource) string {
+	return path.Join(me.basedir, manifest.Uuid, path.Base(file.Path))
+}
+
+// ingest moves the uploaded files of manifest into the blob backend. A file
+// already stored under the same key is dropped instead. Callers must hold
+// blobs.lock.
+func (me *manifestFiles) ingest(manifest *dsapid.ManifestResource) error {
+	for i := range manifest.Files {
+		key, ok := blobKey(manifest.Files[i].Sha1)
+		if !ok {
+			continue
+		}
+
+		filename := me.uploadPath(manifest, &manifest.Files[i])
+
+		if _, err := os.Stat(filename); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return err
+		}
+
+		if me.blobs.backend.Exists(key) {
+			if err := os.Remove(filename); err != nil {
+				return err
+			}
+		} else if err := me.blobs.backend.Put(key, filename); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Release removes the blobs of sums which are no longer referenced.
+func (me *blobStore) Release(sums []string) {
+	for _, sum := range sums {
+		if key, ok := blobKey(sum); ok {
+			me.backend.Remove(key)
+		}
+	}
+}
+
+func blobKey(sum string) (string, bool) {
+	if b, err := hex.DecodeString(sum); err != nil || len(b) != 20 {
+		return "", false
+	}
+
+	return path.Join("sha1", sum[:2], sum), true
+}
+
+type localBlobBackend struct {
+	basedir string
+}
+
+type localBlobReader struct {
+	*os.File
+
+	info os.FileInfo
+}
+
+func NewLocalBlobBackend(basedir string) BlobBackend {
+	return &localBlobBackend{
+		basedir: basedir,
+	}
+}
+
+func (me *localBlobBackend) Open(key string) (BlobReader, error) {
+	return openLocalBlob(path.Join(me.basedir, key))
+}
+
+func (me *localBlobBackend) Put(key string, filename string) error {
+	blobname := path.Join(me.basedir, key)
+
+	if err := os.MkdirAll(path.Dir(blobname), 0770); err != nil {
+		return err
+	}
+
+	return os.Rename(filename, blobname)
+}
+
+func (me *localBlobBackend) Exists(key string) bool {
+	_, err := os.Stat(path.Join(me.basedir, key))
+
+	return err == nil
+}
+
+func (me *localBlobBackend) Remove(key string) error {
+	if err := os.Remove(path.Join(me.basedir, key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (me *localBlobBackend) Walk(fn func(key string, size int64) error) error {
+	err := filepath.Walk(me.basedir, func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.Mode().IsRegular() {
+			key, _ := filepath.Rel(me.basedir, filename)
+
+			return fn(filepath.ToSlash(key), info.Size())
+		}
+
+		return nil
+	})
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+func openLocalBlob(filename string) (BlobReader, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+
+		return nil, err
+	}
+
+	return &localBlobReader{f, info}, nil
+}
+
+func (me *localBlobReader) Size() int64 {
+	return me.info.Size()
+}
+
+func (me *localBlobReader) ModTime() time.Time {
+	return me.info.ModTime()
+}
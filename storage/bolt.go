@@ -0,0 +1,39 @@
+package storage
+
+import (
+	bolt "go.etcd.io/bbolt"
+	"time"
+)
+
+var (
+	boltBucketManifests = []byte("manifests")
+	boltBucketUsers     = []byte("users")
+	boltBucketRevisions = []byte("revisions")
+)
+
+// OpenBoltDB opens (or creates) the database file shared by the bolt
+// manifest and user storages.
+func OpenBoltDB(filename string) (*bolt.DB, error) {
+	db, err := bolt.Open(filename, 0660, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltBucketManifests, boltBucketUsers, boltBucketRevisions} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		db.Close()
+
+		return nil, err
+	}
+
+	return db, nil
+}
@@ -0,0 +1,297 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/MerlinDMC/dsapid"
+	bolt "go.etcd.io/bbolt"
+	"os"
+	"path"
+	"strconv"
+	"time"
+)
+
+// boltManifestStorage keeps the manifests inside a bolt database while the
+// image files are handled the same way as by the filesystem storage.
+type boltManifestStorage struct {
+	manifestCache
+	manifestFiles
+
+	db *bolt.DB
+}
+
+func NewBoltManifestStorage(db *bolt.DB, basedir string, backend BlobBackend) (ManifestStorage, error) {
+	storage := new(boltManifestStorage)
+
+	storage.db = db
+	storage.manifestFiles = newManifestFiles(basedir, backend)
+
+	index, err := storage.load()
+	if err != nil {
+		return nil, err
+	}
+
+	storage.swap(index)
+
+	return storage, nil
+}
+
+func (me *boltManifestStorage) Add(id string, manifest *dsapid.ManifestResource) error {
+	return me.add(id, manifest, true)
+}
+
+func (me *boltManifestStorage) Insert(id string, manifest *dsapid.ManifestResource) error {
+	return me.add(id, manifest, false)
+}
+
+func (me *boltManifestStorage) add(id string, manifest *dsapid.ManifestResource, replace bool) error {
+	if err := os.MkdirAll(path.Join(me.basedir, id), 0770); err != nil {
+		return err
+	}
+
+	me.blobs.lock.Lock()
+	defer me.blobs.lock.Unlock()
+
+	if _, ok := me.GetOK(id); ok && !replace {
+		return ErrStorageItemExists
+	}
+
+	if err := me.ingest(manifest); err != nil {
+		return err
+	}
+
+	manifest.UpdatedAt = time.Now()
+
+	if err := me.save(id, manifest); err != nil {
+		return err
+	}
+
+	me.blobs.Release(me.put(id, manifest))
+
+	return nil
+}
+
+func (me *boltManifestStorage) Update(id string, manifest *dsapid.ManifestResource, author RevisionAuthor) error {
+	me.blobs.lock.Lock()
+	defer me.blobs.lock.Unlock()
+
+	if err := me.ingest(manifest); err != nil {
+		return err
+	}
+
+	manifest.UpdatedAt = time.Now()
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	err = me.db.Update(func(tx *bolt.Tx) error {
+		revisions := tx.Bucket(boltBucketRevisions)
+		last := lastBoltRevision(revisions, id)
+
+		if last == 0 {
+			// keep the state from before the first update
+			if current := tx.Bucket(boltBucketManifests).Get([]byte(id)); current != nil {
+				if base, err := baseRevision(current); err == nil {
+					if err := putBoltRevision(revisions, id, base); err != nil {
+						return err
+					}
+
+					last = base.Revision
+				}
+			}
+		}
+
+		if err := tx.Bucket(boltBucketManifests).Put([]byte(id), data); err != nil {
+			return err
+		}
+
+		return putBoltRevision(revisions, id, &ManifestRevision{
+			Revision:  last + 1,
+			CreatedAt: manifest.UpdatedAt,
+			Author:    author,
+			Manifest:  manifest,
+		})
+	})
+
+	if err != nil {
+		return err
+	}
+
+	me.blobs.Release(me.put(id, manifest))
+
+	return nil
+}
+
+func (me *boltManifestStorage) Delete(id string) {
+	me.blobs.lock.Lock()
+	defer me.blobs.lock.Unlock()
+
+	me.db.Update(func(tx *bolt.Tx) error {
+		prefix := boltRevisionPrefix(id)
+		c := tx.Bucket(boltBucketRevisions).Cursor()
+
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+
+		return tx.Bucket(boltBucketManifests).Delete([]byte(id))
+	})
+
+	os.RemoveAll(path.Join(me.basedir, id))
+
+	me.blobs.Release(me.remove(id))
+}
+
+func (me *boltManifestStorage) Revisions(id string) ([]*ManifestRevision, error) {
+	revisions := make([]*ManifestRevision, 0)
+
+	err := me.db.View(func(tx *bolt.Tx) error {
+		prefix := boltRevisionPrefix(id)
+		c := tx.Bucket(boltBucketRevisions).Cursor()
+
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			revision := new(ManifestRevision)
+
+			if err := json.Unmarshal(v, revision); err != nil {
+				return err
+			}
+
+			revisions = append(revisions, revision)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+func (me *boltManifestStorage) Revision(id string, n int) (*ManifestRevision, error) {
+	revision := new(ManifestRevision)
+
+	err := me.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltBucketRevisions).Get(boltRevisionKey(id, n))
+		if data == nil {
+			return ErrStorageItemNotFound
+		}
+
+		return json.Unmarshal(data, revision)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return revision, nil
+}
+
+func (me *boltManifestStorage) importRevisions(id string, revisions []*ManifestRevision) error {
+	return me.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucketRevisions)
+
+		for _, revision := range revisions {
+			if err := putBoltRevision(bucket, id, revision); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (me *boltManifestStorage) Reload() error {
+	me.blobs.lock.Lock()
+	defer me.blobs.lock.Unlock()
+
+	index, err := me.load()
+	if err != nil {
+		return err
+	}
+
+	me.swap(index)
+
+	return nil
+}
+
+func (me *boltManifestStorage) save(id string, manifest *dsapid.ManifestResource) error {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	return me.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucketManifests).Put([]byte(id), data)
+	})
+}
+
+func (me *boltManifestStorage) load() (*manifestIndex, error) {
+	index := newManifestIndex()
+
+	err := me.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucketManifests).ForEach(func(k, v []byte) error {
+			manifest := new(dsapid.ManifestResource)
+
+			if err := json.Unmarshal(v, manifest); err == nil && manifest.Uuid == string(k) {
+				index.insert(manifest)
+			}
+
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	index.sort()
+
+	return index, nil
+}
+
+// revisions are keyed by "<uuid>/<zero padded number>" so a cursor walks
+// them in order.
+func boltRevisionPrefix(id string) []byte {
+	return []byte(id + "/")
+}
+
+func boltRevisionKey(id string, n int) []byte {
+	return []byte(fmt.Sprintf("%s/%010d", id, n))
+}
+
+func lastBoltRevision(bucket *bolt.Bucket, id string) int {
+	prefix := boltRevisionPrefix(id)
+	c := bucket.Cursor()
+
+	// "0" sorts right after "/" so seeking it passes all revisions of id
+	k, _ := c.Seek([]byte(id + "0"))
+	if k == nil {
+		k, _ = c.Last()
+	} else {
+		k, _ = c.Prev()
+	}
+
+	if k == nil || !bytes.HasPrefix(k, prefix) {
+		return 0
+	}
+
+	n, _ := strconv.Atoi(string(k[len(prefix):]))
+
+	return n
+}
+
+func putBoltRevision(bucket *bolt.Bucket, id string, revision *ManifestRevision) error {
+	data, err := json.Marshal(revision)
+	if err != nil {
+		return err
+	}
+
+	return bucket.Put(boltRevisionKey(id, revision.Revision), data)
+}
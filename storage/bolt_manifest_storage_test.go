@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"github.com/MerlinDMC/dsapid"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestBoltStorageMigrateAndReopen(t *testing.T) {
+	fs_storage, basedir := newTestManifestStorage(t)
+	defer os.RemoveAll(basedir)
+
+	m1 := newTestManifest("base", time.Now().Add(-time.Hour))
+	m2 := newTestManifest("base64", time.Now())
+
+	fs_storage.Add(m1.Uuid, m1)
+	fs_storage.Add(m2.Uuid, m2)
+
+	dbdir, err := ioutil.TempDir("", "dsapid-bolt")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dbdir)
+
+	db, err := OpenBoltDB(path.Join(dbdir, "dsapid.db"))
+	if err != nil {
+		t.Fatalf("can't open database: %s", err)
+	}
+
+	manifests, err := NewBoltManifestStorage(db, basedir, nil)
+	if err != nil {
+		t.Fatalf("can't open manifest storage: %s", err)
+	}
+
+	if count, err := MigrateManifests(manifests, fs_storage); err != nil || count != 2 {
+		t.Fatalf("should migrate 2 manifests but got %d (%v)", count, err)
+	}
+
+	users, err := NewBoltUserStorage(db)
+	if err != nil {
+		t.Fatalf("can't open user storage: %s", err)
+	}
+
+	users.Add("test1", &dsapid.UserResource{Uuid: "test1", Name: "test1", Token: "test1token"})
+	users.Add("test2", &dsapid.UserResource{Uuid: "test2", Name: "test2"})
+	users.Delete("test2")
+
+	m1.State = dsapid.ManifestStateDisabled
+	manifests.Update(m1.Uuid, m1, RevisionAuthor{})
+	manifests.Delete(m2.Uuid)
+
+	db.Close()
+
+	if db, err = OpenBoltDB(path.Join(dbdir, "dsapid.db")); err != nil {
+		t.Fatalf("can't reopen database: %s", err)
+	}
+	defer db.Close()
+
+	if manifests, err = NewBoltManifestStorage(db, basedir, nil); err != nil {
+		t.Fatalf("can't reopen manifest storage: %s", err)
+	}
+
+	if m, ok := manifests.GetOK(m1.Uuid); !ok || m.State != dsapid.ManifestStateDisabled {
+		t.Errorf("should have persisted update of %s", m1.Uuid)
+	}
+
+	if _, ok := manifests.GetOK(m2.Uuid); ok {
+		t.Errorf("should have persisted delete of %s", m2.Uuid)
+	}
+
+	if users, err = NewBoltUserStorage(db); err != nil {
+		t.Fatalf("can't reopen user storage: %s", err)
+	}
+
+	if u, err := users.FindByToken("test1token"); err != nil || u.Uuid != "test1" {
+		t.Errorf("should find user test1 by token but got %v", err)
+	}
+
+	if _, ok := users.GetOK("test2"); ok {
+		t.Error("should have persisted delete of user test2")
+	}
+}
+
+func TestMigrateManifestsKeepsRevisions(t *testing.T) {
+	fs_storage, basedir := newTestManifestStorage(t)
+	defer os.RemoveAll(basedir)
+
+	m := newTestManifest("base", time.Now())
+	fs_storage.Add(m.Uuid, m)
+
+	changed := CopyManifest(m)
+	changed.Description = "changed"
+
+	if err := fs_storage.Update(m.Uuid, changed, RevisionAuthor{Name: "admin"}); err != nil {
+		t.Fatalf("can't update manifest: %s", err)
+	}
+
+	dbdir, err := ioutil.TempDir("", "dsapid-bolt")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dbdir)
+
+	db, err := OpenBoltDB(path.Join(dbdir, "dsapid.db"))
+	if err != nil {
+		t.Fatalf("can't open database: %s", err)
+	}
+	defer db.Close()
+
+	manifests, err := NewBoltManifestStorage(db, basedir, nil)
+	if err != nil {
+		t.Fatalf("can't open manifest storage: %s", err)
+	}
+
+	if count, err := MigrateManifests(manifests, fs_storage); err != nil || count != 1 {
+		t.Fatalf("should migrate 1 manifest but got %d (%v)", count, err)
+	}
+
+	revisions, err := manifests.Revisions(m.Uuid)
+	if err != nil || len(revisions) != 2 {
+		t.Fatalf("should migrate 2 revisions but got %d (%v)", len(revisions), err)
+	}
+
+	if revisions[1].Author.Name != "admin" || revisions[1].Manifest.Description != "changed" {
+		t.Errorf("migrated revision differs: %+v", revisions[1])
+	}
+
+	changed = CopyManifest(changed)
+	changed.Description = "changed again"
+
+	if err := manifests.Update(m.Uuid, changed, RevisionAuthor{}); err != nil {
+		t.Fatalf("can't update manifest: %s", err)
+	}
+
+	if revision, err := manifests.Revision(m.Uuid, 3); err != nil || revision.Manifest.Description != "changed again" {
+		t.Errorf("should continue the migrated history but got %v", err)
+	}
+}
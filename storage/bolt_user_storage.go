@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"encoding/json"
+	"github.com/MerlinDMC/dsapid"
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltUserStorage persists every user as its own key inside a bolt database.
+// Lookups are served by the embedded in-memory maps of jsonUserStorage which
+// has no filename and therefore never writes anything on its own.
+type boltUserStorage struct {
+	jsonUserStorage
+
+	db *bolt.DB
+}
+
+func NewBoltUserStorage(db *bolt.DB) (UserStorage, error) {
+	store := new(boltUserStorage)
+
+	store.db = db
+	store.users = make(map[string]*dsapid.UserResource, 0)
+	store.map_name_id = make(map[string]string)
+	store.map_email_id = make(map[string]string)
+	store.map_token_id = make(map[string]string)
+
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func (me *boltUserStorage) Save() error {
+	return me.db.Update(func(tx *bolt.Tx) error {
+		for id, user := range me.users {
+			if err := me.put(tx, id, user); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (me *boltUserStorage) Add(id string, user *dsapid.UserResource) error {
+	err := me.db.Update(func(tx *bolt.Tx) error {
+		return me.put(tx, id, user)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	me.add(id, *user)
+
+	return nil
+}
+
+func (me *boltUserStorage) Update(id string, user *dsapid.UserResource) error {
+	err := me.db.Update(func(tx *bolt.Tx) error {
+		return me.put(tx, id, user)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	me.delete(id)
+	me.add(id, *user)
+
+	return nil
+}
+
+func (me *boltUserStorage) Delete(id string) error {
+	err := me.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucketUsers).Delete([]byte(id))
+	})
+
+	if err != nil {
+		return err
+	}
+
+	me.delete(id)
+
+	return nil
+}
+
+func (me *boltUserStorage) EnsureExists(id, name string) *dsapid.UserResource {
+	if v, ok := me.users[id]; ok {
+		return v
+	}
+
+	user := new(dsapid.UserResource)
+	user.Uuid = id
+	user.Name = name
+
+	me.Add(id, user)
+
+	return user
+}
+
+func (me *boltUserStorage) put(tx *bolt.Tx, id string, user *dsapid.UserResource) error {
+	data, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+
+	return tx.Bucket(boltBucketUsers).Put([]byte(id), data)
+}
+
+func (me *boltUserStorage) load() error {
+	return me.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucketUsers).ForEach(func(k, v []byte) error {
+			var user dsapid.UserResource
+
+			if err := json.Unmarshal(v, &user); err != nil {
+				return ErrStorageFileInvalid
+			}
+
+			me.add(string(k), user)
+
+			return nil
+		})
+	})
+}
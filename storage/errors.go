@@ -10,4 +10,7 @@ var (
 	ErrStorageFileNotWritable error = errors.New("File not writable")
 	ErrStorageFileInvalid     error = errors.New("Syntax error in storage file")
 	ErrStorageItemNotFound    error = errors.New("Item not available in storage")
+	ErrStorageItemExists      error = errors.New("Item already exists in storage")
+	ErrManifestIncomplete     error = errors.New("Manifest or its files are incomplete")
+	ErrWatchNotSupported      error = errors.New("Watching is not supported by this storage")
 )
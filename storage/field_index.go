@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/MerlinDMC/dsapid"
+	"sort"
+	"strings"
+)
+
+const (
+	indexName    string = "name"
+	indexOwner   string = "owner"
+	indexOs      string = "os"
+	indexType    string = "type"
+	indexState   string = "state"
+	indexTag     string = "tag"
+	indexChannel string = "channel"
+)
+
+// fieldIndex maps the values of a single manifest field to the manifests
+// carrying them. Each list is ordered like manifestIndex.byDate.
+type fieldIndex struct {
+	values map[string][]*dsapid.ManifestResource
+	// sorted distinct values for prefix lookups
+	keys []string
+}
+
+type fieldKey struct {
+	field string
+	value string
+}
+
+func newFieldIndex() *fieldIndex {
+	return &fieldIndex{
+		values: make(map[string][]*dsapid.ManifestResource),
+		keys:   make([]string, 0),
+	}
+}
+
+// manifestKeys returns the index entries of manifest. Tags are indexed as
+// "key=value", manifests without channels under the empty channel.
+func manifestKeys(manifest *dsapid.ManifestResource) []fieldKey {
+	keys := []fieldKey{
+		{indexName, manifest.Name},
+		{indexOwner, manifest.Owner},
+		{indexOs, manifest.Os},
+		{indexType, string(manifest.Type)},
+		{indexState, string(manifest.State)},
+	}
+
+	for k, v := range manifest.Tags {
+		keys = append(keys, fieldKey{indexTag, tagKey(k, v)})
+	}
+
+	if len(manifest.Channels) == 0 {
+		keys = append(keys, fieldKey{indexChannel, ""})
+	}
+
+	seen := make(map[string]bool)
+
+	for _, channel := range manifest.Channels {
+		if !seen[channel] {
+			keys = append(keys, fieldKey{indexChannel, channel})
+			seen[channel] = true
+		}
+	}
+
+	return keys
+}
+
+func tagKey(key string, value interface{}) string {
+	return fmt.Sprintf("%s=%v", key, value)
+}
+
+// insert appends without ordering; used while bulk loading and followed by
+// a single sort().
+func (me *fieldIndex) insert(value string, manifest *dsapid.ManifestResource) {
+	if _, ok := me.values[value]; !ok {
+		me.addKey(value)
+	}
+
+	me.values[value] = append(me.values[value], manifest)
+}
+
+func (me *fieldIndex) sort() {
+	for _, list := range me.values {
+		sort.Sort(ManifestsByPublishedAt(list))
+	}
+}
+
+func (me *fieldIndex) add(value string, manifest *dsapid.ManifestResource) {
+	list, ok := me.values[value]
+	if !ok {
+		me.addKey(value)
+	}
+
+	i := sort.Search(len(list), func(i int) bool {
+		return manifestBefore(manifest, list[i])
+	})
+
+	list = append(list, nil)
+	copy(list[i+1:], list[i:])
+	list[i] = manifest
+
+	me.values[value] = list
+}
+
+func (me *fieldIndex) remove(value string, manifest *dsapid.ManifestResource) {
+	list := me.values[value]
+
+	for i, m := range list {
+		if m == manifest {
+			list = append(list[:i], list[i+1:]...)
+
+			break
+		}
+	}
+
+	if len(list) > 0 {
+		me.values[value] = list
+
+		return
+	}
+
+	delete(me.values, value)
+
+	if i := sort.SearchStrings(me.keys, value); i < len(me.keys) && me.keys[i] == value {
+		me.keys = append(me.keys[:i], me.keys[i+1:]...)
+	}
+}
+
+func (me *fieldIndex) addKey(value string) {
+	i := sort.SearchStrings(me.keys, value)
+
+	me.keys = append(me.keys, "")
+	copy(me.keys[i+1:], me.keys[i:])
+	me.keys[i] = value
+}
+
+// lookup returns the manifests carrying any of values or, with prefix set,
+// any value starting with one of them.
+func (me *fieldIndex) lookup(values []string, prefix bool) []*dsapid.ManifestResource {
+	lists := me.lists(values, prefix)
+
+	switch len(lists) {
+	case 0:
+		return nil
+	case 1:
+		result := make([]*dsapid.ManifestResource, len(lists[0]))
+		copy(result, lists[0])
+
+		return result
+	}
+
+	var result []*dsapid.ManifestResource
+
+	for _, list := range lists {
+		result = append(result, list...)
+	}
+
+	sort.Stable(ManifestsByPublishedAt(result))
+
+	return result
+}
+
+// count returns the number of manifests lookup would return.
+func (me *fieldIndex) count(values []string, prefix bool) (n int) {
+	for _, list := range me.lists(values, prefix) {
+		n += len(list)
+	}
+
+	return n
+}
+
+func (me *fieldIndex) lists(values []string, prefix bool) (lists [][]*dsapid.ManifestResource) {
+	for _, value := range values {
+		if !prefix {
+			if list, ok := me.values[value]; ok {
+				lists = append(lists, list)
+			}
+
+			continue
+		}
+
+		for i := sort.SearchStrings(me.keys, value); i < len(me.keys) && strings.HasPrefix(me.keys[i], value); i++ {
+			lists = append(lists, me.values[me.keys[i]])
+		}
+	}
+
+	return lists
+}
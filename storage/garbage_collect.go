@@ -0,0 +1,242 @@
+package storage
+
+import (
+	"github.com/MerlinDMC/dsapid"
+	"github.com/pborman/uuid"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+type GarbageOptions struct {
+	// nuked images and orphaned files must be untouched for this long
+	Grace  time.Duration
+	DryRun bool
+}
+
+// GarbageReport lists what a garbage collection run removed or, on a dry
+// run, would have removed.
+type GarbageReport struct {
+	DryRun         bool      `json:"dry_run"`
+	StartedAt      time.Time `json:"started_at"`
+	FinishedAt     time.Time `json:"finished_at"`
+	NukedImages    []string  `json:"nuked_images"`
+	OrphanedDirs   []string  `json:"orphaned_dirs"`
+	StrayFiles     []string  `json:"stray_files"`
+	OrphanedBlobs  []string  `json:"orphaned_blobs"`
+	BytesReclaimed int64     `json:"bytes_reclaimed"`
+}
+
+func (me *filesystemManifestStorage) CollectGarbage(opts GarbageOptions) (*GarbageReport, error) {
+	return me.collectGarbage(&me.manifestCache, me.Delete, opts)
+}
+
+func (me *boltManifestStorage) CollectGarbage(opts GarbageOptions) (*GarbageReport, error) {
+	return me.collectGarbage(&me.manifestCache, me.Delete, opts)
+}
+
+// collectGarbage purges images nuked longer than the grace period, removes
+// directories in basedir no manifest belongs to, files inside image
+// directories which are not part of the image and blobs no manifest
+// references. Directories which are not named by an uuid are left alone.
+func (me *manifestFiles) collectGarbage(cache *manifestCache, remove func(string), opts GarbageOptions) (*GarbageReport, error) {
+	report := &GarbageReport{
+		DryRun:        opts.DryRun,
+		StartedAt:     time.Now(),
+		NukedImages:   make([]string, 0),
+		OrphanedDirs:  make([]string, 0),
+		StrayFiles:    make([]string, 0),
+		OrphanedBlobs: make([]string, 0),
+	}
+
+	deadline := report.StartedAt.Add(-opts.Grace)
+
+	purged := me.collectNuked(cache, remove, deadline, report)
+
+	if err := me.collectOrphans(cache, purged, deadline, report); err != nil {
+		return report, err
+	}
+
+	if err := me.collectBlobs(cache, report); err != nil {
+		return report, err
+	}
+
+	report.FinishedAt = time.Now()
+
+	return report, nil
+}
+
+func (me *manifestFiles) collectNuked(cache *manifestCache, remove func(string), deadline time.Time, report *GarbageReport) map[string]bool {
+	purged := make(map[string]bool)
+	refs := make(map[string]int)
+
+	for manifest := range cache.List() {
+		if manifest.State != dsapid.ManifestStateNuked {
+			continue
+		}
+
+		changed := manifest.UpdatedAt
+		if changed.IsZero() {
+			changed = manifest.PublishedAt
+		}
+
+		if changed.After(deadline) {
+			continue
+		}
+
+		purged[manifest.Uuid] = true
+		report.NukedImages = append(report.NukedImages, manifest.Uuid)
+		report.BytesReclaimed += dirSize(me.ManifestPath(manifest))
+
+		for _, file := range manifest.Files {
+			if file.Sha1 == "" {
+				continue
+			}
+
+			// a shared blob is only freed once every manifest using it is purged
+			if refs[file.Sha1]++; refs[file.Sha1] == cache.blobRefCount(file.Sha1) {
+				report.BytesReclaimed += file.Size
+			}
+		}
+	}
+
+	if !report.DryRun {
+		for _, id := range report.NukedImages {
+			remove(id)
+		}
+	}
+
+	return purged
+}
+
+func (me *manifestFiles) collectOrphans(cache *manifestCache, purged map[string]bool, deadline time.Time, report *GarbageReport) error {
+	entries, err := ioutil.ReadDir(me.basedir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == defaultBlobDirname || uuid.Parse(entry.Name()) == nil || purged[entry.Name()] {
+			continue
+		}
+
+		dirname := path.Join(me.basedir, entry.Name())
+
+		manifest, ok := cache.GetOK(entry.Name())
+		if !ok {
+			// uploads and syncs write files before the manifest is added
+			if lastModified(dirname).After(deadline) {
+				continue
+			}
+
+			report.OrphanedDirs = append(report.OrphanedDirs, entry.Name())
+			report.BytesReclaimed += dirSize(dirname)
+
+			if !report.DryRun {
+				if err := os.RemoveAll(dirname); err != nil {
+					return err
+				}
+			}
+
+			continue
+		}
+
+		known := map[string]bool{
+			defaultManifestFilename: true,
+			defaultRevisionDirname:  true,
+			defaultIconFilename:     true,
+		}
+
+		for i := range manifest.Files {
+			known[path.Base(manifest.Files[i].Path)] = true
+		}
+
+		files, err := ioutil.ReadDir(dirname)
+		if err != nil {
+			return err
+		}
+
+		for _, file := range files {
+			if known[file.Name()] || file.ModTime().After(deadline) {
+				continue
+			}
+
+			filename := path.Join(dirname, file.Name())
+
+			report.StrayFiles = append(report.StrayFiles, path.Join(entry.Name(), file.Name()))
+			report.BytesReclaimed += dirSize(filename)
+
+			if !report.DryRun {
+				if err := os.RemoveAll(filename); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// collectBlobs holds blobs.lock so blobs ingested by a concurrent Add are
+// referenced before they are looked at.
+func (me *manifestFiles) collectBlobs(cache *manifestCache, report *GarbageReport) error {
+	me.blobs.lock.Lock()
+	defer me.blobs.lock.Unlock()
+
+	orphans := make([]string, 0)
+
+	err := me.blobs.backend.Walk(func(key string, size int64) error {
+		if cache.blobRefCount(path.Base(key)) == 0 {
+			orphans = append(orphans, key)
+			report.BytesReclaimed += size
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	report.OrphanedBlobs = orphans
+
+	if !report.DryRun {
+		for _, key := range orphans {
+			if err := me.blobs.backend.Remove(key); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func dirSize(filename string) (size int64) {
+	filepath.Walk(filename, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+
+		return nil
+	})
+
+	return size
+}
+
+func lastModified(filename string) (t time.Time) {
+	filepath.Walk(filename, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.ModTime().After(t) {
+			t = info.ModTime()
+		}
+
+		return nil
+	})
+
+	return t
+}
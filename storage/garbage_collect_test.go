@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"github.com/MerlinDMC/dsapid"
+	"github.com/pborman/uuid"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	storage, basedir := newTestManifestStorage(t)
+	defer os.RemoveAll(basedir)
+
+	nuked := newTestManifest("nuked", time.Now())
+	nuked.State = dsapid.ManifestStateNuked
+	nuked.Files = []dsapid.ManifestFileResource{{
+		Path: "nuked-1.0.0.zfs.gz",
+		Size: 4,
+		Sha1: "a17c9aaa61e80a1bf71d0d850af4e5baa9800bbd",
+	}}
+
+	if f, err := storage.CreateFile(nuked, &nuked.Files[0]); err == nil {
+		f.Write([]byte("data"))
+		f.Close()
+	}
+
+	active := newTestManifest("active", time.Now())
+	active.Icon = true
+
+	storage.Add(nuked.Uuid, nuked)
+	storage.Add(active.Uuid, active)
+
+	orphan := path.Join(basedir, uuid.New())
+	os.MkdirAll(orphan, 0770)
+	ioutil.WriteFile(path.Join(orphan, "upload.zfs.gz"), []byte("orphan"), 0660)
+
+	if err := storage.SaveIcon(active, []byte("icon")); err != nil {
+		t.Fatalf("can't save icon: %s", err)
+	}
+
+	stray := path.Join(storage.ManifestPath(active), "leftover")
+	ioutil.WriteFile(stray, []byte("stray"), 0660)
+
+	unknown := path.Join(basedir, "lost+found")
+	os.MkdirAll(unknown, 0770)
+
+	report, err := storage.CollectGarbage(GarbageOptions{Grace: time.Hour})
+	if err != nil {
+		t.Fatalf("can't collect garbage: %s", err)
+	}
+
+	if len(report.NukedImages)+len(report.OrphanedDirs)+len(report.StrayFiles)+len(report.OrphanedBlobs) != 0 {
+		t.Fatalf("should keep everything within the grace period but got %+v", report)
+	}
+
+	if report, err = storage.CollectGarbage(GarbageOptions{DryRun: true}); err != nil {
+		t.Fatalf("can't collect garbage: %s", err)
+	}
+
+	if len(report.NukedImages) != 1 || len(report.OrphanedDirs) != 1 || len(report.StrayFiles) != 1 {
+		t.Fatalf("should report nuked image, orphaned dir and stray file but got %+v", report)
+	}
+
+	if _, ok := storage.GetOK(nuked.Uuid); !ok {
+		t.Fatal("should not purge images on a dry run")
+	}
+
+	if report, err = storage.CollectGarbage(GarbageOptions{}); err != nil {
+		t.Fatalf("can't collect garbage: %s", err)
+	}
+
+	if _, ok := storage.GetOK(nuked.Uuid); ok {
+		t.Error("should purge nuked image")
+	}
+
+	if _, ok := storage.GetOK(active.Uuid); !ok {
+		t.Error("should keep active image")
+	}
+
+	for _, filename := range []string{orphan, stray, path.Join(basedir, defaultBlobDirname, "sha1", "a1", nuked.Files[0].Sha1)} {
+		if _, err := os.Stat(filename); !os.IsNotExist(err) {
+			t.Errorf("should remove %s", filename)
+		}
+	}
+
+	if icon, err := storage.OpenIcon(active); err != nil {
+		t.Error("should keep the icon of an image")
+	} else {
+		icon.Close()
+	}
+
+	if _, err := os.Stat(unknown); err != nil {
+		t.Error("should leave directories not named by an uuid alone")
+	}
+
+	if report.BytesReclaimed < int64(len("data")+len("orphan")+len("stray")) {
+		t.Errorf("should report reclaimed bytes but got %d", report.BytesReclaimed)
+	}
+}
+
+func TestCollectGarbageRemovesOrphanedBlobs(t *testing.T) {
+	storage, basedir := newTestManifestStorage(t)
+	defer os.RemoveAll(basedir)
+
+	blob := path.Join(basedir, defaultBlobDirname, "sha1", "a1", "a17c9aaa61e80a1bf71d0d850af4e5baa9800bbd")
+	os.MkdirAll(path.Dir(blob), 0770)
+	ioutil.WriteFile(blob, []byte("data"), 0660)
+
+	report, err := storage.CollectGarbage(GarbageOptions{})
+	if err != nil {
+		t.Fatalf("can't collect garbage: %s", err)
+	}
+
+	if len(report.OrphanedBlobs) != 1 || report.BytesReclaimed != 4 {
+		t.Errorf("should report orphaned blob but got %+v", report)
+	}
+
+	if _, err := os.Stat(blob); !os.IsNotExist(err) {
+		t.Error("should remove orphaned blob")
+	}
+}
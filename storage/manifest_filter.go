@@ -1,52 +1,301 @@
 package storage
 
 import (
+	"fmt"
 	"github.com/MerlinDMC/dsapid"
 	"strings"
+	"time"
 )
 
 func FilterManifestEnabled() ManifestFilter {
-	return func(manifest *dsapid.ManifestResource) bool {
-		return manifest.State == dsapid.ManifestStateActive || manifest.State == dsapid.ManifestStateDeprecated
+	return &indexedFilter{
+		ManifestFilterFunc: func(manifest *dsapid.ManifestResource) bool {
+			return manifest.State == dsapid.ManifestStateActive || manifest.State == dsapid.ManifestStateDeprecated
+		},
+		field:  indexState,
+		values: []string{string(dsapid.ManifestStateActive), string(dsapid.ManifestStateDeprecated)},
 	}
 }
 
 func FilterManifestPublic(value bool) ManifestFilter {
-	return func(manifest *dsapid.ManifestResource) bool {
+	return ManifestFilterFunc(func(manifest *dsapid.ManifestResource) bool {
 		return manifest.Public == value
-	}
+	})
 }
 
+// FilterManifestForUser matches public manifests and the private ones
+// owned by or shared with the user uuid.
 func FilterManifestForUser(uuid string) ManifestFilter {
-	return func(manifest *dsapid.ManifestResource) bool {
-		return manifest.Public == true || manifest.Owner == uuid
-	}
+	return ManifestFilterFunc(func(manifest *dsapid.ManifestResource) bool {
+		if manifest.Public == true || manifest.Owner == uuid {
+			return true
+		}
+
+		for _, id := range manifest.Acl {
+			if id == uuid {
+				return true
+			}
+		}
+
+		return false
+	})
+}
+
+// FilterManifestOwnerOrAcl matches the images owned by or shared with uuid
+// regardless of them being public.
+func FilterManifestOwnerOrAcl(uuid string) ManifestFilter {
+	return ManifestFilterFunc(func(manifest *dsapid.ManifestResource) bool {
+		if manifest.Owner == uuid {
+			return true
+		}
+
+		for _, id := range manifest.Acl {
+			if id == uuid {
+				return true
+			}
+		}
+
+		return false
+	})
 }
 
 func FilterManifestName(value string) ManifestFilter {
-	return func(manifest *dsapid.ManifestResource) bool {
-		if strings.HasPrefix(value, "~") {
+	if strings.HasPrefix(value, "~") {
+		return ManifestFilterFunc(func(manifest *dsapid.ManifestResource) bool {
 			return strings.Contains(manifest.Name, value[1:])
-		} else {
+		})
+	}
+
+	return &indexedFilter{
+		ManifestFilterFunc: func(manifest *dsapid.ManifestResource) bool {
 			return strings.HasPrefix(manifest.Name, value)
-		}
+		},
+		field:  indexName,
+		values: []string{value},
+		prefix: true,
 	}
 }
 
-func FilterManifestVersion(value string) ManifestFilter {
-	return func(manifest *dsapid.ManifestResource) bool {
-		return strings.HasPrefix(manifest.Version, value)
+// FilterManifestExactName matches the name like IMGAPI does: exactly or,
+// prefixed by "~", as substring.
+func FilterManifestExactName(value string) ManifestFilter {
+	if strings.HasPrefix(value, "~") {
+		return FilterManifestName(value)
+	}
+
+	return &indexedFilter{
+		ManifestFilterFunc: func(manifest *dsapid.ManifestResource) bool {
+			return manifest.Name == value
+		},
+		field:  indexName,
+		values: []string{value},
 	}
 }
 
+// FilterManifestVersion matches the versions starting with the fields of
+// value, see VersionHasPrefix.
+func FilterManifestVersion(value string) ManifestFilter {
+	return ManifestFilterFunc(func(manifest *dsapid.ManifestResource) bool {
+		return VersionHasPrefix(manifest.Version, value)
+	})
+}
+
+// FilterManifestExactVersion matches the version exactly or, prefixed by
+// "~", as substring.
+func FilterManifestExactVersion(value string) ManifestFilter {
+	return ManifestFilterFunc(func(manifest *dsapid.ManifestResource) bool {
+		if strings.HasPrefix(value, "~") {
+			return strings.Contains(manifest.Version, value[1:])
+		}
+
+		return manifest.Version == value
+	})
+}
+
 func FilterManifestOs(value string) ManifestFilter {
-	return func(manifest *dsapid.ManifestResource) bool {
-		return strings.HasPrefix(manifest.Os, value)
+	return &indexedFilter{
+		ManifestFilterFunc: func(manifest *dsapid.ManifestResource) bool {
+			return strings.HasPrefix(manifest.Os, value)
+		},
+		field:  indexOs,
+		values: []string{value},
+		prefix: true,
+	}
+}
+
+func FilterManifestExactOs(value string) ManifestFilter {
+	return &indexedFilter{
+		ManifestFilterFunc: func(manifest *dsapid.ManifestResource) bool {
+			return manifest.Os == value
+		},
+		field:  indexOs,
+		values: []string{value},
 	}
 }
 
 func FilterManifestUuid(value string) ManifestFilter {
-	return func(manifest *dsapid.ManifestResource) bool {
+	return ManifestFilterFunc(func(manifest *dsapid.ManifestResource) bool {
 		return strings.HasPrefix(manifest.Uuid, value)
+	})
+}
+
+// FilterManifestAfter matches the manifests listed after marker.
+func FilterManifestAfter(marker *dsapid.ManifestResource) ManifestFilter {
+	return ManifestFilterFunc(func(manifest *dsapid.ManifestResource) bool {
+		return manifestBefore(marker, manifest)
+	})
+}
+
+// FilterManifestAfterPosition matches the manifests listed after the place
+// a manifest published at published with the uuid id is or was listed at.
+func FilterManifestAfterPosition(published time.Time, id string) ManifestFilter {
+	return FilterManifestAfter(&dsapid.ManifestResource{
+		Uuid:        id,
+		PublishedAt: published,
+	})
+}
+
+func FilterManifestOwner(uuid string) ManifestFilter {
+	return &indexedFilter{
+		ManifestFilterFunc: func(manifest *dsapid.ManifestResource) bool {
+			return manifest.Owner == uuid
+		},
+		field:  indexOwner,
+		values: []string{uuid},
+	}
+}
+
+func FilterManifestType(value dsapid.ManifestType) ManifestFilter {
+	return &indexedFilter{
+		ManifestFilterFunc: func(manifest *dsapid.ManifestResource) bool {
+			return manifest.Type == value
+		},
+		field:  indexType,
+		values: []string{string(value)},
+	}
+}
+
+func FilterManifestState(value dsapid.ManifestState) ManifestFilter {
+	return &indexedFilter{
+		ManifestFilterFunc: func(manifest *dsapid.ManifestResource) bool {
+			return manifest.State == value
+		},
+		field:  indexState,
+		values: []string{string(value)},
+	}
+}
+
+// FilterManifestTag matches manifests tagged key with a value printing as
+// value.
+func FilterManifestTag(key string, value string) ManifestFilter {
+	return &indexedFilter{
+		ManifestFilterFunc: func(manifest *dsapid.ManifestResource) bool {
+			v, ok := manifest.Tags[key]
+
+			return ok && fmt.Sprint(v) == value
+		},
+		field:  indexTag,
+		values: []string{tagKey(key, value)},
+	}
+}
+
+func FilterManifestBillingTag(value string) ManifestFilter {
+	return ManifestFilterFunc(func(manifest *dsapid.ManifestResource) bool {
+		for _, tag := range manifest.BillingTags {
+			if tag == value {
+				return true
+			}
+		}
+
+		return false
+	})
+}
+
+// FilterManifestLatest matches the manifests kept by LatestByName.
+func FilterManifestLatest(latest map[string]*dsapid.ManifestResource) ManifestFilter {
+	return ManifestFilterFunc(func(manifest *dsapid.ManifestResource) bool {
+		return latest[manifest.Name] == manifest
+	})
+}
+
+// FilterManifestPublishedAfter matches manifests published at or after t.
+func FilterManifestPublishedAfter(t time.Time) ManifestFilter {
+	return ManifestFilterFunc(func(manifest *dsapid.ManifestResource) bool {
+		return !manifest.PublishedAt.Before(t)
+	})
+}
+
+// FilterManifestPublishedBefore matches manifests published before t.
+func FilterManifestPublishedBefore(t time.Time) ManifestFilter {
+	return ManifestFilterFunc(func(manifest *dsapid.ManifestResource) bool {
+		return manifest.PublishedAt.Before(t)
+	})
+}
+
+// FilterManifestCreatedAfter matches manifests created at or after t.
+// Manifests without a creation date never match.
+func FilterManifestCreatedAfter(t time.Time) ManifestFilter {
+	return ManifestFilterFunc(func(manifest *dsapid.ManifestResource) bool {
+		return !manifest.CreatedAt.IsZero() && !manifest.CreatedAt.Before(t)
+	})
+}
+
+// FilterManifestCreatedBefore matches manifests created before t.
+// Manifests without a creation date never match.
+func FilterManifestCreatedBefore(t time.Time) ManifestFilter {
+	return ManifestFilterFunc(func(manifest *dsapid.ManifestResource) bool {
+		return !manifest.CreatedAt.IsZero() && manifest.CreatedAt.Before(t)
+	})
+}
+
+// FilterManifestMinSize matches manifests whose files add up to at least
+// size bytes.
+func FilterManifestMinSize(size int64) ManifestFilter {
+	return ManifestFilterFunc(func(manifest *dsapid.ManifestResource) bool {
+		return manifestSize(manifest) >= size
+	})
+}
+
+// FilterManifestMaxSize matches manifests whose files add up to at most
+// size bytes.
+func FilterManifestMaxSize(size int64) ManifestFilter {
+	return ManifestFilterFunc(func(manifest *dsapid.ManifestResource) bool {
+		return manifestSize(manifest) <= size
+	})
+}
+
+func manifestSize(manifest *dsapid.ManifestResource) (size int64) {
+	for _, file := range manifest.Files {
+		size += file.Size
+	}
+
+	return size
+}
+
+// FilterManifestChannel matches manifests in channel. Manifests without any
+// channel are matched if channel is the default one.
+func FilterManifestChannel(channel string, is_default bool) ManifestFilter {
+	values := []string{channel}
+
+	if is_default {
+		values = append(values, "")
+	}
+
+	return &indexedFilter{
+		ManifestFilterFunc: func(manifest *dsapid.ManifestResource) bool {
+			if len(manifest.Channels) == 0 {
+				return is_default
+			}
+
+			for _, v := range manifest.Channels {
+				if v == channel {
+					return true
+				}
+			}
+
+			return false
+		},
+		field:  indexChannel,
+		values: values,
 	}
 }
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"github.com/MerlinDMC/dsapid"
+	"testing"
+	"time"
+)
+
+func TestFilterManifestRanges(t *testing.T) {
+	at := time.Date(2016, 3, 2, 12, 0, 0, 0, time.UTC)
+
+	manifest := &dsapid.ManifestResource{
+		PublishedAt: at,
+		CreatedAt:   at,
+		Files: []dsapid.ManifestFileResource{
+			{Size: 100},
+			{Size: 50},
+		},
+	}
+
+	undated := &dsapid.ManifestResource{
+		PublishedAt: at,
+	}
+
+	tests := []struct {
+		name     string
+		filter   ManifestFilter
+		manifest *dsapid.ManifestResource
+		expected bool
+	}{
+		{"published after earlier", FilterManifestPublishedAfter(at.Add(-time.Second)), manifest, true},
+		{"published after same", FilterManifestPublishedAfter(at), manifest, true},
+		{"published after later", FilterManifestPublishedAfter(at.Add(time.Second)), manifest, false},
+		{"published before earlier", FilterManifestPublishedBefore(at.Add(-time.Second)), manifest, false},
+		{"published before same", FilterManifestPublishedBefore(at), manifest, false},
+		{"published before later", FilterManifestPublishedBefore(at.Add(time.Second)), manifest, true},
+		{"created after earlier", FilterManifestCreatedAfter(at.Add(-time.Second)), manifest, true},
+		{"created after same", FilterManifestCreatedAfter(at), manifest, true},
+		{"created after later", FilterManifestCreatedAfter(at.Add(time.Second)), manifest, false},
+		{"created before earlier", FilterManifestCreatedBefore(at.Add(-time.Second)), manifest, false},
+		{"created before same", FilterManifestCreatedBefore(at), manifest, false},
+		{"created before later", FilterManifestCreatedBefore(at.Add(time.Second)), manifest, true},
+		{"created after without date", FilterManifestCreatedAfter(time.Time{}), undated, false},
+		{"created before without date", FilterManifestCreatedBefore(at), undated, false},
+		{"min size below", FilterManifestMinSize(149), manifest, true},
+		{"min size same", FilterManifestMinSize(150), manifest, true},
+		{"min size above", FilterManifestMinSize(151), manifest, false},
+		{"max size below", FilterManifestMaxSize(149), manifest, false},
+		{"max size same", FilterManifestMaxSize(150), manifest, true},
+		{"max size above", FilterManifestMaxSize(151), manifest, true},
+		{"min size without files", FilterManifestMinSize(0), undated, true},
+		{"max size without files", FilterManifestMaxSize(0), undated, true},
+	}
+
+	for _, test := range tests {
+		if m := test.filter.Match(test.manifest); m != test.expected {
+			t.Errorf("%s: should be %v but got %v", test.name, test.expected, m)
+		}
+	}
+}
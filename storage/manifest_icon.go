@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"github.com/MerlinDMC/dsapid"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+const (
+	defaultIconFilename string = "icon"
+)
+
+// OpenIcon opens the icon stored next to the files of manifest.
+func (me *manifestFiles) OpenIcon(manifest *dsapid.ManifestResource) (BlobReader, error) {
+	return openLocalBlob(me.iconPath(manifest))
+}
+
+// SaveIcon replaces the icon of manifest with data.
+func (me *manifestFiles) SaveIcon(manifest *dsapid.ManifestResource, data []byte) error {
+	if err := os.MkdirAll(me.ManifestPath(manifest), 0770); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(me.ManifestPath(manifest), "."+defaultIconFilename)
+	if err != nil {
+		return err
+	}
+
+	if err := tmp.Chmod(0660); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+
+		return err
+	}
+
+	return os.Rename(tmp.Name(), me.iconPath(manifest))
+}
+
+func (me *manifestFiles) RemoveIcon(manifest *dsapid.ManifestResource) error {
+	if err := os.Remove(me.iconPath(manifest)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (me *manifestFiles) iconPath(manifest *dsapid.ManifestResource) string {
+	return path.Join(me.ManifestPath(manifest), defaultIconFilename)
+}
@@ -0,0 +1,326 @@
+package storage
+
+import (
+	"github.com/MerlinDMC/dsapid"
+	"sort"
+	"sync"
+	"time"
+)
+
+// manifestCache is the in-memory view every ManifestStorage backend serves
+// reads from. Writers replace single entries, Reload builds a fresh index
+// off to the side and swaps it in.
+type manifestCache struct {
+	lock  sync.RWMutex
+	index *manifestIndex
+
+	// bumped on every change; starts at the load time in nanoseconds so a
+	// restarted server never hands out a generation seen before
+	generation uint64
+	modified   time.Time
+}
+
+type manifestIndex struct {
+	manifests map[string]*dsapid.ManifestResource
+	byDate    []*dsapid.ManifestResource
+
+	// number of manifests referencing a file by its sha1 and the sums each
+	// manifest was counted with
+	blobRefs map[string]int
+	refs     map[string][]string
+
+	// secondary indexes by field and the entries each manifest was indexed
+	// under so they are found again whatever the stored manifest holds
+	fields  map[string]*fieldIndex
+	indexed map[string][]fieldKey
+
+	search *searchIndex
+}
+
+func (me *manifestCache) Get(id string) *dsapid.ManifestResource {
+	me.lock.RLock()
+	defer me.lock.RUnlock()
+
+	return me.index.manifests[id]
+}
+
+func (me *manifestCache) GetOK(id string) (*dsapid.ManifestResource, bool) {
+	me.lock.RLock()
+	defer me.lock.RUnlock()
+
+	v, ok := me.index.manifests[id]
+
+	return v, ok
+}
+
+func (me *manifestCache) List() (c chan *dsapid.ManifestResource) {
+	c = make(chan *dsapid.ManifestResource)
+
+	// take a snapshot so a slow consumer never holds the lock
+	me.lock.RLock()
+	items := make([]*dsapid.ManifestResource, len(me.index.byDate))
+	copy(items, me.index.byDate)
+	me.lock.RUnlock()
+
+	go func() {
+		for _, item := range items {
+			c <- item
+		}
+
+		close(c)
+	}()
+
+	return
+}
+
+func (me *manifestCache) Filter(flist ...ManifestFilter) (c chan *dsapid.ManifestResource) {
+	c = make(chan *dsapid.ManifestResource)
+
+	me.lock.RLock()
+	items := me.index.plan(flist)
+	me.lock.RUnlock()
+
+	go func() {
+	nextItem:
+		for _, item := range items {
+			for _, f := range flist {
+				if !f.Match(item) {
+					continue nextItem
+				}
+			}
+
+			c <- item
+		}
+
+		close(c)
+	}()
+
+	return
+}
+
+// put adds or replaces a manifest and returns the sha1 sums of files no
+// manifest references anymore.
+func (me *manifestCache) put(id string, manifest *dsapid.ManifestResource) []string {
+	me.lock.Lock()
+	defer me.lock.Unlock()
+
+	me.bump()
+
+	return me.index.add(id, manifest)
+}
+
+// remove drops a manifest and returns the sha1 sums of files no manifest
+// references anymore.
+func (me *manifestCache) remove(id string) []string {
+	me.lock.Lock()
+	defer me.lock.Unlock()
+
+	me.bump()
+
+	return me.index.delete(id)
+}
+
+// blobRefCount returns the number of manifests referencing the file sum.
+func (me *manifestCache) blobRefCount(sum string) int {
+	me.lock.RLock()
+	defer me.lock.RUnlock()
+
+	return me.index.blobRefs[sum]
+}
+
+func (me *manifestCache) swap(index *manifestIndex) {
+	me.lock.Lock()
+	defer me.lock.Unlock()
+
+	me.index = index
+	me.bump()
+}
+
+func (me *manifestCache) bump() {
+	me.modified = time.Now()
+
+	if me.generation == 0 {
+		me.generation = uint64(me.modified.UnixNano())
+	} else {
+		me.generation++
+	}
+}
+
+// Generation returns a counter which changes with every change of the
+// catalog and the time of the last change.
+func (me *manifestCache) Generation() (uint64, time.Time) {
+	me.lock.RLock()
+	defer me.lock.RUnlock()
+
+	return me.generation, me.modified
+}
+
+func newManifestIndex() *manifestIndex {
+	return &manifestIndex{
+		manifests: make(map[string]*dsapid.ManifestResource),
+		byDate:    make([]*dsapid.ManifestResource, 0),
+		blobRefs:  make(map[string]int),
+		refs:      make(map[string][]string),
+		fields: map[string]*fieldIndex{
+			indexName:    newFieldIndex(),
+			indexOwner:   newFieldIndex(),
+			indexOs:      newFieldIndex(),
+			indexType:    newFieldIndex(),
+			indexState:   newFieldIndex(),
+			indexTag:     newFieldIndex(),
+			indexChannel: newFieldIndex(),
+		},
+		indexed: make(map[string][]fieldKey),
+		search:  newSearchIndex(),
+	}
+}
+
+// plan returns the candidates for a query: the smallest set any indexed
+// filter selects or every manifest if none of flist is indexed. The result
+// is a copy ordered like byDate.
+func (me *manifestIndex) plan(flist []ManifestFilter) []*dsapid.ManifestResource {
+	var best *indexedFilter
+	best_count := 0
+
+	for _, f := range flist {
+		if f, ok := f.(*indexedFilter); ok {
+			if count := me.fields[f.field].count(f.values, f.prefix); best == nil || count < best_count {
+				best, best_count = f, count
+			}
+		}
+	}
+
+	if best != nil {
+		return me.fields[best.field].lookup(best.values, best.prefix)
+	}
+
+	items := make([]*dsapid.ManifestResource, len(me.byDate))
+	copy(items, me.byDate)
+
+	return items
+}
+
+// insert appends without keeping byDate ordered; used while bulk loading
+// and followed by a single sort().
+func (me *manifestIndex) insert(manifest *dsapid.ManifestResource) {
+	me.manifests[manifest.Uuid] = manifest
+	me.byDate = append(me.byDate, manifest)
+
+	keys := manifestKeys(manifest)
+
+	for _, key := range keys {
+		me.fields[key.field].insert(key.value, manifest)
+	}
+
+	me.indexed[manifest.Uuid] = keys
+	me.search.add(manifest, true)
+
+	me.ref(manifest.Uuid, manifest)
+}
+
+func (me *manifestIndex) sort() {
+	sort.Sort(ManifestsByPublishedAt(me.byDate))
+
+	for _, field := range me.fields {
+		field.sort()
+	}
+
+	me.search.sort()
+}
+
+// add replaces the entry of id. The files of the previous entry are only
+// released after those of manifest are referenced so a file kept by the
+// update is never released.
+func (me *manifestIndex) add(id string, manifest *dsapid.ManifestResource) (released []string) {
+	old_sums := me.refs[id]
+
+	me.drop(id)
+
+	me.manifests[id] = manifest
+	me.ref(id, manifest)
+
+	i := sort.Search(len(me.byDate), func(i int) bool {
+		return manifestBefore(manifest, me.byDate[i])
+	})
+
+	me.byDate = append(me.byDate, nil)
+	copy(me.byDate[i+1:], me.byDate[i:])
+	me.byDate[i] = manifest
+
+	keys := manifestKeys(manifest)
+
+	for _, key := range keys {
+		me.fields[key.field].add(key.value, manifest)
+	}
+
+	me.indexed[id] = keys
+	me.search.add(manifest, false)
+
+	return me.unref(old_sums)
+}
+
+func (me *manifestIndex) delete(id string) (released []string) {
+	sums := me.refs[id]
+
+	if me.drop(id) {
+		released = me.unref(sums)
+	}
+
+	return released
+}
+
+// drop removes the entry of id but leaves its blob references alone.
+func (me *manifestIndex) drop(id string) bool {
+	for i, m := range me.byDate {
+		if m.Uuid == id {
+			me.byDate = append(me.byDate[:i], me.byDate[i+1:]...)
+
+			break
+		}
+	}
+
+	v, ok := me.manifests[id]
+	if !ok {
+		return false
+	}
+
+	delete(me.manifests, id)
+
+	for _, key := range me.indexed[id] {
+		me.fields[key.field].remove(key.value, v)
+	}
+
+	delete(me.indexed, id)
+	delete(me.refs, id)
+	me.search.remove(id)
+
+	return true
+}
+
+func (me *manifestIndex) ref(id string, manifest *dsapid.ManifestResource) {
+	sums := make([]string, 0, len(manifest.Files))
+
+	for _, file := range manifest.Files {
+		if file.Sha1 != "" {
+			me.blobRefs[file.Sha1]++
+
+			sums = append(sums, file.Sha1)
+		}
+	}
+
+	me.refs[id] = sums
+}
+
+func (me *manifestIndex) unref(sums []string) (released []string) {
+	for _, sum := range sums {
+		me.blobRefs[sum]--
+
+		if me.blobRefs[sum] <= 0 {
+			delete(me.blobRefs, sum)
+
+			released = append(released, sum)
+		}
+	}
+
+	return released
+}
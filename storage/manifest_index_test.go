@@ -0,0 +1,260 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/MerlinDMC/dsapid"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestManifestCache(count int) *manifestCache {
+	cache := new(manifestCache)
+	index := newManifestIndex()
+
+	for i := 0; i < count; i++ {
+		m := newTestManifest(fmt.Sprintf("image%d", i%100), time.Unix(int64(i), 0))
+		m.Version = fmt.Sprintf("1.%d.0", i)
+		m.Owner = fmt.Sprintf("owner%d", i%10)
+		m.Tags = dsapid.Table{"role": fmt.Sprintf("role%d", i%3)}
+
+		if i%4 == 0 {
+			m.State = dsapid.ManifestStateDisabled
+		}
+
+		index.insert(m)
+	}
+
+	index.sort()
+	cache.swap(index)
+
+	return cache
+}
+
+func collect(c chan *dsapid.ManifestResource) (ids []string) {
+	for m := range c {
+		ids = append(ids, m.Uuid)
+	}
+
+	return ids
+}
+
+// scan hides the index of filters so Filter has to walk every manifest.
+func scan(flist ...ManifestFilter) []ManifestFilter {
+	var result []ManifestFilter
+
+	for _, f := range flist {
+		result = append(result, ManifestFilterFunc(f.Match))
+	}
+
+	return result
+}
+
+func TestFilterPlansAgainstIndexes(t *testing.T) {
+	cache := newTestManifestCache(1000)
+
+	queries := [][]ManifestFilter{
+		{FilterManifestEnabled()},
+		{FilterManifestEnabled(), FilterManifestName("image1"), FilterManifestOwner("owner1")},
+		{FilterManifestName("image42"), FilterManifestPublic(true)},
+		{FilterManifestName("~age4")},
+		{FilterManifestOs("smart"), FilterManifestType(dsapid.ManifestTypeZone)},
+		{FilterManifestState(dsapid.ManifestStateDisabled), FilterManifestTag("role", "role1")},
+		{FilterManifestOwner("nobody")},
+	}
+
+	for i, query := range queries {
+		indexed, scanned := collect(cache.Filter(query...)), collect(cache.Filter(scan(query...)...))
+
+		if strings.Join(indexed, ",") != strings.Join(scanned, ",") {
+			t.Errorf("query %d: should return %d manifests like a full scan but got %d", i, len(scanned), len(indexed))
+		}
+	}
+}
+
+func TestIndexesFollowInPlaceUpdates(t *testing.T) {
+	storage, basedir := newTestManifestStorage(t)
+	defer os.RemoveAll(basedir)
+
+	m := newTestManifest("base", time.Now())
+	storage.Add(m.Uuid, m)
+
+	m.State = dsapid.ManifestStateDisabled
+	storage.Update(m.Uuid, m, RevisionAuthor{})
+
+	if ids := collect(storage.Filter(FilterManifestEnabled())); len(ids) != 0 {
+		t.Errorf("should not find disabled manifest as enabled")
+	}
+
+	if ids := collect(storage.Filter(FilterManifestState(dsapid.ManifestStateDisabled))); len(ids) != 1 {
+		t.Errorf("should find manifest by its new state")
+	}
+
+	storage.Delete(m.Uuid)
+
+	if ids := collect(storage.Filter(FilterManifestName("base"))); len(ids) != 0 {
+		t.Errorf("should drop deleted manifest from the indexes")
+	}
+}
+
+func benchmarkFilter(b *testing.B, indexed bool) {
+	cache := newTestManifestCache(10000)
+
+	// all versions of a name for an owner
+	query := []ManifestFilter{FilterManifestEnabled(), FilterManifestName("image11"), FilterManifestOwner("owner1")}
+	if !indexed {
+		query = scan(query...)
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for range cache.Filter(query...) {
+		}
+	}
+}
+
+func BenchmarkFilterIndexed(b *testing.B) { benchmarkFilter(b, true) }
+func BenchmarkFilterScan(b *testing.B)    { benchmarkFilter(b, false) }
+
+func TestFilterAfterMarkerIsStable(t *testing.T) {
+	cache := newTestManifestCache(0)
+	published := time.Now()
+
+	for i := 0; i < 10; i++ {
+		m := newTestManifest("base", published)
+		cache.put(m.Uuid, m)
+	}
+
+	all := collect(cache.List())
+	page := collect(cache.Filter(FilterManifestAfter(cache.Get(all[4]))))
+
+	// images published later must not shift the following pages
+	m := newTestManifest("base", published.Add(time.Hour))
+	cache.put(m.Uuid, m)
+
+	if strings.Join(page, ",") != strings.Join(all[5:], ",") {
+		t.Errorf("should list the manifests after the marker")
+	}
+
+	if next := collect(cache.Filter(FilterManifestAfter(cache.Get(all[4])))); strings.Join(next, ",") != strings.Join(page, ",") {
+		t.Errorf("should return the same page after adding a newer manifest")
+	}
+}
+
+func TestSearchRanksAndFollowsUpdates(t *testing.T) {
+	cache := newTestManifestCache(0)
+
+	base := newTestManifest("base64", time.Unix(100, 0))
+	base.Description = "A minimal base image"
+	base.Owner = "owner-a"
+
+	older := newTestManifest("base64", time.Unix(50, 0))
+	older.Description = "A minimal base image"
+	older.Owner = "owner-a"
+
+	mongo := newTestManifest("mongodb", time.Unix(200, 0))
+	mongo.Description = "MongoDB on a base64 image"
+	mongo.Owner = "owner-b"
+
+	for _, m := range []*dsapid.ManifestResource{base, older, mongo} {
+		cache.put(m.Uuid, m)
+	}
+
+	owner_name := func(id string) string {
+		return map[string]string{"owner-a": "Joyent Inc", "owner-b": "community"}[id]
+	}
+
+	ids := func(results []*dsapid.ManifestResource) (ids []string) {
+		for _, m := range results {
+			ids = append(ids, m.Uuid)
+		}
+
+		return ids
+	}
+
+	expect := func(query string, want ...string) {
+		if got := ids(cache.Search(query, owner_name)); strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("Search(%q) = %v, want %v", query, got, want)
+		}
+	}
+
+	// name matches outrank description matches, equal scores go by date
+	expect("base64", base.Uuid, older.Uuid, mongo.Uuid)
+	expect("mini", base.Uuid, older.Uuid)
+	expect("joyent base", base.Uuid, older.Uuid)
+	expect("community mongo", mongo.Uuid)
+	expect("")
+
+	mongo.Description = "MongoDB"
+	cache.put(mongo.Uuid, mongo)
+	expect("base64", base.Uuid, older.Uuid)
+
+	cache.remove(older.Uuid)
+	expect("minimal", base.Uuid)
+}
+
+func TestGenerationChangesWithCatalog(t *testing.T) {
+	cache := newTestManifestCache(10)
+
+	before, _ := cache.Generation()
+
+	m := newTestManifest("image", time.Unix(100, 0))
+	cache.put(m.Uuid, m)
+
+	after_put, _ := cache.Generation()
+	if after_put == before {
+		t.Errorf("generation unchanged by put")
+	}
+
+	cache.remove(m.Uuid)
+
+	if after_remove, _ := cache.Generation(); after_remove == after_put {
+		t.Errorf("generation unchanged by remove")
+	}
+}
+
+func TestFilterChannelDefaultsUnassigned(t *testing.T) {
+	cache := newTestManifestCache(0)
+
+	plain := newTestManifest("plain", time.Unix(300, 0))
+
+	dev := newTestManifest("dev", time.Unix(200, 0))
+	dev.Channels = []string{"dev"}
+
+	both := newTestManifest("both", time.Unix(100, 0))
+	both.Channels = []string{"release", "dev"}
+
+	for _, m := range []*dsapid.ManifestResource{plain, dev, both} {
+		cache.put(m.Uuid, m)
+	}
+
+	if got, want := strings.Join(collect(cache.Filter(FilterManifestChannel("release", true))), ","), plain.Uuid+","+both.Uuid; got != want {
+		t.Errorf("default channel = %s, want %s", got, want)
+	}
+
+	if got, want := strings.Join(collect(cache.Filter(FilterManifestChannel("dev", false))), ","), dev.Uuid+","+both.Uuid; got != want {
+		t.Errorf("dev channel = %s, want %s", got, want)
+	}
+
+	dev.Channels = []string{"release"}
+	cache.put(dev.Uuid, dev)
+
+	if got, want := strings.Join(collect(cache.Filter(FilterManifestChannel("dev", false))), ","), both.Uuid; got != want {
+		t.Errorf("dev channel after update = %s, want %s", got, want)
+	}
+}
+
+func TestFilterForUserHonorsAcl(t *testing.T) {
+	m := newTestManifest("private", time.Unix(100, 0))
+	m.Public = false
+	m.Owner = "owner"
+	m.Acl = []string{"partner"}
+
+	for user, want := range map[string]bool{"owner": true, "partner": true, "stranger": false} {
+		if got := FilterManifestForUser(user).Match(m); got != want {
+			t.Errorf("FilterManifestForUser(%q) = %t, want %t", user, got, want)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/MerlinDMC/dsapid"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRevisionDirname string = "revisions"
+)
+
+// RevisionAuthor identifies who changed a manifest.
+type RevisionAuthor struct {
+	Uuid string `json:"uuid,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+// ManifestRevision is the state of a manifest after a numbered change. The
+// first revision is the state the manifest had before its first update.
+type ManifestRevision struct {
+	Revision  int                      `json:"revision"`
+	CreatedAt time.Time                `json:"created_at"`
+	Author    RevisionAuthor           `json:"author"`
+	Manifest  *dsapid.ManifestResource `json:"manifest"`
+}
+
+type ManifestChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// DiffManifests returns the top level manifest fields which differ between
+// from and to keyed by their json name.
+func DiffManifests(from, to *dsapid.ManifestResource) (map[string]ManifestChange, error) {
+	from_fields, err := manifestFields(from)
+	if err != nil {
+		return nil, err
+	}
+
+	to_fields, err := manifestFields(to)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(map[string]ManifestChange)
+
+	for k, v := range from_fields {
+		if !reflect.DeepEqual(v, to_fields[k]) {
+			changes[k] = ManifestChange{v, to_fields[k]}
+		}
+	}
+
+	for k, v := range to_fields {
+		if _, ok := from_fields[k]; !ok {
+			changes[k] = ManifestChange{nil, v}
+		}
+	}
+
+	return changes, nil
+}
+
+func manifestFields(manifest *dsapid.ManifestResource) (fields map[string]interface{}, err error) {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &fields)
+
+	return fields, err
+}
+
+// baseRevision captures the persisted state of a manifest without history.
+func baseRevision(data []byte) (*ManifestRevision, error) {
+	manifest := new(dsapid.ManifestResource)
+
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, err
+	}
+
+	created_at := manifest.UpdatedAt
+	if created_at.IsZero() {
+		created_at = manifest.PublishedAt
+	}
+
+	return &ManifestRevision{
+		Revision:  1,
+		CreatedAt: created_at,
+		Manifest:  manifest,
+	}, nil
+}
+
+func (me *filesystemManifestStorage) Revisions(id string) ([]*ManifestRevision, error) {
+	revisions := make([]*ManifestRevision, 0)
+
+	numbers, err := me.revisionNumbers(id)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, n := range numbers {
+		revision, err := me.Revision(id, n)
+		if err != nil {
+			return nil, err
+		}
+
+		revisions = append(revisions, revision)
+	}
+
+	return revisions, nil
+}
+
+func (me *filesystemManifestStorage) Revision(id string, n int) (*ManifestRevision, error) {
+	data, err := ioutil.ReadFile(me.revisionFilename(id, n))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrStorageItemNotFound
+		}
+
+		return nil, err
+	}
+
+	revision := new(ManifestRevision)
+
+	if err := json.Unmarshal(data, revision); err != nil {
+		return nil, err
+	}
+
+	return revision, nil
+}
+
+func (me *filesystemManifestStorage) importRevisions(id string, revisions []*ManifestRevision) error {
+	me.revisionLock.Lock()
+	defer me.revisionLock.Unlock()
+
+	for _, revision := range revisions {
+		if err := me.saveRevision(id, revision); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// revisionNumbers returns the stored revisions of id in ascending order.
+func (me *filesystemManifestStorage) revisionNumbers(id string) ([]int, error) {
+	items, err := ioutil.ReadDir(path.Join(me.basedir, id, defaultRevisionDirname))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	var numbers []int
+
+	for _, item := range items {
+		if n, err := strconv.Atoi(strings.TrimSuffix(item.Name(), ".json")); err == nil && strings.HasSuffix(item.Name(), ".json") {
+			numbers = append(numbers, n)
+		}
+	}
+
+	sort.Ints(numbers)
+
+	return numbers, nil
+}
+
+func (me *filesystemManifestStorage) revisionFilename(id string, n int) string {
+	return path.Join(me.basedir, id, defaultRevisionDirname, fmt.Sprintf("%d.json", n))
+}
+
+func (me *filesystemManifestStorage) saveRevision(id string, revision *ManifestRevision) error {
+	if err := os.MkdirAll(path.Join(me.basedir, id, defaultRevisionDirname), 0770); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(revision, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(me.revisionFilename(id, revision.Revision), data, 0666)
+}
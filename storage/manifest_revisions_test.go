@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"github.com/MerlinDMC/dsapid"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func testManifestRevisions(t *testing.T, storage ManifestStorage) {
+	m := newTestManifest("base", time.Now())
+	storage.Add(m.Uuid, m)
+
+	if revisions, err := storage.Revisions(m.Uuid); err != nil || len(revisions) != 0 {
+		t.Fatalf("should not keep revisions before the first update but got %d (%v)", len(revisions), err)
+	}
+
+	m.State = dsapid.ManifestStateDisabled
+	storage.Update(m.Uuid, m, RevisionAuthor{Uuid: "admin", Name: "admin"})
+
+	m.Description = "changed"
+	storage.Update(m.Uuid, m, RevisionAuthor{Uuid: "admin", Name: "admin"})
+
+	revisions, err := storage.Revisions(m.Uuid)
+	if err != nil || len(revisions) != 3 {
+		t.Fatalf("should keep 3 revisions but got %d (%v)", len(revisions), err)
+	}
+
+	for i, revision := range revisions {
+		if revision.Revision != i+1 {
+			t.Errorf("should number revisions in order but got %d at %d", revision.Revision, i)
+		}
+	}
+
+	if revisions[0].Manifest.State != dsapid.ManifestStateActive || revisions[0].Author.Uuid != "" {
+		t.Errorf("should keep the state from before the first update as revision 1")
+	}
+
+	if revisions[2].Author.Name != "admin" || revisions[2].Manifest.Description != "changed" {
+		t.Errorf("should record author and state of revision 3")
+	}
+
+	changes, err := DiffManifests(revisions[0].Manifest, revisions[2].Manifest)
+	if err != nil {
+		t.Fatalf("can't diff revisions: %s", err)
+	}
+
+	if _, ok := changes["state"]; !ok {
+		t.Errorf("should report changed state but got %v", changes)
+	}
+
+	if _, ok := changes["name"]; ok {
+		t.Error("should not report unchanged name")
+	}
+
+	if _, err := storage.Revision(m.Uuid, 4); err != ErrStorageItemNotFound {
+		t.Errorf("should not find revision 4 but got %v", err)
+	}
+
+	storage.Delete(m.Uuid)
+
+	if revisions, _ := storage.Revisions(m.Uuid); len(revisions) != 0 {
+		t.Error("should delete revisions with the manifest")
+	}
+}
+
+func TestManifestStorageRevisions(t *testing.T) {
+	storage, basedir := newTestManifestStorage(t)
+	defer os.RemoveAll(basedir)
+
+	testManifestRevisions(t, storage)
+}
+
+func TestBoltStorageRevisions(t *testing.T) {
+	basedir, err := ioutil.TempDir("", "dsapid-bolt")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %s", err)
+	}
+	defer os.RemoveAll(basedir)
+
+	db, err := OpenBoltDB(path.Join(basedir, "dsapid.db"))
+	if err != nil {
+		t.Fatalf("can't open database: %s", err)
+	}
+	defer db.Close()
+
+	storage, err := NewBoltManifestStorage(db, basedir, nil)
+	if err != nil {
+		t.Fatalf("can't open manifest storage: %s", err)
+	}
+
+	testManifestRevisions(t, storage)
+}
@@ -1,177 +1,349 @@
 package storage
 
 import (
+	"bytes"
 	"encoding/json"
 	"github.com/MerlinDMC/dsapid"
 	"github.com/pborman/uuid"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
-	"sort"
+	"sync"
+	"time"
 )
 
 const (
 	defaultManifestFilename string = "manifest.json"
 )
 
+// ManifestStorage keeps the manifests of all images. The manifests returned
+// by Get, GetOK, List, Filter and Search are shared with concurrent readers
+// and must not be modified; change a CopyManifest and pass it to Update.
 type ManifestStorage interface {
 	Add(string, *dsapid.ManifestResource) error
-	Update(string, *dsapid.ManifestResource) error
+	// Insert is Add for a new image; it fails with ErrStorageItemExists if
+	// the id is stored already so concurrent uploads and syncs of the same
+	// image can't replace each other.
+	Insert(string, *dsapid.ManifestResource) error
+	// Update stores the previous state as a revision. Like Add it moves
+	// files written by CreateFile into the blob backend.
+	Update(string, *dsapid.ManifestResource, RevisionAuthor) error
 	Delete(string)
-	Reload()
+	Reload() error
 	Get(string) *dsapid.ManifestResource
 	GetOK(string) (*dsapid.ManifestResource, bool)
 	List() chan *dsapid.ManifestResource
 	Filter(...ManifestFilter) chan *dsapid.ManifestResource
+	Search(string, func(string) string) []*dsapid.ManifestResource
+	Generation() (uint64, time.Time)
 	ManifestPath(*dsapid.ManifestResource) string
-	FilePath(*dsapid.ManifestResource, *dsapid.ManifestFileResource) string
+	OpenFile(*dsapid.ManifestResource, *dsapid.ManifestFileResource) (BlobReader, error)
+	// CreateFile writes a new file which Add moves into the blob backend
+	CreateFile(*dsapid.ManifestResource, *dsapid.ManifestFileResource) (io.WriteCloser, error)
+	OpenIcon(*dsapid.ManifestResource) (BlobReader, error)
+	SaveIcon(*dsapid.ManifestResource, []byte) error
+	RemoveIcon(*dsapid.ManifestResource) error
+	CollectGarbage(GarbageOptions) (*GarbageReport, error)
+	Revisions(string) ([]*ManifestRevision, error)
+	Revision(string, int) (*ManifestRevision, error)
 }
 
 type filesystemManifestStorage struct {
-	basedir string
+	manifestCache
+	manifestFiles
 
-	manifests map[string]*dsapid.ManifestResource
-	byDate    []*dsapid.ManifestResource
+	// revisionLock serializes updates so revisions are numbered in order
+	revisionLock sync.Mutex
 }
 
-type ManifestFilter func(*dsapid.ManifestResource) bool
+// ManifestFilter selects manifests. Filters created by the FilterManifest
+// functions are planned against the secondary indexes where possible.
+type ManifestFilter interface {
+	Match(*dsapid.ManifestResource) bool
+}
+
+type ManifestFilterFunc func(*dsapid.ManifestResource) bool
+
+func (f ManifestFilterFunc) Match(manifest *dsapid.ManifestResource) bool {
+	return f(manifest)
+}
+
+// indexedFilter narrows a query down to the manifests whose field carries
+// one of values before Match is applied.
+type indexedFilter struct {
+	ManifestFilterFunc
+
+	field  string
+	values []string
+	prefix bool
+}
 
 type ManifestsByPublishedAt []*dsapid.ManifestResource
 
 func (t ManifestsByPublishedAt) Len() int      { return len(t) }
 func (t ManifestsByPublishedAt) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
 func (t ManifestsByPublishedAt) Less(i, j int) bool {
-	return t[i].PublishedAt.Unix() > t[j].PublishedAt.Unix()
+	return manifestBefore(t[i], t[j])
 }
 
-func NewManifestStorage(basedir string) ManifestStorage {
-	storage := new(filesystemManifestStorage)
+// manifestBefore orders newer manifests first. Manifests published within
+// the same second are ordered by uuid so paging through them is stable.
+func manifestBefore(a, b *dsapid.ManifestResource) bool {
+	if a.PublishedAt.Unix() != b.PublishedAt.Unix() {
+		return a.PublishedAt.Unix() > b.PublishedAt.Unix()
+	}
 
-	storage.basedir = basedir
+	return a.Uuid < b.Uuid
+}
 
-	storage.manifests = make(map[string]*dsapid.ManifestResource)
-	storage.byDate = make([]*dsapid.ManifestResource, 0)
+// NewManifestStorage keeps the manifests as JSON files inside basedir. The
+// image files go to backend or below basedir if backend is nil.
+func NewManifestStorage(basedir string, backend BlobBackend) ManifestStorage {
+	storage := new(filesystemManifestStorage)
 
-	storage.load()
+	storage.manifestFiles = newManifestFiles(basedir, backend)
+	storage.swap(storage.load())
 
 	return storage
 }
 
 func (me *filesystemManifestStorage) Add(id string, manifest *dsapid.ManifestResource) error {
-	os.MkdirAll(path.Join(me.basedir, id), 0770)
+	return me.add(id, manifest, true)
+}
 
-	if data, err := json.MarshalIndent(manifest, "", "  "); err == nil {
-		if err = ioutil.WriteFile(path.Join(me.basedir, id, defaultManifestFilename), data, 0666); err != nil {
-			return err
-		}
+func (me *filesystemManifestStorage) Insert(id string, manifest *dsapid.ManifestResource) error {
+	return me.add(id, manifest, false)
+}
+
+func (me *filesystemManifestStorage) add(id string, manifest *dsapid.ManifestResource, replace bool) error {
+	if err := os.MkdirAll(path.Join(me.basedir, id), 0770); err != nil {
+		return err
 	}
 
-	me.add(id, manifest)
+	me.blobs.lock.Lock()
+	defer me.blobs.lock.Unlock()
+
+	if _, ok := me.GetOK(id); ok && !replace {
+		return ErrStorageItemExists
+	}
+
+	if err := me.ingest(manifest); err != nil {
+		return err
+	}
+
+	manifest.UpdatedAt = time.Now()
+
+	if err := me.save(id, manifest); err != nil {
+		return err
+	}
+
+	me.blobs.Release(me.put(id, manifest))
 
 	return nil
 }
 
-func (me *filesystemManifestStorage) Update(id string, manifest *dsapid.ManifestResource) error {
-	if data, err := json.MarshalIndent(manifest, "", "  "); err == nil {
-		if err = ioutil.WriteFile(path.Join(me.basedir, id, defaultManifestFilename), data, 0666); err != nil {
-			return err
+func (me *filesystemManifestStorage) Update(id string, manifest *dsapid.ManifestResource, author RevisionAuthor) error {
+	me.revisionLock.Lock()
+	defer me.revisionLock.Unlock()
+
+	numbers, err := me.revisionNumbers(id)
+	if err != nil {
+		return err
+	}
+
+	last := 0
+
+	if len(numbers) > 0 {
+		last = numbers[len(numbers)-1]
+	} else if data, err := ioutil.ReadFile(path.Join(me.basedir, id, defaultManifestFilename)); err == nil {
+		// keep the state from before the first update
+		if base, err := baseRevision(data); err == nil {
+			if err := me.saveRevision(id, base); err != nil {
+				return err
+			}
+
+			last = base.Revision
 		}
 	}
 
-	return nil
-}
+	me.blobs.lock.Lock()
+	defer me.blobs.lock.Unlock()
 
-func (me *filesystemManifestStorage) Delete(id string) {
-	os.RemoveAll(path.Join(me.basedir, id))
+	if err := me.ingest(manifest); err != nil {
+		return err
+	}
 
-	me.delete(id)
-}
+	manifest.UpdatedAt = time.Now()
 
-func (me *filesystemManifestStorage) Reload() {
-	for k := range me.manifests {
-		delete(me.manifests, k)
+	if err := me.save(id, manifest); err != nil {
+		return err
 	}
 
-	me.byDate = make([]*dsapid.ManifestResource, 0)
+	me.blobs.Release(me.put(id, manifest))
 
-	me.load()
+	return me.saveRevision(id, &ManifestRevision{
+		Revision:  last + 1,
+		CreatedAt: manifest.UpdatedAt,
+		Author:    author,
+		Manifest:  manifest,
+	})
 }
 
-func (me *filesystemManifestStorage) Get(id string) *dsapid.ManifestResource {
-	return me.manifests[id]
+func (me *filesystemManifestStorage) Delete(id string) {
+	me.blobs.lock.Lock()
+	defer me.blobs.lock.Unlock()
+
+	os.RemoveAll(path.Join(me.basedir, id))
+
+	me.blobs.Release(me.remove(id))
 }
 
-func (me *filesystemManifestStorage) GetOK(id string) (*dsapid.ManifestResource, bool) {
-	v, ok := me.manifests[id]
+// Reload rebuilds the index from disk. Writers are held off meanwhile so a
+// manifest stored during the reload can't be missing from the new index.
+func (me *filesystemManifestStorage) Reload() error {
+	me.blobs.lock.Lock()
+	defer me.blobs.lock.Unlock()
+
+	me.swap(me.load())
 
-	return v, ok
+	return nil
 }
 
-func (me *filesystemManifestStorage) List() (c chan *dsapid.ManifestResource) {
-	c = make(chan *dsapid.ManifestResource)
+// reloadManifest picks up changes made to a single manifest directory from
+// outside. A new or changed manifest is only taken over once all of its
+// files are complete. Like the writers it holds blobs.lock throughout so it
+// never races an Add, Update or Delete of the same manifest.
+func (me *filesystemManifestStorage) reloadManifest(id string) error {
+	me.blobs.lock.Lock()
+	defer me.blobs.lock.Unlock()
+
+	data, err := ioutil.ReadFile(path.Join(me.basedir, id, defaultManifestFilename))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
 
-	go func() {
-		for _, item := range me.byDate {
-			c <- item
+		if _, ok := me.GetOK(id); ok {
+			me.blobs.Release(me.remove(id))
 		}
 
-		close(c)
-	}()
+		return nil
+	}
+
+	// skip the manifests written by dsapid itself
+	if current, ok := me.GetOK(id); ok {
+		if current_data, err := json.MarshalIndent(current, "", "  "); err == nil && bytes.Equal(current_data, data) {
+			return nil
+		}
+	}
 
-	return
-}
+	manifest := new(dsapid.ManifestResource)
 
-func (me *filesystemManifestStorage) Filter(flist ...ManifestFilter) (c chan *dsapid.ManifestResource) {
-	c = make(chan *dsapid.ManifestResource)
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return err
+	}
 
-	go func() {
-	nextItem:
-		for item := range me.List() {
-			for _, f := range flist {
-				if !f(item) {
-					continue nextItem
-				}
-			}
+	if uuid.Parse(id).String() != manifest.Uuid {
+		return ErrManifestIncomplete
+	}
 
-			c <- item
+	for i := range manifest.Files {
+		if !me.fileComplete(manifest, &manifest.Files[i]) {
+			return ErrManifestIncomplete
 		}
+	}
 
-		close(c)
-	}()
+	me.blobs.Release(me.put(id, manifest))
 
-	return
+	return nil
 }
 
-func (me *filesystemManifestStorage) ManifestPath(manifest *dsapid.ManifestResource) string {
-	return path.Join(me.basedir, manifest.Uuid)
+// CopyManifest returns a copy of manifest which can be changed without
+// affecting the stored manifest.
+func CopyManifest(manifest *dsapid.ManifestResource) *dsapid.ManifestResource {
+	m := *manifest
+
+	m.Acl = append([]string(nil), manifest.Acl...)
+	m.BillingTags = append([]string(nil), manifest.BillingTags...)
+	m.Channels = append([]string(nil), manifest.Channels...)
+	m.Files = append([]dsapid.ManifestFileResource(nil), manifest.Files...)
+
+	m.Requirements = copyTable(manifest.Requirements)
+	m.Users = copyTables(manifest.Users)
+	m.Tags = copyTable(manifest.Tags)
+	m.Options = copyTable(manifest.Options)
+	m.MetadataInfo = copyTables(manifest.MetadataInfo)
+	m.BuilderInfo = copyTable(manifest.BuilderInfo)
+	m.SyncInfo = copyTable(manifest.SyncInfo)
+
+	return &m
 }
 
-func (me *filesystemManifestStorage) FilePath(manifest *dsapid.ManifestResource, file *dsapid.ManifestFileResource) string {
-	return path.Join(me.basedir, manifest.Uuid, path.Base(file.Path))
-}
+func copyTable(t dsapid.Table) dsapid.Table {
+	if t == nil {
+		return nil
+	}
 
-func (me *filesystemManifestStorage) add(id string, manifest *dsapid.ManifestResource) {
-	me.manifests[id] = manifest
-	me.byDate = append(me.byDate, manifest)
+	c := make(dsapid.Table, len(t))
+
+	for k, v := range t {
+		c[k] = copyValue(v)
+	}
 
-	sort.Sort(ManifestsByPublishedAt(me.byDate))
+	return c
 }
 
-func (me *filesystemManifestStorage) delete(id string) {
-	for i, m := range me.byDate {
-		if m.Uuid == id {
-			me.byDate = append(me.byDate[:i], me.byDate[i+1:]...)
+func copyTables(t []dsapid.Table) []dsapid.Table {
+	if t == nil {
+		return nil
+	}
+
+	c := make([]dsapid.Table, len(t))
+
+	for i, v := range t {
+		c[i] = copyTable(v)
+	}
+
+	return c
+}
 
-			break
+// copyValue copies the objects and arrays a decoded JSON value is made of.
+func copyValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case dsapid.Table:
+		return copyTable(t)
+	case map[string]interface{}:
+		return map[string]interface{}(copyTable(dsapid.Table(t)))
+	case []dsapid.Table:
+		return copyTables(t)
+	case []interface{}:
+		c := make([]interface{}, len(t))
+
+		for i, item := range t {
+			c[i] = copyValue(item)
 		}
+
+		return c
+	case []string:
+		return append([]string(nil), t...)
 	}
 
-	if _, ok := me.manifests[id]; ok {
-		delete(me.manifests, id)
+	return v
+}
+
+func (me *filesystemManifestStorage) save(id string, manifest *dsapid.ManifestResource) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
 	}
+
+	return writeFileAtomic(path.Join(me.basedir, id, defaultManifestFilename), data, 0666)
 }
 
-func (me *filesystemManifestStorage) load() {
+func (me *filesystemManifestStorage) load() *manifestIndex {
+	index := newManifestIndex()
+
 	if items, err := ioutil.ReadDir(me.basedir); err == nil {
 		for _, item := range items {
 			if item.IsDir() {
@@ -184,11 +356,15 @@ func (me *filesystemManifestStorage) load() {
 						json.Unmarshal(data, &manifest)
 
 						if id.String() == manifest.Uuid {
-							me.add(manifest.Uuid, &manifest)
+							index.insert(&manifest)
 						}
 					}
 				}
 			}
 		}
 	}
+
+	index.sort()
+
+	return index
 }
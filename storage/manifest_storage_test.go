@@ -0,0 +1,376 @@
+package storage
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/MerlinDMC/dsapid"
+	"github.com/pborman/uuid"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestManifestStorage(t *testing.T) (ManifestStorage, string) {
+	basedir, err := ioutil.TempDir("", "dsapid-manifests")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %s", err)
+	}
+
+	return NewManifestStorage(basedir, nil), basedir
+}
+
+func newTestManifest(name string, published time.Time) *dsapid.ManifestResource {
+	return &dsapid.ManifestResource{
+		Uuid:        uuid.New(),
+		Name:        name,
+		Version:     "1.0.0",
+		State:       dsapid.ManifestStateActive,
+		Public:      true,
+		Type:        dsapid.ManifestTypeZone,
+		Os:          "smartos",
+		PublishedAt: published,
+	}
+}
+
+func TestManifestStorageAddUpdate(t *testing.T) {
+	storage, basedir := newTestManifestStorage(t)
+	defer os.RemoveAll(basedir)
+
+	m1 := newTestManifest("base", time.Now().Add(-time.Hour))
+	m2 := newTestManifest("base64", time.Now())
+
+	storage.Add(m1.Uuid, m1)
+	storage.Add(m2.Uuid, m2)
+	storage.Update(m1.Uuid, m1, RevisionAuthor{})
+
+	var listed []*dsapid.ManifestResource
+
+	for m := range storage.List() {
+		listed = append(listed, m)
+	}
+
+	if len(listed) != 2 {
+		t.Fatalf("should list 2 manifests but got %d", len(listed))
+	}
+
+	if listed[0].Uuid != m2.Uuid {
+		t.Errorf("should list newest manifest first but got %s", listed[0].Name)
+	}
+}
+
+func TestManifestStorageReloadIsAtomic(t *testing.T) {
+	const count = 20
+
+	storage, basedir := newTestManifestStorage(t)
+	defer os.RemoveAll(basedir)
+
+	for i := 0; i < count; i++ {
+		m := newTestManifest("base", time.Now())
+
+		storage.Add(m.Uuid, m)
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				n := 0
+				for range storage.List() {
+					n++
+				}
+
+				if n < count {
+					t.Errorf("should always list at least %d manifests but got %d", count, n)
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < 10; i++ {
+			m := newTestManifest("extra", time.Now())
+
+			storage.Add(m.Uuid, m)
+			storage.Delete(m.Uuid)
+		}
+	}()
+
+	for i := 0; i < 10; i++ {
+		storage.Reload()
+	}
+
+	close(stop)
+	wg.Wait()
+
+	storage.Reload()
+
+	n := 0
+	for range storage.List() {
+		n++
+	}
+
+	if n != count {
+		t.Errorf("should have %d manifests after reload but got %d", count, n)
+	}
+}
+
+func TestManifestStorageReloadKeepsConcurrentAdds(t *testing.T) {
+	const count = 50
+
+	storage, basedir := newTestManifestStorage(t)
+	defer os.RemoveAll(basedir)
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for i := 0; i < count; i++ {
+			m := newTestManifest("base", time.Now())
+
+			if err := storage.Add(m.Uuid, m); err != nil {
+				t.Errorf("can't add manifest: %s", err)
+			}
+		}
+	}()
+
+	for reloading := true; reloading; {
+		select {
+		case <-done:
+			reloading = false
+		default:
+			storage.Reload()
+		}
+	}
+
+	n := 0
+	for range storage.List() {
+		n++
+	}
+
+	if n != count {
+		t.Errorf("should keep all %d manifests added during reloads but got %d", count, n)
+	}
+}
+
+func TestManifestStorageDeduplicatesFiles(t *testing.T) {
+	storage, basedir := newTestManifestStorage(t)
+	defer os.RemoveAll(basedir)
+
+	var manifests []*dsapid.ManifestResource
+
+	for i := 0; i < 2; i++ {
+		m := newTestManifest("base", time.Now())
+		m.Files = []dsapid.ManifestFileResource{{
+			Path: "base-1.0.0.zfs.gz",
+			Size: 4,
+			Sha1: "a17c9aaa61e80a1bf71d0d850af4e5baa9800bbd",
+		}}
+
+		if f, err := storage.CreateFile(m, &m.Files[0]); err == nil {
+			f.Write([]byte("data"))
+			f.Close()
+		}
+
+		if err := storage.Add(m.Uuid, m); err != nil {
+			t.Fatalf("can't add manifest: %s", err)
+		}
+
+		manifests = append(manifests, m)
+	}
+
+	blob := path.Join(basedir, defaultBlobDirname, "sha1", "a1", manifests[0].Files[0].Sha1)
+
+	if _, err := os.Stat(blob); err != nil {
+		t.Fatalf("should have moved uploaded file into the blob store: %s", err)
+	}
+
+	for _, m := range manifests {
+		if _, err := os.Stat(path.Join(storage.ManifestPath(m), m.Files[0].Path)); !os.IsNotExist(err) {
+			t.Errorf("should not keep a copy of the uploaded file for %s", m.Uuid)
+		}
+
+		if f, err := storage.OpenFile(m, &m.Files[0]); err != nil {
+			t.Errorf("should open file of %s: %s", m.Uuid, err)
+		} else {
+			if data, _ := ioutil.ReadAll(f); string(data) != "data" {
+				t.Errorf("should read file content of %s but got %q", m.Uuid, data)
+			}
+
+			f.Close()
+		}
+	}
+
+	storage.Delete(manifests[0].Uuid)
+
+	if _, err := os.Stat(blob); err != nil {
+		t.Errorf("should keep blob while it is still referenced: %s", err)
+	}
+
+	storage.Delete(manifests[1].Uuid)
+
+	if _, err := os.Stat(blob); !os.IsNotExist(err) {
+		t.Error("should remove blob once it is no longer referenced")
+	}
+}
+
+func addTestFile(t *testing.T, storage ManifestStorage, manifest *dsapid.ManifestResource, name string, data string) {
+	sum := sha1.Sum([]byte(data))
+	file := dsapid.ManifestFileResource{
+		Path: name,
+		Size: int64(len(data)),
+		Sha1: hex.EncodeToString(sum[:]),
+	}
+
+	f, err := storage.CreateFile(manifest, &file)
+	if err != nil {
+		t.Fatalf("can't create file: %s", err)
+	}
+
+	f.Write([]byte(data))
+	f.Close()
+
+	manifest.Files = []dsapid.ManifestFileResource{file}
+}
+
+func TestManifestStorageRefcountsInPlaceUpdates(t *testing.T) {
+	storage, basedir := newTestManifestStorage(t)
+	defer os.RemoveAll(basedir)
+
+	blobExists := func(file dsapid.ManifestFileResource) bool {
+		_, err := os.Stat(path.Join(basedir, defaultBlobDirname, "sha1", file.Sha1[:2], file.Sha1))
+
+		return err == nil
+	}
+
+	b := newTestManifest("b", time.Now())
+	addTestFile(t, storage, b, "b.zfs", "shared")
+	storage.Add(b.Uuid, b)
+
+	a := newTestManifest("a", time.Now())
+	addTestFile(t, storage, a, "a.zfs", "own")
+	storage.Add(a.Uuid, a)
+
+	own := a.Files[0]
+
+	// the stored manifest itself gets the file b holds as well
+	stored := storage.Get(a.Uuid)
+	addTestFile(t, storage, stored, "a.zfs", "shared")
+
+	if err := storage.Update(a.Uuid, stored, RevisionAuthor{}); err != nil {
+		t.Fatalf("can't update manifest: %s", err)
+	}
+
+	if blobExists(own) {
+		t.Error("should release the replaced file")
+	}
+
+	storage.Delete(a.Uuid)
+
+	if !blobExists(b.Files[0]) {
+		t.Fatal("should keep the file shared with b after deleting a")
+	}
+
+	if f, err := storage.OpenFile(b, &b.Files[0]); err != nil {
+		t.Errorf("should open file of b: %s", err)
+	} else {
+		f.Close()
+	}
+
+	storage.Delete(b.Uuid)
+
+	if blobExists(b.Files[0]) {
+		t.Error("should release the file once no manifest references it")
+	}
+}
+
+func TestCopyManifestIsDeep(t *testing.T) {
+	manifest := newTestManifest("base", time.Now())
+	manifest.Acl = []string{"acl"}
+	manifest.BillingTags = []string{"billing"}
+	manifest.Channels = []string{"release"}
+	manifest.Files = []dsapid.ManifestFileResource{{Path: "base.zfs"}}
+	manifest.Requirements = dsapid.Table{"networks": []interface{}{map[string]interface{}{"name": "net0"}}}
+	manifest.Users = []dsapid.Table{{"name": "root"}}
+	manifest.Tags = dsapid.Table{"role": "db", "nested": map[string]interface{}{"key": "value"}}
+	manifest.Options = dsapid.Table{"cpu_type": "host"}
+	manifest.MetadataInfo = []dsapid.Table{{"name": "key"}}
+	manifest.BuilderInfo = dsapid.Table{"builder": "old"}
+	manifest.SyncInfo = dsapid.Table{"from": "old"}
+
+	c := CopyManifest(manifest)
+
+	c.Acl[0] = "changed"
+	c.BillingTags[0] = "changed"
+	c.Channels[0] = "changed"
+	c.Files[0].Path = "changed"
+	c.Requirements["networks"].([]interface{})[0].(map[string]interface{})["name"] = "changed"
+	c.Users[0]["name"] = "changed"
+	c.Tags["role"] = "changed"
+	c.Tags["nested"].(map[string]interface{})["key"] = "changed"
+	c.Options["cpu_type"] = "changed"
+	c.MetadataInfo[0]["name"] = "changed"
+	c.BuilderInfo["builder"] = "changed"
+	c.SyncInfo["from"] = "changed"
+
+	expected := newTestManifest("base", manifest.PublishedAt)
+	expected.Uuid = manifest.Uuid
+	expected.Acl = []string{"acl"}
+	expected.BillingTags = []string{"billing"}
+	expected.Channels = []string{"release"}
+	expected.Files = []dsapid.ManifestFileResource{{Path: "base.zfs"}}
+	expected.Requirements = dsapid.Table{"networks": []interface{}{map[string]interface{}{"name": "net0"}}}
+	expected.Users = []dsapid.Table{{"name": "root"}}
+	expected.Tags = dsapid.Table{"role": "db", "nested": map[string]interface{}{"key": "value"}}
+	expected.Options = dsapid.Table{"cpu_type": "host"}
+	expected.MetadataInfo = []dsapid.Table{{"name": "key"}}
+	expected.BuilderInfo = dsapid.Table{"builder": "old"}
+	expected.SyncInfo = dsapid.Table{"from": "old"}
+
+	if !reflect.DeepEqual(manifest, expected) {
+		t.Errorf("changing the copy changed the original: %+v", manifest)
+	}
+}
+
+func TestManifestStorageInsert(t *testing.T) {
+	storage, basedir := newTestManifestStorage(t)
+	defer os.RemoveAll(basedir)
+
+	manifest := newTestManifest("base", time.Now())
+
+	if err := storage.Insert(manifest.Uuid, manifest); err != nil {
+		t.Fatalf("insert failed: %s", err)
+	}
+
+	other := newTestManifest("other", time.Now())
+	other.Uuid = manifest.Uuid
+
+	if err := storage.Insert(other.Uuid, other); err != ErrStorageItemExists {
+		t.Errorf("expected ErrStorageItemExists but got %v", err)
+	}
+
+	if storage.Get(manifest.Uuid).Name != "base" {
+		t.Error("a failed insert should keep the stored manifest")
+	}
+}
@@ -0,0 +1,114 @@
+package storage
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// ManifestWatcher keeps a filesystem ManifestStorage in sync with manifest
+// directories added, changed or removed by hand inside its basedir.
+type ManifestWatcher interface {
+	Close() error
+}
+
+// NewManifestWatcher starts watching the basedir of manifests. Changes to a
+// manifest directory are applied once it saw no events for delay.
+func NewManifestWatcher(manifests ManifestStorage, delay time.Duration) (ManifestWatcher, error) {
+	storage, ok := manifests.(*filesystemManifestStorage)
+	if !ok {
+		return nil, ErrWatchNotSupported
+	}
+
+	return newManifestWatcher(storage, delay)
+}
+
+// manifestDebouncer collects the ids of changed manifest directories and
+// reloads each once it stopped changing. Incomplete directories are kept
+// pending until their next change. The id "" stands for a full reload.
+type manifestDebouncer struct {
+	storage *filesystemManifestStorage
+	delay   time.Duration
+
+	lock    sync.Mutex
+	pending map[string]time.Time
+	s_stop  chan struct{}
+}
+
+func newManifestDebouncer(storage *filesystemManifestStorage, delay time.Duration) *manifestDebouncer {
+	debouncer := &manifestDebouncer{
+		storage: storage,
+		delay:   delay,
+		pending: make(map[string]time.Time),
+		s_stop:  make(chan struct{}),
+	}
+
+	go debouncer.run()
+
+	return debouncer
+}
+
+func (me *manifestDebouncer) touch(id string) {
+	me.lock.Lock()
+	defer me.lock.Unlock()
+
+	me.pending[id] = time.Now()
+}
+
+func (me *manifestDebouncer) run() {
+	interval := me.delay / 2
+	if interval <= 0 {
+		interval = time.Millisecond
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-me.s_stop:
+			return
+		case now := <-ticker.C:
+			var due []string
+
+			me.lock.Lock()
+			for id, t := range me.pending {
+				if now.Sub(t) >= me.delay {
+					due = append(due, id)
+					delete(me.pending, id)
+				}
+			}
+			me.lock.Unlock()
+
+			for _, id := range due {
+				me.reload(id)
+			}
+		}
+	}
+}
+
+func (me *manifestDebouncer) reload(id string) {
+	if id == "" {
+		if err := me.storage.Reload(); err != nil {
+			log.Errorf("can't reload manifests: %s", err)
+		}
+
+		return
+	}
+
+	switch err := me.storage.reloadManifest(id); err {
+	case nil:
+	case ErrManifestIncomplete:
+		log.WithFields(log.Fields{
+			"image_uuid": id,
+		}).Debug("manifest not complete yet")
+	default:
+		log.WithFields(log.Fields{
+			"image_uuid": id,
+		}).Errorf("can't reload manifest: %s", err)
+	}
+}
+
+func (me *manifestDebouncer) stop() {
+	close(me.s_stop)
+}
@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"github.com/pborman/uuid"
+	"golang.org/x/sys/unix"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+const (
+	inotifyBasedirMask  uint32 = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MOVED_TO | unix.IN_MOVED_FROM | unix.IN_ONLYDIR
+	inotifyManifestMask uint32 = unix.IN_CREATE | unix.IN_CLOSE_WRITE | unix.IN_DELETE | unix.IN_MOVED_TO | unix.IN_MOVED_FROM | unix.IN_ONLYDIR
+)
+
+type inotifyManifestWatcher struct {
+	*manifestDebouncer
+
+	fd   int
+	file *os.File
+
+	lock sync.Mutex
+	// manifest id by watch descriptor; the basedir maps to ""
+	watches map[int]string
+}
+
+func newManifestWatcher(storage *filesystemManifestStorage, delay time.Duration) (ManifestWatcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+
+	watcher := &inotifyManifestWatcher{
+		fd: fd,
+		// a non-blocking fd is served by the runtime poller so Close
+		// interrupts a pending Read
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		watches: make(map[int]string),
+	}
+
+	if err := watcher.addWatch("", storage.basedir, inotifyBasedirMask); err != nil {
+		watcher.file.Close()
+
+		return nil, err
+	}
+
+	watcher.manifestDebouncer = newManifestDebouncer(storage, delay)
+
+	if items, err := ioutil.ReadDir(storage.basedir); err == nil {
+		for _, item := range items {
+			if item.IsDir() && uuid.Parse(item.Name()) != nil {
+				watcher.addWatch(item.Name(), path.Join(storage.basedir, item.Name()), inotifyManifestMask)
+			}
+		}
+	}
+
+	go watcher.readEvents()
+
+	return watcher, nil
+}
+
+func (me *inotifyManifestWatcher) Close() error {
+	me.stop()
+
+	return me.file.Close()
+}
+
+func (me *inotifyManifestWatcher) addWatch(id string, dirname string, mask uint32) error {
+	wd, err := unix.InotifyAddWatch(me.fd, dirname, mask)
+	if err != nil {
+		return err
+	}
+
+	me.lock.Lock()
+	defer me.lock.Unlock()
+
+	me.watches[wd] = id
+
+	return nil
+}
+
+func (me *inotifyManifestWatcher) readEvents() {
+	var buf [unix.SizeofInotifyEvent * 4096]byte
+
+	for {
+		n, err := me.file.Read(buf[:])
+		if err != nil {
+			return
+		}
+
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			name := ""
+
+			if event.Len > 0 {
+				raw := buf[offset+unix.SizeofInotifyEvent : offset+unix.SizeofInotifyEvent+int(event.Len)]
+
+				for i, c := range raw {
+					if c == 0 {
+						raw = raw[:i]
+						break
+					}
+				}
+
+				name = string(raw)
+			}
+
+			me.handleEvent(int(event.Wd), event.Mask, name)
+
+			offset += unix.SizeofInotifyEvent + int(event.Len)
+		}
+	}
+}
+
+func (me *inotifyManifestWatcher) handleEvent(wd int, mask uint32, name string) {
+	if mask&unix.IN_Q_OVERFLOW != 0 {
+		// events got lost, fall back to a full rescan without blocking
+		// the reader
+		me.touch("")
+
+		return
+	}
+
+	me.lock.Lock()
+	id, ok := me.watches[wd]
+
+	if mask&unix.IN_IGNORED != 0 {
+		delete(me.watches, wd)
+	}
+	me.lock.Unlock()
+
+	if !ok || mask&unix.IN_IGNORED != 0 {
+		return
+	}
+
+	if id != "" {
+		me.touch(id)
+
+		return
+	}
+
+	if mask&unix.IN_ISDIR == 0 || uuid.Parse(name) == nil {
+		return
+	}
+
+	if mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 {
+		me.addWatch(name, path.Join(me.storage.basedir, name), inotifyManifestMask)
+	}
+
+	// files may have been written before the watch was added
+	me.touch(name)
+}
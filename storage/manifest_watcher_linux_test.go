@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"encoding/json"
+	"github.com/MerlinDMC/dsapid"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func waitFor(cond func() bool) bool {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if cond() {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestManifestWatcher(t *testing.T) {
+	storage, basedir := newTestManifestStorage(t)
+	defer os.RemoveAll(basedir)
+
+	watcher, err := NewManifestWatcher(storage, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("can't watch manifests: %s", err)
+	}
+	defer watcher.Close()
+
+	m := newTestManifest("dropped", time.Now())
+	m.Files = []dsapid.ManifestFileResource{{
+		Path: "dropped-1.0.0.zfs.gz",
+		Size: 4,
+	}}
+
+	dirname := path.Join(basedir, m.Uuid)
+	os.MkdirAll(dirname, 0770)
+
+	data, _ := json.Marshal(m)
+	ioutil.WriteFile(path.Join(dirname, defaultManifestFilename), data, 0666)
+	ioutil.WriteFile(path.Join(dirname, m.Files[0].Path), []byte("da"), 0666)
+
+	time.Sleep(200 * time.Millisecond)
+
+	if _, ok := storage.GetOK(m.Uuid); ok {
+		t.Fatal("should not pick up a manifest before its files are complete")
+	}
+
+	ioutil.WriteFile(path.Join(dirname, m.Files[0].Path), []byte("data"), 0666)
+
+	if !waitFor(func() bool { _, ok := storage.GetOK(m.Uuid); return ok }) {
+		t.Fatal("should pick up the manifest once its files are complete")
+	}
+
+	m.Name = "renamed"
+	data, _ = json.Marshal(m)
+	ioutil.WriteFile(path.Join(dirname, defaultManifestFilename), data, 0666)
+
+	if !waitFor(func() bool { return storage.Get(m.Uuid).Name == "renamed" }) {
+		t.Error("should pick up changes of the manifest")
+	}
+
+	os.RemoveAll(dirname)
+
+	if !waitFor(func() bool { _, ok := storage.GetOK(m.Uuid); return !ok }) {
+		t.Error("should drop the manifest once its directory is gone")
+	}
+}
+
+func TestManifestDebouncerRescans(t *testing.T) {
+	storage, basedir := newTestManifestStorage(t)
+	defer os.RemoveAll(basedir)
+
+	m := newTestManifest("rescanned", time.Now())
+
+	os.MkdirAll(path.Join(basedir, m.Uuid), 0770)
+	data, _ := json.Marshal(m)
+	ioutil.WriteFile(path.Join(basedir, m.Uuid, defaultManifestFilename), data, 0666)
+
+	debouncer := newManifestDebouncer(storage.(*filesystemManifestStorage), 10*time.Millisecond)
+	defer debouncer.stop()
+
+	// what an overflowing event queue asks for
+	debouncer.touch("")
+
+	if !waitFor(func() bool { _, ok := storage.GetOK(m.Uuid); return ok }) {
+		t.Error("should pick up manifests by a full reload")
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package storage
+
+import (
+	"time"
+)
+
+func newManifestWatcher(storage *filesystemManifestStorage, delay time.Duration) (ManifestWatcher, error) {
+	return nil, ErrWatchNotSupported
+}
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"github.com/MerlinDMC/dsapid"
+)
+
+// revisionImporter is implemented by storages which can take over the
+// revisions of a manifest as they are.
+type revisionImporter interface {
+	importRevisions(id string, revisions []*ManifestRevision) error
+}
+
+// MigrateManifests copies every manifest found in from into to. Both have to
+// use the same datadir and blob backend: files already in the backend are
+// shared while files still kept inside a manifest directory are moved into
+// the backend by Add. The revisions of a manifest are copied along.
+func MigrateManifests(to, from ManifestStorage) (count int, err error) {
+	// keep draining on errors so the List() goroutine can finish
+	for manifest := range from.List() {
+		if err != nil {
+			continue
+		}
+
+		if err = migrateManifest(to, from, manifest); err == nil {
+			count++
+		}
+	}
+
+	return count, err
+}
+
+func migrateManifest(to, from ManifestStorage, manifest *dsapid.ManifestResource) error {
+	revisions, err := from.Revisions(manifest.Uuid)
+	if err != nil {
+		return err
+	}
+
+	if err := to.Add(manifest.Uuid, manifest); err != nil {
+		return err
+	}
+
+	if importer, ok := to.(revisionImporter); ok && len(revisions) > 0 {
+		return importer.importRevisions(manifest.Uuid, revisions)
+	}
+
+	return nil
+}
+
+// MigrateUsers copies every user found in from into to.
+func MigrateUsers(to, from UserStorage) (count int, err error) {
+	for id, user := range from.Dump() {
+		if err = to.Add(id, user); err != nil {
+			return count, err
+		}
+
+		count++
+	}
+
+	return count, nil
+}
@@ -0,0 +1,474 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	s3UnsignedPayload string = "UNSIGNED-PAYLOAD"
+	s3DefaultRegion   string = "us-east-1"
+
+	// files above the threshold are uploaded in parts of s3PartSize
+	s3MultipartThreshold int64 = 256 << 20
+	s3PartSize           int64 = 64 << 20
+)
+
+// S3Options configures a bucket on any S3 compatible object storage. Requests
+// use path-style addressing and are signed with AWS signature version 4.
+type S3Options struct {
+	Endpoint  string `json:"endpoint"`
+	Region    string `json:"region,omitempty"`
+	Bucket    string `json:"bucket"`
+	Prefix    string `json:"prefix,omitempty"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+}
+
+type s3BlobBackend struct {
+	opts     S3Options
+	endpoint *url.URL
+	client   *http.Client
+}
+
+type s3BlobReader struct {
+	backend *s3BlobBackend
+	key     string
+	size    int64
+	modTime time.Time
+
+	offset int64
+	body   io.ReadCloser
+}
+
+func NewS3BlobBackend(opts S3Options) (BlobBackend, error) {
+	endpoint, err := url.Parse(opts.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	if endpoint.Host == "" || opts.Bucket == "" {
+		return nil, fmt.Errorf("s3: endpoint and bucket are required")
+	}
+
+	if opts.Region == "" {
+		opts.Region = s3DefaultRegion
+	}
+
+	return &s3BlobBackend{
+		opts:     opts,
+		endpoint: endpoint,
+		client:   &http.Client{},
+	}, nil
+}
+
+func (me *s3BlobBackend) Open(key string) (BlobReader, error) {
+	res, err := me.do("HEAD", key, nil, nil, nil, s3UnsignedPayload)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, ErrStorageFileNotFound
+	} else if res.StatusCode != http.StatusOK {
+		return nil, me.errorf("HEAD", key, res)
+	}
+
+	modTime, _ := http.ParseTime(res.Header.Get("Last-Modified"))
+
+	return &s3BlobReader{
+		backend: me,
+		key:     key,
+		size:    res.ContentLength,
+		modTime: modTime,
+	}, nil
+}
+
+func (me *s3BlobBackend) Put(key string, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.Size() > s3MultipartThreshold {
+		err = me.putMultipart(key, f, info.Size())
+	} else {
+		_, err = me.putObject(key, nil, f, info.Size())
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(filename)
+}
+
+func (me *s3BlobBackend) Exists(key string) bool {
+	if res, err := me.do("HEAD", key, nil, nil, nil, s3UnsignedPayload); err == nil {
+		res.Body.Close()
+
+		return res.StatusCode == http.StatusOK
+	}
+
+	return false
+}
+
+func (me *s3BlobBackend) Remove(key string) error {
+	res, err := me.do("DELETE", key, nil, nil, nil, s3UnsignedPayload)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNotFound {
+		return me.errorf("DELETE", key, res)
+	}
+
+	return nil
+}
+
+func (me *s3BlobBackend) Walk(fn func(key string, size int64) error) error {
+	prefix := ""
+	if me.opts.Prefix != "" {
+		prefix = strings.Trim(me.opts.Prefix, "/") + "/"
+	}
+
+	query := url.Values{
+		"list-type": {"2"},
+		"prefix":    {prefix},
+	}
+
+	for {
+		var listing struct {
+			Contents []struct {
+				Key  string `xml:"Key"`
+				Size int64  `xml:"Size"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+
+		if err := me.list(query, &listing); err != nil {
+			return err
+		}
+
+		for _, object := range listing.Contents {
+			if err := fn(strings.TrimPrefix(object.Key, prefix), object.Size); err != nil {
+				return err
+			}
+		}
+
+		if !listing.IsTruncated || listing.NextContinuationToken == "" {
+			return nil
+		}
+
+		query.Set("continuation-token", listing.NextContinuationToken)
+	}
+}
+
+// list requests a bucket listing which is addressed without key and prefix.
+func (me *s3BlobBackend) list(query url.Values, result interface{}) error {
+	u := *me.endpoint
+	u.Path = "/" + me.opts.Bucket + "/"
+	u.RawQuery = strings.Replace(query.Encode(), "+", "%20", -1)
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	me.sign(req, s3UnsignedPayload, time.Now().UTC())
+
+	res, err := me.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return me.errorf("GET", "?list-type=2", res)
+	}
+
+	return xml.NewDecoder(res.Body).Decode(result)
+}
+
+func (me *s3BlobBackend) putObject(key string, query url.Values, body io.Reader, size int64) (string, error) {
+	res, err := me.do("PUT", key, query, nil, &sizedReader{body, size}, s3UnsignedPayload)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", me.errorf("PUT", key, res)
+	}
+
+	return res.Header.Get("ETag"), nil
+}
+
+func (me *s3BlobBackend) putMultipart(key string, f *os.File, size int64) error {
+	var initiated struct {
+		UploadId string `xml:"UploadId"`
+	}
+
+	if err := me.post(key, url.Values{"uploads": {""}}, nil, &initiated); err != nil {
+		return err
+	}
+
+	type part struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+
+	var complete struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}
+
+	for offset, number := int64(0), 1; offset < size; offset, number = offset+s3PartSize, number+1 {
+		length := s3PartSize
+		if offset+length > size {
+			length = size - offset
+		}
+
+		etag, err := me.putObject(key, url.Values{
+			"partNumber": {strconv.Itoa(number)},
+			"uploadId":   {initiated.UploadId},
+		}, io.NewSectionReader(f, offset, length), length)
+
+		if err != nil {
+			me.abortMultipart(key, initiated.UploadId)
+
+			return err
+		}
+
+		complete.Parts = append(complete.Parts, part{number, etag})
+	}
+
+	data, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+
+	if err := me.post(key, url.Values{"uploadId": {initiated.UploadId}}, data, nil); err != nil {
+		me.abortMultipart(key, initiated.UploadId)
+
+		return err
+	}
+
+	return nil
+}
+
+func (me *s3BlobBackend) abortMultipart(key string, upload_id string) {
+	if res, err := me.do("DELETE", key, url.Values{"uploadId": {upload_id}}, nil, nil, s3UnsignedPayload); err == nil {
+		res.Body.Close()
+	}
+}
+
+func (me *s3BlobBackend) post(key string, query url.Values, data []byte, result interface{}) error {
+	sum := sha256.Sum256(data)
+
+	res, err := me.do("POST", key, query, nil, &sizedReader{bytes.NewReader(data), int64(len(data))}, hex.EncodeToString(sum[:]))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return me.errorf("POST", key, res)
+	}
+
+	// CompleteMultipartUpload reports errors inside a 200 response
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if bytes.Contains(body, []byte("<Error>")) {
+		return fmt.Errorf("s3: POST %s: %s", key, body)
+	}
+
+	if result != nil {
+		return xml.Unmarshal(body, result)
+	}
+
+	return nil
+}
+
+func (me *s3BlobBackend) do(method string, key string, query url.Values, header http.Header, body *sizedReader, payload_hash string) (*http.Response, error) {
+	u := *me.endpoint
+	u.Path = "/" + path.Join(me.opts.Bucket, me.opts.Prefix, key)
+	u.RawQuery = strings.Replace(query.Encode(), "+", "%20", -1)
+
+	// an empty but non-nil body would be sent chunked which S3 rejects
+	var reader io.Reader
+	if body != nil && body.size > 0 {
+		reader = body.Reader
+	}
+
+	req, err := http.NewRequest(method, u.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if reader != nil {
+		req.ContentLength = body.size
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	me.sign(req, payload_hash, time.Now().UTC())
+
+	return me.client.Do(req)
+}
+
+// sign adds an AWS signature version 4 Authorization header to req.
+func (me *s3BlobBackend) sign(req *http.Request, payload_hash string, now time.Time) {
+	amz_date := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	scope := strings.Join([]string{date, me.opts.Region, "s3", "aws4_request"}, "/")
+
+	req.Header.Set("X-Amz-Date", amz_date)
+	req.Header.Set("X-Amz-Content-Sha256", payload_hash)
+
+	signed_headers := "host;x-amz-content-sha256;x-amz-date"
+	canonical_headers := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payload_hash + "\n" +
+		"x-amz-date:" + amz_date + "\n"
+
+	canonical_request := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonical_headers,
+		signed_headers,
+		payload_hash,
+	}, "\n")
+
+	request_hash := sha256.Sum256([]byte(canonical_request))
+
+	string_to_sign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amz_date,
+		scope,
+		hex.EncodeToString(request_hash[:]),
+	}, "\n")
+
+	key := hmacSha256([]byte("AWS4"+me.opts.SecretKey), date)
+	key = hmacSha256(key, me.opts.Region)
+	key = hmacSha256(key, "s3")
+	key = hmacSha256(key, "aws4_request")
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		me.opts.AccessKey, scope, signed_headers, hex.EncodeToString(hmacSha256(key, string_to_sign))))
+}
+
+func (me *s3BlobBackend) errorf(method string, key string, res *http.Response) error {
+	return fmt.Errorf("s3: %s %s: %s", method, key, res.Status)
+}
+
+func (me *s3BlobReader) Read(p []byte) (n int, err error) {
+	if me.offset >= me.size {
+		return 0, io.EOF
+	}
+
+	if me.body == nil {
+		res, err := me.backend.do("GET", me.key, nil, http.Header{
+			"Range": {fmt.Sprintf("bytes=%d-", me.offset)},
+		}, nil, s3UnsignedPayload)
+
+		if err != nil {
+			return 0, err
+		}
+
+		if res.StatusCode != http.StatusPartialContent && res.StatusCode != http.StatusOK {
+			res.Body.Close()
+
+			return 0, me.backend.errorf("GET", me.key, res)
+		}
+
+		me.body = res.Body
+	}
+
+	n, err = me.body.Read(p)
+	me.offset += int64(n)
+
+	return n, err
+}
+
+func (me *s3BlobReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		break
+	case io.SeekCurrent:
+		offset += me.offset
+		break
+	case io.SeekEnd:
+		offset += me.size
+		break
+	}
+
+	if offset < 0 {
+		return 0, fmt.Errorf("s3: negative seek offset")
+	}
+
+	if offset != me.offset && me.body != nil {
+		me.body.Close()
+		me.body = nil
+	}
+
+	me.offset = offset
+
+	return offset, nil
+}
+
+func (me *s3BlobReader) Close() error {
+	if me.body != nil {
+		return me.body.Close()
+	}
+
+	return nil
+}
+
+func (me *s3BlobReader) Size() int64 {
+	return me.size
+}
+
+func (me *s3BlobReader) ModTime() time.Time {
+	return me.modTime
+}
+
+type sizedReader struct {
+	io.Reader
+
+	size int64
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+
+	return h.Sum(nil)
+}
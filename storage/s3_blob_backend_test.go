@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestS3RequestSignature(t *testing.T) {
+	backend, err := NewS3BlobBackend(S3Options{
+		Endpoint:  "http://localhost:9000",
+		Bucket:    "images",
+		Prefix:    "dsapid",
+		AccessKey: "minio",
+		SecretKey: "minio123",
+	})
+	if err != nil {
+		t.Fatalf("can't create backend: %s", err)
+	}
+
+	req, _ := http.NewRequest("PUT", "http://localhost:9000/images/dsapid/sha1/a1/a17c9aaa61e80a1bf71d0d850af4e5baa9800bbd?partNumber=1&uploadId=abc", nil)
+	now, _ := time.Parse("20060102T150405Z", "20261018T073409Z")
+
+	backend.(*s3BlobBackend).sign(req, s3UnsignedPayload, now)
+
+	const expected = "AWS4-HMAC-SHA256 Credential=minio/20261018/us-east-1/s3/aws4_request, " +
+		"SignedHeaders=host;x-amz-content-sha256;x-amz-date, " +
+		"Signature=94dac93424ced6c9a46a11b13cc9a2a98c1ef1bd36a755ad72f1d22cb84c11fe"
+
+	if v := req.Header.Get("Authorization"); v != expected {
+		t.Errorf("should sign request as\n%s\nbut got\n%s", expected, v)
+	}
+}
@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/MerlinDMC/dsapid"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// weights of a term found in the different manifest fields; a term only
+// matching the prefix of a token counts half
+const (
+	searchWeightName        int = 8
+	searchWeightTag         int = 4
+	searchWeightOwner       int = 2
+	searchWeightDescription int = 1
+	searchWeightHomepage    int = 1
+)
+
+// searchIndex is an inverted index from the tokens of the manifest metadata
+// to the manifests containing them.
+type searchIndex struct {
+	postings map[string]map[string]int
+	// sorted tokens for prefix matches
+	tokens []string
+	// tokens each manifest was indexed under
+	docs map[string][]string
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		postings: make(map[string]map[string]int),
+		tokens:   make([]string, 0),
+		docs:     make(map[string][]string),
+	}
+}
+
+// tokenize splits text into lower case words of at least two characters.
+func tokenize(text string) []string {
+	var tokens []string
+
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len(word) > 1 {
+			tokens = append(tokens, word)
+		}
+	}
+
+	return tokens
+}
+
+func searchTokens(manifest *dsapid.ManifestResource) map[string]int {
+	weights := make(map[string]int)
+
+	add := func(text string, weight int) {
+		for _, token := range tokenize(text) {
+			weights[token] += weight
+		}
+	}
+
+	add(manifest.Name, searchWeightName)
+	add(manifest.Description, searchWeightDescription)
+	add(manifest.Homepage, searchWeightHomepage)
+
+	for k, v := range manifest.Tags {
+		add(k, searchWeightTag)
+		add(fmt.Sprint(v), searchWeightTag)
+	}
+
+	return weights
+}
+
+// add indexes manifest. While bulk loading new tokens are appended and
+// sorted once by sort().
+func (me *searchIndex) add(manifest *dsapid.ManifestResource, bulk bool) {
+	weights := searchTokens(manifest)
+	tokens := make([]string, 0, len(weights))
+
+	for token, weight := range weights {
+		posting, ok := me.postings[token]
+		if !ok {
+			posting = make(map[string]int)
+			me.postings[token] = posting
+
+			if bulk {
+				me.tokens = append(me.tokens, token)
+			} else {
+				i := sort.SearchStrings(me.tokens, token)
+
+				me.tokens = append(me.tokens, "")
+				copy(me.tokens[i+1:], me.tokens[i:])
+				me.tokens[i] = token
+			}
+		}
+
+		posting[manifest.Uuid] = weight
+		tokens = append(tokens, token)
+	}
+
+	me.docs[manifest.Uuid] = tokens
+}
+
+func (me *searchIndex) sort() {
+	sort.Strings(me.tokens)
+}
+
+func (me *searchIndex) remove(id string) {
+	for _, token := range me.docs[id] {
+		delete(me.postings[token], id)
+
+		if len(me.postings[token]) > 0 {
+			continue
+		}
+
+		delete(me.postings, token)
+
+		if i := sort.SearchStrings(me.tokens, token); i < len(me.tokens) && me.tokens[i] == token {
+			me.tokens = append(me.tokens[:i], me.tokens[i+1:]...)
+		}
+	}
+
+	delete(me.docs, id)
+}
+
+// match adds the weight of every manifest containing term to scores.
+func (me *searchIndex) match(term string, scores map[string]int) {
+	for i := sort.SearchStrings(me.tokens, term); i < len(me.tokens) && strings.HasPrefix(me.tokens[i], term); i++ {
+		for id, weight := range me.postings[me.tokens[i]] {
+			scores[id] += searchWeight(weight, me.tokens[i] == term)
+		}
+	}
+}
+
+func searchWeight(weight int, exact bool) int {
+	if exact {
+		return weight * 2
+	}
+
+	return weight
+}
+
+func matchTokens(tokens []string, term string) (matched bool, exact bool) {
+	for _, token := range tokens {
+		if token == term {
+			return true, true
+		} else if strings.HasPrefix(token, term) {
+			matched = true
+		}
+	}
+
+	return matched, false
+}
+
+// Search ranks the manifests containing every word of query by relevance
+// and date. The name of an owner is looked up by owner_name.
+func (me *manifestCache) Search(query string, owner_name func(string) string) []*dsapid.ManifestResource {
+	terms := tokenize(query)
+	if len(terms) == 0 {
+		return make([]*dsapid.ManifestResource, 0)
+	}
+
+	me.lock.RLock()
+	defer me.lock.RUnlock()
+
+	owners := make(map[string][]string)
+
+	for _, owner := range me.index.fields[indexOwner].keys {
+		if name := owner_name(owner); name != "" {
+			owners[owner] = tokenize(name)
+		}
+	}
+
+	scores := make(map[string]int)
+	matches := make(map[string]int)
+
+	for _, term := range terms {
+		term_scores := make(map[string]int)
+
+		me.index.search.match(term, term_scores)
+
+		for owner, tokens := range owners {
+			if matched, exact := matchTokens(tokens, term); matched {
+				for _, manifest := range me.index.fields[indexOwner].values[owner] {
+					term_scores[manifest.Uuid] += searchWeight(searchWeightOwner, exact)
+				}
+			}
+		}
+
+		for id, score := range term_scores {
+			scores[id] += score
+			matches[id]++
+		}
+	}
+
+	results := make([]*dsapid.ManifestResource, 0)
+
+	for id, n := range matches {
+		if n == len(terms) {
+			results = append(results, me.index.manifests[id])
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if a, b := scores[results[i].Uuid], scores[results[j].Uuid]; a != b {
+			return a > b
+		}
+
+		return manifestBefore(results[i], results[j])
+	})
+
+	return results
+}
@@ -5,13 +5,15 @@ import (
 	"github.com/MerlinDMC/dsapid"
 	"io/ioutil"
 	"os"
+	"sync"
+	"time"
 )
 
 type UserStorage interface {
 	Save() error
-	Add(string, *dsapid.UserResource)
-	Update(string, *dsapid.UserResource)
-	Delete(string)
+	Add(string, *dsapid.UserResource) error
+	Update(string, *dsapid.UserResource) error
+	Delete(string) error
 	EnsureExists(string, string) *dsapid.UserResource
 	Get(string) *dsapid.UserResource
 	GetOK(string) (*dsapid.UserResource, bool)
@@ -20,9 +22,15 @@ type UserStorage interface {
 	FindByToken(string) (*dsapid.UserResource, error)
 	Dump() map[string]*dsapid.UserResource
 	GuestUser() *dsapid.UserResource
+	// Generation returns a counter which changes whenever a user is added,
+	// updated or deleted and the time of the last change.
+	Generation() (uint64, time.Time)
 }
 
-func NewUserStorage(filename string) UserStorage {
+// NewUserStorage loads the users from filename. A missing file is fine and
+// results in an empty storage but an unreadable or invalid one is an error
+// as saving over it would wipe every existing user.
+func NewUserStorage(filename string) (UserStorage, error) {
 	store := new(jsonUserStorage)
 
 	store.filename = filename
@@ -32,9 +40,11 @@ func NewUserStorage(filename string) UserStorage {
 	store.map_email_id = make(map[string]string)
 	store.map_token_id = make(map[string]string)
 
-	store.load()
+	if err := store.load(); err != nil && err != ErrStorageFileNotFound {
+		return nil, err
+	}
 
-	return store
+	return store, nil
 }
 
 type jsonUserStorage struct {
@@ -45,26 +55,33 @@ type jsonUserStorage struct {
 	map_name_id  map[string]string
 	map_email_id map[string]string
 	map_token_id map[string]string
+
+	generation_lock sync.Mutex
+	generation      uint64
+	modified        time.Time
 }
 
 func (me *jsonUserStorage) Save() error {
 	return me.save()
 }
 
-func (me *jsonUserStorage) Add(id string, user *dsapid.UserResource) {
+func (me *jsonUserStorage) Add(id string, user *dsapid.UserResource) error {
 	me.add(id, *user)
-	me.save()
+
+	return me.save()
 }
 
-func (me *jsonUserStorage) Update(id string, user *dsapid.UserResource) {
+func (me *jsonUserStorage) Update(id string, user *dsapid.UserResource) error {
 	me.delete(id)
 	me.add(id, *user)
-	me.save()
+
+	return me.save()
 }
 
-func (me *jsonUserStorage) Delete(id string) {
+func (me *jsonUserStorage) Delete(id string) error {
 	me.delete(id)
-	me.save()
+
+	return me.save()
 }
 
 func (me *jsonUserStorage) EnsureExists(id, name string) *dsapid.UserResource {
@@ -131,7 +148,29 @@ func (me *jsonUserStorage) GuestUser() *dsapid.UserResource {
 	}
 }
 
+func (me *jsonUserStorage) Generation() (uint64, time.Time) {
+	me.generation_lock.Lock()
+	defer me.generation_lock.Unlock()
+
+	return me.generation, me.modified
+}
+
+func (me *jsonUserStorage) bump() {
+	me.generation_lock.Lock()
+	defer me.generation_lock.Unlock()
+
+	me.modified = time.Now()
+
+	if me.generation == 0 {
+		me.generation = uint64(me.modified.UnixNano())
+	} else {
+		me.generation++
+	}
+}
+
 func (me *jsonUserStorage) add(id string, user dsapid.UserResource) {
+	me.bump()
+
 	me.users[id] = &user
 
 	if _, ok := me.map_name_id[user.Name]; !ok && user.Name != "" {
@@ -149,6 +188,8 @@ func (me *jsonUserStorage) add(id string, user dsapid.UserResource) {
 
 func (me *jsonUserStorage) delete(id string) {
 	if v, ok := me.users[id]; ok {
+		me.bump()
+
 		delete(me.users, id)
 
 		if _, ok := me.map_name_id[v.Name]; ok {
@@ -184,16 +225,20 @@ func (me *jsonUserStorage) load() error {
 		} else {
 			return ErrStorageFileNotReadable
 		}
-	} else {
+	} else if os.IsNotExist(err) {
 		return ErrStorageFileNotFound
+	} else {
+		return ErrStorageFileNotReadable
 	}
 
+	me.loaded = true
+
 	return nil
 }
 
-func (me *jsonUserStorage) save() (err error) {
+func (me *jsonUserStorage) save() error {
 	if me.filename == "" {
-		return
+		return nil
 	}
 
 	var users []*dsapid.UserResource = make([]*dsapid.UserResource, 0)
@@ -202,9 +247,10 @@ func (me *jsonUserStorage) save() (err error) {
 		users = append(users, u)
 	}
 
-	if data, err := json.MarshalIndent(users, "", "  "); err == nil {
-		err = ioutil.WriteFile(me.filename, data, 0660)
+	data, err := json.MarshalIndent(users, "", "  ")
+	if err != nil {
+		return err
 	}
 
-	return err
+	return writeFileAtomic(me.filename, data, 0660)
 }
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"github.com/MerlinDMC/dsapid"
+	"io/ioutil"
 	"os"
 	"testing"
 )
@@ -11,7 +12,12 @@ var (
 )
 
 func TestNewUserStorage(t *testing.T) {
-	storage := NewUserStorage(storageFilename).(*jsonUserStorage)
+	v, err := NewUserStorage(storageFilename)
+	if err != nil {
+		t.Fatalf("should load user storage but got: %s", err)
+	}
+
+	storage := v.(*jsonUserStorage)
 
 	user1 := &dsapid.UserResource{
 		Uuid:  "test1",
@@ -56,7 +62,12 @@ func TestNewUserStorage(t *testing.T) {
 }
 
 func TestLoadStorage(t *testing.T) {
-	storage := NewUserStorage(storageFilename).(*jsonUserStorage)
+	v, err := NewUserStorage(storageFilename)
+	if err != nil {
+		t.Fatalf("should load user storage but got: %s", err)
+	}
+
+	storage := v.(*jsonUserStorage)
 
 	storage.Get("") // dummy get to trigger load()
 
@@ -76,3 +87,31 @@ func TestLoadStorage(t *testing.T) {
 		t.Errorf("should have mapped token:test1token to test1 but got %s", v)
 	}
 }
+
+func TestInvalidStorageFileIsRejected(t *testing.T) {
+	filename := os.TempDir() + "/users-invalid.json"
+	defer os.Remove(filename)
+
+	if err := ioutil.WriteFile(filename, []byte("[{\"uuid\": "), 0660); err != nil {
+		t.Fatalf("can't write test file: %s", err)
+	}
+
+	if _, err := NewUserStorage(filename); err != ErrStorageFileInvalid {
+		t.Errorf("should reject invalid storage file but got %v", err)
+	}
+
+	if data, _ := ioutil.ReadFile(filename); string(data) != "[{\"uuid\": " {
+		t.Errorf("should leave invalid storage file untouched but got %q", data)
+	}
+}
+
+func TestSaveReportsWriteErrors(t *testing.T) {
+	v, err := NewUserStorage(os.TempDir() + "/missing-dir/users.json")
+	if err != nil {
+		t.Fatalf("should accept a missing storage file but got: %s", err)
+	}
+
+	if err := v.Add("test1", &dsapid.UserResource{Uuid: "test1", Name: "test1"}); err == nil {
+		t.Error("should report write error on unwritable storage file")
+	}
+}
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"github.com/MerlinDMC/dsapid"
+	"io"
+)
+
+// IntegrityError reports a file which is missing or differs from the size
+// and checksums recorded in its manifest.
+type IntegrityError struct {
+	Path   string
+	Reason string
+}
+
+func (me *IntegrityError) Error() string {
+	return fmt.Sprintf("%s: %s", me.Path, me.Reason)
+}
+
+// VerifyManifestFiles re-hashes the files of manifest and compares size and
+// checksums with the values recorded in the manifest. Broken files are
+// reported as *IntegrityError, any other error means the files couldn't be
+// checked.
+func VerifyManifestFiles(manifests ManifestStorage, manifest *dsapid.ManifestResource) error {
+	for i := range manifest.Files {
+		reason, err := verifyManifestFile(manifests, manifest, &manifest.Files[i])
+		if err != nil {
+			return fmt.Errorf("%s: %s", manifest.Files[i].Path, err)
+		}
+
+		if reason != "" {
+			return &IntegrityError{
+				Path:   manifest.Files[i].Path,
+				Reason: reason,
+			}
+		}
+	}
+
+	return nil
+}
+
+func verifyManifestFile(manifests ManifestStorage, manifest *dsapid.ManifestResource, file *dsapid.ManifestFileResource) (string, error) {
+	fin, err := manifests.OpenFile(manifest, file)
+	if err != nil {
+		if IsFileNotFound(err) {
+			return "file missing", nil
+		}
+
+		return "", err
+	}
+	defer fin.Close()
+
+	hash_md5 := md5.New()
+	hash_sha1 := sha1.New()
+
+	size, err := io.Copy(io.MultiWriter(hash_md5, hash_sha1), fin)
+	if err != nil {
+		return "", err
+	}
+
+	if file.Size != 0 && size != file.Size {
+		return fmt.Sprintf("size mismatch: got %d expected %d", size, file.Size), nil
+	}
+
+	if sum := hex.EncodeToString(hash_md5.Sum(nil)); file.Md5 != "" && sum != file.Md5 {
+		return fmt.Sprintf("md5 mismatch: got %s expected %s", sum, file.Md5), nil
+	}
+
+	if sum := hex.EncodeToString(hash_sha1.Sum(nil)); file.Sha1 != "" && sum != file.Sha1 {
+		return fmt.Sprintf("sha1 mismatch: got %s expected %s", sum, file.Sha1), nil
+	}
+
+	return "", nil
+}
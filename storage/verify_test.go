@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"github.com/MerlinDMC/dsapid"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestVerifyManifestFiles(t *testing.T) {
+	storage, basedir := newTestManifestStorage(t)
+	defer os.RemoveAll(basedir)
+
+	m := newTestManifest("base", time.Now())
+	m.Files = []dsapid.ManifestFileResource{{
+		Path: "base-1.0.0.zfs.gz",
+		Size: 4,
+		Md5:  "8d777f385d3dfec8815d20f7496026dc",
+		Sha1: "a17c9aaa61e80a1bf71d0d850af4e5baa9800bbd",
+	}}
+
+	if f, err := storage.CreateFile(m, &m.Files[0]); err == nil {
+		f.Write([]byte("data"))
+		f.Close()
+	}
+
+	storage.Add(m.Uuid, m)
+
+	if err := VerifyManifestFiles(storage, m); err != nil {
+		t.Errorf("should verify intact file but got %s", err)
+	}
+
+	m.Files[0].Md5 = "00000000000000000000000000000000"
+
+	if err := VerifyManifestFiles(storage, m); err == nil {
+		t.Error("should report md5 mismatch")
+	}
+
+	m.Files[0].Md5 = ""
+	m.Files[0].Size = 5
+
+	if err := VerifyManifestFiles(storage, m); err == nil {
+		t.Error("should report size mismatch")
+	}
+}
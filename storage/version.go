@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"github.com/MerlinDMC/dsapid"
+	"strings"
+)
+
+// CompareVersions orders image versions returning -1, 0 or 1. Versions are
+// compared field by field like semver ("1.10.0" > "1.9.2", "1.0.0-rc.1" <
+// "1.0.0", build metadata is ignored) which also covers the date-style
+// versions ("15.4.1", "20160302") used by SmartOS images. Fields which are
+// not numeric are compared in natural order.
+func CompareVersions(a, b string) int {
+	a_core, a_pre := splitVersion(a)
+	b_core, b_pre := splitVersion(b)
+
+	if c := compareVersionFields(strings.Split(a_core, "."), strings.Split(b_core, "."), false); c != 0 {
+		return c
+	}
+
+	switch {
+	case a_pre == b_pre:
+		return 0
+	case a_pre == "":
+		return 1
+	case b_pre == "":
+		return -1
+	}
+
+	return compareVersionFields(strings.Split(a_pre, "."), strings.Split(b_pre, "."), true)
+}
+
+// VersionHasPrefix reports whether the leading fields of version equal the
+// fields of prefix, so "1.1" matches "1.1.3" but not "1.10.0". A prefix
+// with a pre-release has to match the whole version. A prefix of a single
+// field is matched as a string so "2016" still finds date versions like
+// "20160302".
+func VersionHasPrefix(version, prefix string) bool {
+	prefix_core, prefix_pre := splitVersion(prefix)
+	if prefix_pre != "" {
+		return CompareVersions(version, prefix) == 0
+	}
+
+	if !strings.Contains(prefix_core, ".") {
+		return strings.HasPrefix(version, prefix)
+	}
+
+	core, _ := splitVersion(version)
+	fields, prefix_fields := strings.Split(core, "."), strings.Split(prefix_core, ".")
+
+	if len(fields) > len(prefix_fields) {
+		fields = fields[:len(prefix_fields)]
+	}
+
+	return compareVersionFields(fields, prefix_fields, false) == 0
+}
+
+// NewerManifest reports whether a is a newer version than b. Equal versions
+// are decided by the publishing date.
+func NewerManifest(a, b *dsapid.ManifestResource) bool {
+	if c := CompareVersions(a.Version, b.Version); c != 0 {
+		return c > 0
+	}
+
+	return a.PublishedAt.After(b.PublishedAt)
+}
+
+// LatestByName returns the newest version of each name read from c.
+func LatestByName(c chan *dsapid.ManifestResource) map[string]*dsapid.ManifestResource {
+	latest := make(map[string]*dsapid.ManifestResource)
+
+	for manifest := range c {
+		if current, ok := latest[manifest.Name]; !ok || NewerManifest(manifest, current) {
+			latest[manifest.Name] = manifest
+		}
+	}
+
+	return latest
+}
+
+func splitVersion(version string) (core string, pre string) {
+	if len(version) > 1 && (version[0] == 'v' || version[0] == 'V') && isDigit(version[1]) {
+		version = version[1:]
+	}
+
+	if i := strings.Index(version, "+"); i >= 0 {
+		version = version[:i]
+	}
+
+	if i := strings.Index(version, "-"); i >= 0 {
+		return version[:i], version[i+1:]
+	}
+
+	return version, ""
+}
+
+// compareVersionFields treats missing fields of a version core as 0 while
+// a shorter pre-release sorts first.
+func compareVersionFields(a, b []string, prerelease bool) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		if prerelease && i >= len(a) {
+			return -1
+		} else if prerelease && i >= len(b) {
+			return 1
+		}
+
+		x, y := "0", "0"
+
+		if i < len(a) {
+			x = a[i]
+		}
+
+		if i < len(b) {
+			y = b[i]
+		}
+
+		if c := compareVersionField(x, y); c != 0 {
+			return c
+		}
+	}
+
+	return 0
+}
+
+// compareVersionField compares numbers numerically and sorts them before
+// anything else; other values are compared in natural order.
+func compareVersionField(a, b string) int {
+	a_num, b_num := isNumeric(a), isNumeric(b)
+
+	switch {
+	case a_num && b_num:
+		return compareNumbers(a, b)
+	case a_num:
+		return -1
+	case b_num:
+		return 1
+	}
+
+	for a != "" && b != "" {
+		var x, y string
+
+		x, a = nextChunk(a)
+		y, b = nextChunk(b)
+
+		var c int
+
+		if isNumeric(x) && isNumeric(y) {
+			c = compareNumbers(x, y)
+		} else {
+			c = strings.Compare(x, y)
+		}
+
+		if c != 0 {
+			return c
+		}
+	}
+
+	return strings.Compare(a, b)
+}
+
+// nextChunk splits off the leading run of digits or non-digits.
+func nextChunk(s string) (string, string) {
+	i := 1
+
+	for i < len(s) && isDigit(s[i]) == isDigit(s[0]) {
+		i++
+	}
+
+	return s[:i], s[i:]
+}
+
+func compareNumbers(a, b string) int {
+	a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+
+	if len(a) != len(b) {
+		if len(a) < len(b) {
+			return -1
+		}
+
+		return 1
+	}
+
+	return strings.Compare(a, b)
+}
+
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for i := 0; i < len(s); i++ {
+		if !isDigit(s[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"1.0", "1.0.0", 0},
+		{"v1.2.0", "1.2.0", 0},
+		{"1.10.0", "1.9.2", 1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.0.0-rc.2", "1.0.0-rc.10", -1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.beta", "1.0.0-beta", -1},
+		{"1.0.0+build.5", "1.0.0", 0},
+		{"15.4.1", "15.4.0", 1},
+		{"16.1.0", "15.4.1", 1},
+		{"20160302", "20151214", 1},
+		{"2.4.1a", "2.4.1", 1},
+		{"1.2.rc10", "1.2.rc9", 1},
+	}
+
+	for _, test := range tests {
+		if c := CompareVersions(test.a, test.b); c != test.expected {
+			t.Errorf("CompareVersions(%q, %q) should be %d but got %d", test.a, test.b, test.expected, c)
+		}
+
+		if c := CompareVersions(test.b, test.a); c != -test.expected {
+			t.Errorf("CompareVersions(%q, %q) should be %d but got %d", test.b, test.a, -test.expected, c)
+		}
+	}
+}
+
+func TestVersionHasPrefix(t *testing.T) {
+	tests := []struct {
+		version, prefix string
+		expected        bool
+	}{
+		{"1.1.3", "1.1", true},
+		{"1.1", "1.1", true},
+		{"1.1", "1.1.0", true},
+		{"1.10.0", "1.1", false},
+		{"1.1.0-rc.1", "1.1", true},
+		{"1.1.0-rc.1", "1.1.0-rc.1", true},
+		{"1.1.0", "1.1.0-rc.1", false},
+		{"15.4.1", "15", true},
+		{"20160302", "2016", true},
+		{"20160302", "201603", true},
+		{"20150302", "2016", false},
+		{"16.1.0", "1", true},
+	}
+
+	for _, test := range tests {
+		if got := VersionHasPrefix(test.version, test.prefix); got != test.expected {
+			t.Errorf("VersionHasPrefix(%q, %q) should be %t but got %t", test.version, test.prefix, test.expected, got)
+		}
+	}
+}
@@ -13,6 +13,8 @@ type SyncProvider string
 type CompressionType string
 type ManifestState string
 type ManifestType string
+type StorageType string
+type FileBackendType string
 
 type UserResource struct {
 	Uuid     string         `json:"uuid"`
@@ -68,9 +70,41 @@ type SyncSourceResource struct {
 	Source     string       `json:"source,omitempty"`
 	FileSource string       `json:"file_source,omitempty"`
 	Delay      string       `json:"delay"`
+	PageSize   int          `json:"page_size,omitempty"`
 	Opts       Table        `json:"opts,omitempty"`
 }
 
+// ChannelResource declares a channel images can be published to.
+type ChannelResource struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Default     bool   `json:"default,omitempty"`
+}
+
+type Channels []ChannelResource
+
+func (me Channels) Get(name string) (ChannelResource, bool) {
+	for _, channel := range me {
+		if channel.Name == name {
+			return channel, true
+		}
+	}
+
+	return ChannelResource{}, false
+}
+
+// Default returns the channel listed when no channel is asked for. Images
+// without channels belong to it.
+func (me Channels) Default() (ChannelResource, bool) {
+	for _, channel := range me {
+		if channel.Default {
+			return channel, true
+		}
+	}
+
+	return ChannelResource{}, false
+}
+
 type ManifestResource struct {
 	Uuid     string       `json:"uuid"`
 	Provider SyncProvider `json:"provider,omitempty"`
@@ -90,14 +124,20 @@ type ManifestResource struct {
 	Type ManifestType `json:"type"`
 	Os   string       `json:"os"`
 
-	// TODO: acl
+	// uuids of the users a private image is shared with
+	Acl []string `json:"acl,omitempty"`
+
 	PublishedAt time.Time `json:"published_at"`
 	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
 
-	Requirements Table   `json:"requirements"`
-	Users        []Table `json:"users,omitempty"`
-	Tags         Table   `json:"tags,omitempty"`
-	Options      Table   `json:"options,omitempty"`
+	Requirements Table    `json:"requirements"`
+	Users        []Table  `json:"users,omitempty"`
+	Tags         Table    `json:"tags,omitempty"`
+	BillingTags  []string `json:"billing_tags,omitempty"`
+	Channels     []string `json:"channels,omitempty"`
+	Icon         bool     `json:"icon,omitempty"`
+	Options      Table    `json:"options,omitempty"`
 
 	MetadataInfo []Table `json:"metadata_info"`
 	BuilderInfo  Table   `json:"builder_info"`
@@ -135,6 +175,8 @@ const (
 
 	DefaultSyncDelay string = "8h"
 
+	MaxIconSize int64 = 128 * 1024
+
 	SyncTypeDsapi  SyncType = "dsapi"
 	SyncTypeImgapi SyncType = "imgapi"
 
@@ -145,12 +187,13 @@ const (
 	SyncProviderTesting   SyncProvider = "testing"
 	SyncProviderUnknown   SyncProvider = "unknown"
 
-	ManifestStatePending    ManifestState = "pending"
-	ManifestStateActive     ManifestState = "active"
-	ManifestStateInactive   ManifestState = "unactivated"
-	ManifestStateDisabled   ManifestState = "disabled"
-	ManifestStateDeprecated ManifestState = "deprecated"
-	ManifestStateNuked      ManifestState = "nuked"
+	ManifestStatePending     ManifestState = "pending"
+	ManifestStateActive      ManifestState = "active"
+	ManifestStateInactive    ManifestState = "unactivated"
+	ManifestStateDisabled    ManifestState = "disabled"
+	ManifestStateDeprecated  ManifestState = "deprecated"
+	ManifestStateNuked       ManifestState = "nuked"
+	ManifestStateQuarantined ManifestState = "quarantined"
 
 	ManifestTypeZone ManifestType = "zone-dataset"
 	ManifestTypeLx   ManifestType = "lx-dataset"
@@ -160,6 +203,12 @@ const (
 	CompressionTypeBzip2 CompressionType = "bzip2"
 	CompressionTypeXz    CompressionType = "xz"
 	CompressionTypeNone  CompressionType = "none"
+
+	StorageTypeFilesystem StorageType = "filesystem"
+	StorageTypeBolt       StorageType = "bolt"
+
+	FileBackendTypeLocal FileBackendType = "local"
+	FileBackendTypeS3    FileBackendType = "s3"
 )
 
 var (
@@ -181,16 +230,18 @@ var (
 	}
 
 	ManifestStateDescription = map[ManifestState]string{
-		ManifestStatePending:    "Pending",
-		ManifestStateActive:     "Active",
-		ManifestStateInactive:   "Not activated",
-		ManifestStateDisabled:   "Disabled",
-		ManifestStateDeprecated: "Deprecated",
-		ManifestStateNuked:      "Nuked",
+		ManifestStatePending:     "Pending",
+		ManifestStateActive:      "Active",
+		ManifestStateInactive:    "Not activated",
+		ManifestStateDisabled:    "Disabled",
+		ManifestStateDeprecated:  "Deprecated",
+		ManifestStateNuked:       "Nuked",
+		ManifestStateQuarantined: "Quarantined",
 	}
 
 	ManifestTypeDescription = map[ManifestType]string{
 		ManifestTypeZone: "zone dataset",
+		ManifestTypeLx:   "lx-branded zone dataset",
 		ManifestTypeZvol: "KVM volume",
 	}
 